@@ -9,6 +9,10 @@ DIRS = {
     "SERVER" : f"{GIT_ROOT}/server",
     "SHARED" : f"{GIT_ROOT}/shared",
     "CLIENT" : f"{GIT_ROOT}/client",
+    "DISASM" : f"{GIT_ROOT}/disasm",
+    "ASMFMT" : f"{GIT_ROOT}/asmfmt",
+    "ASMLSP" : f"{GIT_ROOT}/asmlsp",
+    "COREVIEW" : f"{GIT_ROOT}/coreview",
     "DEPLOY" : f"{GIT_ROOT}/deploy",
     "BUILD" : f"{GIT_ROOT}/build",
 }
@@ -25,6 +29,10 @@ def build() -> bool:
         (DIRS["ROUTER"], "router"),
         (DIRS["SERVER"], "server"),
         (DIRS["CLIENT"], "client"),
+        (DIRS["DISASM"], "disasm"),
+        (DIRS["ASMFMT"], "asmfmt"),
+        (DIRS["ASMLSP"], "asmlsp"),
+        (DIRS["COREVIEW"], "coreview"),
     ]
 
     def build_dir(route_exec):
@@ -59,6 +67,10 @@ def test() -> bool:
     b.shell_pass(f"go test {DIRS["ROUTER"]}/... -v")
     b.shell_pass(f"go test {DIRS["SERVER"]}/... -v")
     b.shell_pass(f"go test {DIRS["CLIENT"]}/... -v")
+    b.shell_pass(f"go test {DIRS["DISASM"]}/... -v")
+    b.shell_pass(f"go test {DIRS["ASMFMT"]}/... -v")
+    b.shell_pass(f"go test {DIRS["ASMLSP"]}/... -v")
+    b.shell_pass(f"go test {DIRS["COREVIEW"]}/... -v")
     b.shell_pass(f"go test {DIRS["SHARED"]}/... -v")
 
     return True
@@ -1,31 +1,97 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"tcp-vm/shared/assembler"
+	"tcp-vm/shared/linker"
 	"tcp-vm/shared/vm"
 )
 
 func main() {
-	if len(os.Args) != 2 {
-		fmt.Printf("usage: %s [path to `.asm` file]\n", os.Args[0])
+	var includes assembler.IncludeFlag
+	flag.Var(&includes, "I", "directory to search for .include files, may be repeated")
+	defs := assembler.DefineFlag{}
+	flag.Var(defs, "D", "define a constant for .if and .ifdef, `name=value` or just name for 1, may be repeated")
+	listing := flag.String("list", "", "write a listing of the assembled program to this file")
+	optimize := flag.Bool("O", false, "run the peephole optimizer and report the bytes it saved")
+	lint := flag.Bool("lint", false, "also warn about unused labels, unreachable code and other likely mistakes")
+	objects := flag.Bool("c", false, "only assemble each file to a relocatable `.o` object next to it")
+	flag.Usage = func() {
+		fmt.Printf("usage: %s [-I dir]... [-D name=value]... [-O] [-lint] [-list file] [-c] [path to `.asm` or `.o` file]...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
 		os.Exit(1)
 	}
 
-	path := os.Args[1]
+	opts := assembler.Options{
+		IncludePaths: includes,
+		Defines:      defs,
+		Optimize:     *optimize,
+		Lint:         *lint,
+	}
+	if *objects {
+		for _, path := range flag.Args() {
+			if err := writeObject(path, opts); err != nil {
+				fmt.Printf("assembler error: %s\n", assembler.Explain(err))
+				os.Exit(1)
+			}
+		}
+		return
+	}
 
-	data, text, err := assembler.Assemble(path)
+	var prog *assembler.Program
+	var err error
+	// several files or any object are assembled separately and linked
+	if flag.NArg() == 1 && !strings.HasSuffix(flag.Arg(0), ".o") {
+		prog, err = assembler.AssembleFile(flag.Arg(0), opts)
+	} else if *listing != "" {
+		err = fmt.Errorf("-list needs a single source file, linked programs have no listing")
+	} else {
+		prog, err = linker.LinkFiles(flag.Args(), opts)
+	}
 	if err != nil {
-		fmt.Printf("assembler error: %v\n", err)
+		fmt.Printf("assembler error: %s\n", assembler.Explain(err))
 		os.Exit(1)
 	}
+	for _, w := range prog.Warnings {
+		fmt.Printf("assembler warning: %v\n", w)
+	}
+	if *optimize {
+		for _, o := range prog.Optimizations {
+			fmt.Printf("optimized %v\n", o)
+		}
+		fmt.Printf("optimizer saved %d bytes\n", prog.Saved())
+	}
+	if *listing != "" {
+		f, err := os.Create(*listing)
+		if err == nil {
+			err = prog.WriteListing(f)
+			f.Close()
+		}
+		if err != nil {
+			fmt.Printf("listing error: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
 	v := new(vm.VirtualMachine)
-	v.ResetFromStateless(data, text)
+	v.ResetFromStateless(prog.Data, prog.Text, prog.Entry)
 
 	err = v.RunUntilStop()
 	if err != nil {
+		var f *vm.Fault
+		if errors.As(err, &f) {
+			err = fmt.Errorf("%w at %s", err, prog.DebugInfo().Describe(uint8(f.PC)))
+		}
 		fmt.Printf("error in vm: %v\n", err)
 		os.Exit(1)
 	}
@@ -36,3 +102,20 @@ func main() {
 	sys := v.Memory[vm.FlagStart]
 	fmt.Printf("sys: %d, arg: %d\n", sys, arg)
 }
+
+// writeObject assembles path to a relocatable object, `prog.asm` is written to
+// `prog.o`
+func writeObject(path string, opts assembler.Options) error {
+	obj, err := assembler.AssembleObjectFile(path, opts)
+	if err != nil {
+		return err
+	}
+	for _, w := range obj.Warnings {
+		fmt.Printf("assembler warning: %v\n", w)
+	}
+	raw, err := obj.Marshal()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(strings.TrimSuffix(path, filepath.Ext(path))+".o", raw, 0o644)
+}
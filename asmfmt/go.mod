@@ -0,0 +1,3 @@
+module tcp-vm/asmfmt
+
+go 1.24.0
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"tcp-vm/shared/assembler"
+)
+
+// sources expands the arguments into files, a directory stands for every
+// `.asm` file under it
+func sources(args []string) ([]string, error) {
+	var files []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		err = filepath.WalkDir(arg, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() && strings.HasSuffix(path, ".asm") {
+				files = append(files, path)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// firstChange is the first line that formatting changes, counted from 1
+func firstChange(before []byte, after []byte) int {
+	a := strings.Split(string(before), "\n")
+	b := strings.Split(string(after), "\n")
+	for i := range min(len(a), len(b)) {
+		if a[i] != b[i] {
+			return i + 1
+		}
+	}
+	return min(len(a), len(b)) + 1
+}
+
+func main() {
+	write := flag.Bool("w", false, "write the result back to the file instead of printing it")
+	list := flag.Bool("l", false, "list the files whose formatting differs instead of printing them")
+	check := flag.Bool("check", false, "only check, list files that are not formatted and exit with 1 if there are any")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [-w] [-l] [-check] [file.asm | dir]...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	// no files formats stdin to stdout
+	if flag.NArg() == 0 {
+		src, err := io.ReadAll(os.Stdin)
+		if err == nil {
+			var out []byte
+			if out, err = assembler.Format("<stdin>", src); err == nil {
+				_, err = os.Stdout.Write(out)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "asmfmt: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	files, err := sources(flag.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "asmfmt: %v\n", err)
+		os.Exit(1)
+	}
+
+	failed, unformatted := false, false
+	for _, path := range files {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "asmfmt: %v\n", err)
+			failed = true
+			continue
+		}
+		out, err := assembler.Format(path, src)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "asmfmt: %v\n", err)
+			failed = true
+			continue
+		}
+		changed := !bytes.Equal(src, out)
+
+		switch {
+		case *check:
+			if changed {
+				fmt.Printf("%s:%d: not formatted\n", path, firstChange(src, out))
+				unformatted = true
+			}
+		case *write:
+			if !changed {
+				break
+			}
+			if *list {
+				fmt.Println(path)
+			}
+			if err := os.WriteFile(path, out, 0o644); err != nil {
+				fmt.Fprintf(os.Stderr, "asmfmt: %v\n", err)
+				failed = true
+			}
+		case *list:
+			if changed {
+				fmt.Println(path)
+			}
+		default:
+			os.Stdout.Write(out)
+		}
+	}
+
+	if failed {
+		os.Exit(2)
+	}
+	if unformatted {
+		os.Exit(1)
+	}
+}
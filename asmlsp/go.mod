@@ -0,0 +1,3 @@
+module tcp-vm/asmlsp
+
+go 1.24.0
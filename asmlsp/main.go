@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"tcp-vm/shared/assembler"
+)
+
+func main() {
+	var includes assembler.IncludeFlag
+	flag.Var(&includes, "I", "directory to search for .include files, may be repeated")
+	defs := assembler.DefineFlag{}
+	flag.Var(defs, "D", "define a constant for .if and .ifdef, `name=value` or just name for 1, may be repeated")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [-I dir]... [-D name=value]...\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "speaks the Language Server Protocol on stdin and stdout")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	s := newServer(os.Stdout, assembler.Options{
+		IncludePaths: includes,
+		Defines:      defs,
+		Lint:         true,
+	})
+	err := s.serve(os.Stdin)
+	switch {
+	case errors.Is(err, errExit) && s.shutdown:
+		return
+	case errors.Is(err, errExit), errors.Is(err, io.EOF):
+		// the client went away without shutting down
+		os.Exit(1)
+	default:
+		fmt.Fprintf(os.Stderr, "asmlsp: %v\n", err)
+		os.Exit(1)
+	}
+}
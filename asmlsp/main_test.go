@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tcp-vm/shared/assembler"
+)
+
+// session sends msgs to a server and returns everything it wrote, by request
+// ID and by notification method
+func session(t *testing.T, msgs ...any) (map[string]json.RawMessage, map[string][]json.RawMessage) {
+	t.Helper()
+	var in, out bytes.Buffer
+	for _, m := range msgs {
+		if err := writeMessage(&in, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := newServer(&out, assembler.Options{Lint: true})
+	if err := s.serve(&in); !errors.Is(err, errExit) || !s.shutdown {
+		t.Fatalf("serve() = %v, shutdown %v", err, s.shutdown)
+	}
+
+	results := map[string]json.RawMessage{}
+	notes := map[string][]json.RawMessage{}
+	r := bufio.NewReader(&out)
+	for {
+		body, err := readMessage(r)
+		if err != nil {
+			break
+		}
+		var m struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+			Result json.RawMessage `json:"result"`
+			Error  *responseError  `json:"error"`
+		}
+		if err := json.Unmarshal(body, &m); err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case m.Error != nil:
+			t.Fatalf("request %s failed: %s", m.ID, m.Error.Message)
+		case m.Method != "":
+			notes[m.Method] = append(notes[m.Method], m.Params)
+		default:
+			results[string(m.ID)] = m.Result
+		}
+	}
+	return results, notes
+}
+
+func request(id int, method string, params any) map[string]any {
+	return map[string]any{"jsonrpc": "2.0", "id": id, "method": method, "params": params}
+}
+
+func notice(method string, params any) map[string]any {
+	return map[string]any{"jsonrpc": "2.0", "method": method, "params": params}
+}
+
+func Test_server(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prog.asm")
+	uri := "file://" + filepath.ToSlash(path)
+	src := `.data
+count = 0x03
+.text
+main:
+	LDA R0, count
+.loop:
+	DEC R0
+	JMP 001, .loop
+	EXIT 0
+`
+	doc := map[string]any{"uri": uri}
+	at := func(line, char int) map[string]any {
+		return map[string]any{"textDocument": doc, "position": map[string]any{"line": line, "character": char}}
+	}
+	refs := at(5, 1)
+	refs["context"] = map[string]any{"includeDeclaration": true}
+
+	results, notes := session(t,
+		request(1, "initialize", map[string]any{}),
+		notice("initialized", map[string]any{}),
+		notice("textDocument/didOpen", map[string]any{
+			"textDocument": map[string]any{"uri": uri, "languageId": "asm", "version": 1, "text": src},
+		}),
+		request(2, "textDocument/definition", at(7, 11)),
+		request(3, "textDocument/references", refs),
+		request(4, "textDocument/hover", at(4, 2)),
+		request(5, "textDocument/hover", at(4, 10)),
+		request(6, "textDocument/completion", at(6, 1)),
+		request(7, "textDocument/completion", at(7, 5)),
+		request(8, "textDocument/documentSymbol", map[string]any{"textDocument": doc}),
+		notice("textDocument/didChange", map[string]any{
+			"textDocument":   map[string]any{"uri": uri, "version": 2},
+			"contentChanges": []map[string]any{{"text": ".text\nmain:\n\tLDI R0, missing\n"}},
+		}),
+		request(9, "shutdown", nil),
+		notice("exit", nil),
+	)
+
+	var diags []publishDiagnosticsParams
+	for _, raw := range notes["textDocument/publishDiagnostics"] {
+		var p publishDiagnosticsParams
+		json.Unmarshal(raw, &p)
+		diags = append(diags, p)
+	}
+	if len(diags) != 2 || len(diags[0].Diagnostics) != 0 {
+		t.Fatalf("expected a clean program then one with an error, got %+v", diags)
+	}
+	if d := diags[1].Diagnostics; len(d) != 1 || d[0].Severity != severityError || d[0].Range.Start != (position{Line: 2, Character: 1}) ||
+		!strings.Contains(d[0].Message, "missing") {
+		t.Fatalf("unexpected diagnostics after the change %+v", d)
+	}
+
+	var def []location
+	json.Unmarshal(results["2"], &def)
+	want := location{URI: uri, Range: span{Start: position{Line: 5, Character: 0}, End: position{Line: 5, Character: 5}}}
+	if len(def) != 1 || def[0] != want {
+		t.Fatalf("definition of .loop = %+v, want %+v", def, want)
+	}
+
+	var uses []location
+	json.Unmarshal(results["3"], &uses)
+	if len(uses) != 2 || uses[1].Range.Start != (position{Line: 7, Character: 10}) {
+		t.Fatalf("references of .loop = %+v", uses)
+	}
+
+	var h hover
+	json.Unmarshal(results["4"], &h)
+	if !strings.Contains(h.Contents.Value, "LDA ra, addr") || !strings.Contains(h.Contents.Value, "0x51  E0 00  LDA R0, count") {
+		t.Fatalf("hover on LDA = %s", h.Contents.Value)
+	}
+	json.Unmarshal(results["5"], &h)
+	if !strings.Contains(h.Contents.Value, "data `count` at `0x00`, holds `0x03`") {
+		t.Fatalf("hover on count = %s", h.Contents.Value)
+	}
+
+	labels := func(raw json.RawMessage) map[string]bool {
+		var items []completionItem
+		json.Unmarshal(raw, &items)
+		seen := map[string]bool{}
+		for _, item := range items {
+			seen[item.Label] = true
+		}
+		return seen
+	}
+	if seen := labels(results["6"]); !seen["MOV"] || !seen["JMPA"] || !seen[".byte"] || seen["R0"] {
+		t.Fatalf("expected mnemonics at the start of a line, got %v", seen)
+	}
+	if seen := labels(results["7"]); !seen["R0"] || !seen["SP"] || !seen["count"] || !seen[".loop"] || seen["MOV"] {
+		t.Fatalf("expected registers and names for an operand, got %v", seen)
+	}
+
+	var outline []documentSymbol
+	json.Unmarshal(results["8"], &outline)
+	if len(outline) != 2 || outline[0].Name != "count" || outline[1].Name != "main" ||
+		len(outline[1].Children) != 1 || outline[1].Children[0].Name != "main.loop" {
+		t.Fatalf("unexpected document symbols %+v", outline)
+	}
+}
+
+func Test_readMessage(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("Content-Type: x\r\ncontent-length: 2\r\n\r\n{}Content-Length: 5\r\n\r\n{}"))
+	body, err := readMessage(r)
+	if err != nil || string(body) != "{}" {
+		t.Fatalf("readMessage() = %q, %v", body, err)
+	}
+	if _, err := readMessage(r); err == nil {
+		t.Fatal("expected an error for a body shorter than its Content-Length")
+	}
+	if _, err := readMessage(bufio.NewReader(strings.NewReader("\r\n{}"))); err == nil {
+		t.Fatal("expected an error for a message without a Content-Length")
+	}
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"tcp-vm/shared/assembler"
+)
+
+// errExit ends serve when the client sends exit
+var errExit = errors.New("exit")
+
+// document is an open file, analysed again whenever it changes
+type document struct {
+	uri string
+	// name given to the assembler, the file path for file URIs
+	path     string
+	lines    []string
+	analysis *assembler.Analysis
+}
+
+type server struct {
+	out      io.Writer
+	opts     assembler.Options
+	docs     map[string]*document // by URI
+	shutdown bool                 // the client asked to shut down, exit is clean
+}
+
+func newServer(out io.Writer, opts assembler.Options) *server {
+	return &server{out: out, opts: opts, docs: map[string]*document{}}
+}
+
+// serve handles messages from r until the client sends exit or r ends
+func (s *server) serve(r io.Reader) error {
+	in := bufio.NewReader(r)
+	for {
+		body, err := readMessage(in)
+		if err != nil {
+			return err
+		}
+		var msg message
+		if err := json.Unmarshal(body, &msg); err != nil {
+			if err := s.fail(nil, codeParseError, err.Error()); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// handle answers a request or acts on a notification, only a failure to write
+// or exit end the session
+func (s *server) handle(msg message) error {
+	var result any
+	var err error
+	switch msg.Method {
+	case "initialize":
+		result = map[string]any{
+			"capabilities": map[string]any{
+				// the whole text is sent on every change
+				"textDocumentSync":       1,
+				"definitionProvider":     true,
+				"referencesProvider":     true,
+				"hoverProvider":          true,
+				"documentSymbolProvider": true,
+				"completionProvider": map[string]any{
+					"triggerCharacters": []string{"."},
+				},
+			},
+			"serverInfo": map[string]any{"name": "asmlsp"},
+		}
+	case "shutdown":
+		s.shutdown = true
+	case "exit":
+		return errExit
+
+	case "textDocument/didOpen":
+		var p didOpenParams
+		if err = json.Unmarshal(msg.Params, &p); err == nil {
+			return s.update(p.TextDocument.URI, p.TextDocument.Text)
+		}
+	case "textDocument/didChange":
+		var p didChangeParams
+		if err = json.Unmarshal(msg.Params, &p); err == nil && len(p.ContentChanges) > 0 {
+			return s.update(p.TextDocument.URI, p.ContentChanges[len(p.ContentChanges)-1].Text)
+		}
+	case "textDocument/didClose":
+		var p didCloseParams
+		if err = json.Unmarshal(msg.Params, &p); err == nil {
+			delete(s.docs, p.TextDocument.URI)
+			return s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+				URI:         p.TextDocument.URI,
+				Diagnostics: []diagnostic{},
+			})
+		}
+
+	case "textDocument/definition":
+		var p positionParams
+		if err = json.Unmarshal(msg.Params, &p); err == nil {
+			result = s.definition(p)
+		}
+	case "textDocument/references":
+		var p referenceParams
+		if err = json.Unmarshal(msg.Params, &p); err == nil {
+			result = s.references(p)
+		}
+	case "textDocument/hover":
+		var p positionParams
+		if err = json.Unmarshal(msg.Params, &p); err == nil {
+			result = s.hover(p)
+		}
+	case "textDocument/completion":
+		var p positionParams
+		if err = json.Unmarshal(msg.Params, &p); err == nil {
+			result = s.completion(p)
+		}
+	case "textDocument/documentSymbol":
+		var p documentSymbolParams
+		if err = json.Unmarshal(msg.Params, &p); err == nil {
+			result = s.documentSymbols(p)
+		}
+
+	default:
+		// notifications that are not understood are dropped
+		if msg.ID == nil {
+			return nil
+		}
+		return s.fail(msg.ID, codeMethodNotFound, fmt.Sprintf("method '%s' is not supported", msg.Method))
+	}
+
+	if msg.ID == nil {
+		return nil
+	}
+	if err != nil {
+		return s.fail(msg.ID, codeInvalidParams, err.Error())
+	}
+	raw, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return writeMessage(s.out, response{JSONRPC: "2.0", ID: msg.ID, Result: raw})
+}
+
+func (s *server) fail(id json.RawMessage, code int, text string) error {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return writeMessage(s.out, response{
+		JSONRPC: "2.0",
+		ID:      id,
+		Error:   &responseError{Code: code, Message: text},
+	})
+}
+
+func (s *server) notify(method string, params any) error {
+	return writeMessage(s.out, notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// update analyses the new text of a document and publishes its diagnostics
+func (s *server) update(uri string, text string) error {
+	doc := &document{uri: uri, path: uriPath(uri), lines: strings.Split(text, "\n")}
+	doc.analysis = assembler.Analyze(doc.path, text, s.opts)
+	s.docs[uri] = doc
+	return s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+		URI:         uri,
+		Diagnostics: s.diagnostics(doc),
+	})
+}
+
+// diagnostics are the problems of a document. An error in an included file
+// is shown on the first line, warnings there are not the document's to fix.
+func (s *server) diagnostics(doc *document) []diagnostic {
+	out := []diagnostic{}
+	for _, p := range doc.analysis.Problems {
+		d := diagnostic{Severity: severityError, Code: p.Rule, Source: "asm", Message: p.Message}
+		if p.Warning {
+			d.Severity = severityWarning
+		}
+		switch {
+		case p.Pos.File == filepath.Clean(doc.path):
+			d.Range = toSpan(p.Pos, p.Length)
+		case p.Warning:
+			continue
+		default:
+			d.Message = fmt.Sprintf("%v: %s", p.Pos, p.Message)
+		}
+		out = append(out, d)
+	}
+	return out
+}
+
+// uriPath is the file a file URI names, other URIs are used as they are
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+// locate is where pos is for the client, false for text it cannot open like
+// the embedded standard library
+func (s *server) locate(doc *document, pos assembler.Position, length int) (location, bool) {
+	uri := doc.uri
+	if pos.File != filepath.Clean(doc.path) {
+		abs, err := filepath.Abs(pos.File)
+		if err != nil {
+			return location{}, false
+		}
+		if _, err := os.Stat(abs); err != nil {
+			return location{}, false
+		}
+		uri = (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}).String()
+	}
+	return location{URI: uri, Range: toSpan(pos, length)}, true
+}
+
+func toSpan(pos assembler.Position, length int) span {
+	start := position{Line: max(pos.Line-1, 0), Character: max(pos.Col-1, 0)}
+	end := start
+	end.Character += length
+	return span{Start: start, End: end}
+}
+
+// at is the document and assembler position of a request, nil for documents
+// that are not open
+func (s *server) at(p positionParams) (*document, assembler.Position) {
+	doc := s.docs[p.TextDocument.URI]
+	if doc == nil {
+		return nil, assembler.Position{}
+	}
+	return doc, assembler.Position{
+		File: filepath.Clean(doc.path),
+		Line: p.Position.Line + 1,
+		Col:  p.Position.Character + 1,
+	}
+}
+
+func (s *server) definition(p positionParams) []location {
+	doc, pos := s.at(p)
+	if doc == nil {
+		return nil
+	}
+	ref, ok := doc.analysis.NameAt(pos)
+	if !ok {
+		return nil
+	}
+	sym, ok := doc.analysis.Lookup(ref.Name)
+	if !ok {
+		return nil
+	}
+	if loc, ok := s.locate(doc, sym.Pos, sym.Length); ok {
+		return []location{loc}
+	}
+	return nil
+}
+
+func (s *server) references(p referenceParams) []location {
+	doc, pos := s.at(p.positionParams)
+	if doc == nil {
+		return nil
+	}
+	ref, ok := doc.analysis.NameAt(pos)
+	if !ok {
+		return nil
+	}
+	var out []location
+	for _, use := range doc.analysis.Uses(ref.Name) {
+		if use.Definition && !p.Context.IncludeDeclaration {
+			continue
+		}
+		if loc, ok := s.locate(doc, use.Pos, use.Length); ok {
+			out = append(out, loc)
+		}
+	}
+	return out
+}
+
+// wordAt is the mnemonic, directive or register under the cursor
+func wordAt(line string, col int) string {
+	isWord := func(c byte) bool {
+		return c == '.' || c == '_' || ('0' <= c && c <= '9') || ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z')
+	}
+	i := col - 1
+	if i < 0 || i >= len(line) || !isWord(line[i]) {
+		return ""
+	}
+	start, end := i, i
+	for start > 0 && isWord(line[start-1]) {
+		start--
+	}
+	for end < len(line) && isWord(line[end]) {
+		end++
+	}
+	return line[start:end]
+}
+
+// keyword finds name among the mnemonics, directives and registers
+func keyword(name string) (assembler.Keyword, bool) {
+	for _, k := range assembler.Keywords() {
+		if k.Name == name {
+			return k, true
+		}
+	}
+	return assembler.Keyword{}, false
+}
+
+// hover describes the symbol under the cursor, or the mnemonic with what its
+// line assembled to and where
+func (s *server) hover(p positionParams) *hover {
+	doc, pos := s.at(p)
+	if doc == nil {
+		return nil
+	}
+	a := doc.analysis
+	var b strings.Builder
+
+	if ref, ok := a.NameAt(pos); ok {
+		sym, ok := a.Lookup(ref.Name)
+		if !ok {
+			return nil
+		}
+		fmt.Fprintf(&b, "```asm\n%s\n```\n", sym.Detail)
+		switch sym.Kind {
+		case assembler.SymbolLabel:
+			if addr, ok := a.Address(sym); ok {
+				fmt.Fprintf(&b, "label `%s` at `0x%02X`", sym.DisplayName(), addr)
+			}
+		case assembler.SymbolData:
+			if addr, ok := a.Address(sym); ok && int(addr) < len(a.Program.Data) {
+				fmt.Fprintf(&b, "data `%s` at `0x%02X`, holds `0x%02X`", sym.DisplayName(), addr, a.Program.Data[addr])
+			}
+		case assembler.SymbolConstant:
+			if a.Program != nil {
+				if v, ok := a.Program.Constants[sym.Name]; ok {
+					fmt.Fprintf(&b, "constant `%s` = %d", sym.Name, v)
+				}
+			}
+		case assembler.SymbolMacro:
+			fmt.Fprintf(&b, "macro defined at %v", sym.Pos)
+		}
+		r := toSpan(ref.Pos, ref.Length)
+		return &hover{Contents: markupContent{Kind: "markdown", Value: b.String()}, Range: &r}
+	}
+
+	line := ""
+	if pos.Line >= 1 && pos.Line <= len(doc.lines) {
+		line = doc.lines[pos.Line-1]
+	}
+	word := wordAt(line, pos.Col)
+	if k, ok := keyword(word); ok {
+		fmt.Fprintf(&b, "`%s` %s: %s\n", k.Syntax, k.Kind, k.Doc)
+	} else if sym, ok := a.Lookup(word); !ok || sym.Kind != assembler.SymbolMacro {
+		return nil
+	}
+	if enc := a.Encoding(pos.File, pos.Line); len(enc) > 0 {
+		b.WriteString("\n```\n")
+		for _, e := range enc {
+			words := make([]string, len(e.Words))
+			for i, w := range e.Words {
+				words[i] = fmt.Sprintf("%02X", w)
+			}
+			fmt.Fprintf(&b, "0x%02X  %-6s %s\n", e.Addr, strings.Join(words, " "), e.Text)
+		}
+		b.WriteString("```\n")
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+	return &hover{Contents: markupContent{Kind: "markdown", Value: b.String()}}
+}
+
+// completion offers mnemonics, directives and macros for the first word of a
+// line and registers and names for the operands
+func (s *server) completion(p positionParams) []completionItem {
+	doc, pos := s.at(p)
+	if doc == nil {
+		return nil
+	}
+	line := ""
+	if pos.Line >= 1 && pos.Line <= len(doc.lines) {
+		line = doc.lines[pos.Line-1]
+	}
+	before := line[:min(pos.Col-1, len(line))]
+	if _, after, ok := strings.Cut(before, ":"); ok {
+		before = after
+	}
+	first := !strings.ContainsAny(strings.TrimLeft(before, " \t"), " \t")
+
+	out := []completionItem{}
+	for _, k := range assembler.Keywords() {
+		if first == (k.Kind == "register") {
+			continue
+		}
+		out = append(out, completionItem{
+			Label:         k.Name,
+			Kind:          completionKeyword,
+			Detail:        k.Syntax,
+			Documentation: &markupContent{Kind: "markdown", Value: k.Doc},
+		})
+	}
+	for _, sym := range doc.analysis.Symbols {
+		item := completionItem{Label: sym.DisplayName(), Detail: sym.Detail}
+		switch {
+		case sym.DisplayName() != sym.Name && !strings.Contains(sym.DisplayName(), "."):
+			// numeric labels are written 1b and 1f
+			continue
+		case first && sym.Kind == assembler.SymbolMacro:
+			item.Kind = completionFunction
+		case first, sym.Kind == assembler.SymbolMacro:
+			continue
+		case sym.Kind == assembler.SymbolLabel:
+			item.Kind = completionReference
+			// a local label is written without the label it belongs to
+			if i := strings.Index(sym.Name, "."); i > 0 {
+				item.Label = sym.Name[i:]
+			}
+		case sym.Kind == assembler.SymbolData:
+			item.Kind = completionVariable
+		default:
+			item.Kind = completionConstant
+		}
+		out = append(out, item)
+	}
+	return out
+}
+
+// documentSymbols outlines the labels, data, constants and macros of the
+// document, local labels under the label they belong to
+func (s *server) documentSymbols(p documentSymbolParams) []documentSymbol {
+	doc := s.docs[p.TextDocument.URI]
+	if doc == nil {
+		return nil
+	}
+	out := []documentSymbol{}
+	global := -1 // index of the label local labels go under
+	for _, sym := range doc.analysis.Symbols {
+		if sym.Pos.File != filepath.Clean(doc.path) {
+			continue
+		}
+		line := doc.lines[sym.Pos.Line-1]
+		ds := documentSymbol{
+			Name:           sym.DisplayName(),
+			Detail:         sym.Detail,
+			Kind:           symbolFunction,
+			Range:          span{Start: position{Line: sym.Pos.Line - 1}, End: position{Line: sym.Pos.Line - 1, Character: len(line)}},
+			SelectionRange: toSpan(sym.Pos, sym.Length),
+		}
+		switch sym.Kind {
+		case assembler.SymbolData:
+			ds.Kind = symbolVariable
+		case assembler.SymbolConstant:
+			ds.Kind = symbolConstant
+		}
+
+		local := strings.Index(sym.DisplayName(), ".") > 0
+		if sym.Kind == assembler.SymbolLabel && local && global >= 0 {
+			out[global].Children = append(out[global].Children, ds)
+			continue
+		}
+		out = append(out, ds)
+		if sym.Kind == assembler.SymbolLabel && !local {
+			global = len(out) - 1
+		}
+	}
+	return out
+}
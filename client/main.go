@@ -1,25 +1,70 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"tcp-vm/shared/assembler"
+	"tcp-vm/shared/core"
+	"tcp-vm/shared/linker"
 	o "tcp-vm/shared/ofstp"
+	"tcp-vm/shared/verify"
 )
 
 func main() {
-	if len(os.Args) != 2 {
-		fmt.Println("usage: client <program.asm>")
+	var includes assembler.IncludeFlag
+	flag.Var(&includes, "I", "directory to search for .include files, may be repeated")
+	defs := assembler.DefineFlag{}
+	flag.Var(defs, "D", "define a constant for .if and .ifdef, `name=value` or just name for 1, may be repeated")
+	listing := flag.String("list", "", "write a listing of the assembled program to this file")
+	optimize := flag.Bool("O", false, "run the peephole optimizer and report the bytes it saved")
+	lint := flag.Bool("lint", false, "also warn about unused labels, unreachable code and other likely mistakes")
+	flag.Usage = func() {
+		fmt.Println("usage: client [-I dir]... [-D name=value]... [-O] [-lint] [-list file] <program.asm> [library.asm|library.o]...")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
 		os.Exit(1)
 	}
-	asm := os.Args[1]
+	asm := flag.Arg(0)
 
-	data, text, err := assembler.Assemble(asm)
+	prog, err := load(flag.Args(), assembler.Options{
+		IncludePaths: includes,
+		Defines:      defs,
+		Optimize:     *optimize,
+		Lint:         *lint,
+	})
 	if err != nil {
-		log.Fatal(err)
+		fmt.Fprintln(os.Stderr, assembler.Explain(err))
+		os.Exit(1)
+	}
+
+	for _, w := range prog.Warnings {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", w)
+	}
+	if *optimize {
+		fmt.Fprintf(os.Stderr, "optimizer saved %d bytes\n", prog.Saved())
+	}
+	if *listing != "" {
+		if flag.NArg() > 1 {
+			log.Fatal("-list needs a single source file, linked programs have no listing")
+		}
+		if err := writeListing(prog, *listing); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// the router rejects programs with errors, show everything up front
+	for _, f := range verify.Verify(prog.Text, prog.Entry) {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", asm, f)
 	}
 
 	routerID := os.Getenv("ROUTER_ID")
@@ -61,7 +106,20 @@ func main() {
 	}
 
 	// Send the real Stateless packet
-	stateless, _ := o.NewStatelessPacket(data[:], text[:])
+	stateless, err := o.NewStatelessPacket(prog.Data[:], prog.Text[:], prog.Entry)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// lets the server report faults by source line instead of address
+	debug, err := prog.DebugInfo().Marshal()
+	if err == nil && len(debug) > o.MaxDebugLength {
+		err = fmt.Errorf("%d bytes, at most %d fit", len(debug), o.MaxDebugLength)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: not sending debug info: %v\n", asm, err)
+	} else {
+		stateless.Debug = debug
+	}
 	_, err = cli.Do(stateless)
 	if err != nil {
 		log.Fatal(err)
@@ -74,6 +132,19 @@ func main() {
 			log.Fatal(err)
 		}
 		if rp, ok := pkt.(*o.ReturnPacket); ok {
+			if rp.ExitCode == o.CoreDumpCode {
+				c, err := core.Parse(rp.Output)
+				if err != nil {
+					log.Fatalf("bad core file from server: %v", err)
+				}
+				corePath := strings.TrimSuffix(asm, filepath.Ext(asm)) + ".core"
+				if err := os.WriteFile(corePath, rp.Output, 0o644); err != nil {
+					log.Fatal(err)
+				}
+				fmt.Printf("FAULT at 0x%02X: %s\n", c.FaultPC, c.Reason)
+				fmt.Printf("core written to %s, inspect with `coreview -src %s %s`\n", corePath, asm, corePath)
+				os.Exit(1)
+			}
 			if len(rp.Output) > 0 {
 				fmt.Printf("OUTPUT: %s\n", string(rp.Output))
 				return
@@ -83,3 +154,24 @@ func main() {
 		}
 	}
 }
+
+func writeListing(prog *assembler.Program, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := prog.WriteListing(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// load assembles a single source file, several files or any object are
+// assembled separately and linked
+func load(paths []string, opts assembler.Options) (*assembler.Program, error) {
+	if len(paths) == 1 && !strings.HasSuffix(paths[0], ".o") {
+		return assembler.AssembleFile(paths[0], opts)
+	}
+	return linker.LinkFiles(paths, opts)
+}
@@ -0,0 +1,3 @@
+module tcp-vm/coreview
+
+go 1.24.0
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"tcp-vm/shared/assembler"
+	"tcp-vm/shared/core"
+	"tcp-vm/shared/debuginfo"
+	"tcp-vm/shared/disasm"
+	g "tcp-vm/shared/globals"
+	"tcp-vm/shared/vm"
+)
+
+// addr as 0xNN (label+offset) when a label is known
+func describe(addr uint8, syms disasm.Symbols) string {
+	out := fmt.Sprintf("0x%02X", addr)
+	if name, off, ok := syms.Enclosing(addr); ok {
+		if off == 0 {
+			return fmt.Sprintf("%s (%s)", out, name)
+		}
+		return fmt.Sprintf("%s (%s+%d)", out, name, off)
+	}
+	return out
+}
+
+func printText(c *core.Core, syms disasm.Symbols) {
+	text := c.Text()
+	end := len(disasm.TrimPadding(text))
+	if c.FaultPC >= vm.TextStart {
+		// always show the faulting instruction, even inside the padding
+		off := int(c.FaultPC - vm.TextStart)
+		fault := off + 1
+		if in, err := disasm.Decode(text, off, vm.TextStart); err == nil {
+			fault = off + in.Size()
+		}
+		end = max(end, fault)
+	}
+
+	fmt.Println(".text:")
+	instrs, err := disasm.Disassemble(text[:end], vm.TextStart)
+	for _, in := range instrs {
+		if name, ok := syms[in.Addr]; ok {
+			fmt.Printf("  %s:\n", name)
+		}
+
+		marker := "  "
+		if in.Addr == c.FaultPC {
+			marker = "=>"
+		}
+
+		var enc []string
+		for _, b := range in.Raw {
+			enc = append(enc, fmt.Sprintf("%02X", b))
+		}
+		fmt.Printf(
+			"%s\t0x%02X:  %-6s  %s\n",
+			marker,
+			in.Addr,
+			strings.Join(enc, " "),
+			in.Format(syms),
+		)
+	}
+	if err != nil {
+		fmt.Printf("  (%v)\n", err)
+	}
+}
+
+func printStack(c *core.Core) {
+	stack := c.Stack()
+	fmt.Printf("stack (%d words, top first):\n", len(stack))
+	if len(stack) == 0 {
+		fmt.Println("  (empty)")
+	}
+	for i := len(stack) - 1; i >= 0; i-- {
+		addr := vm.StackStart + i
+		fmt.Printf("  0x%02X:  %02X  %3d\n", addr, stack[i], stack[i])
+	}
+}
+
+func printData(c *core.Core, syms disasm.Symbols) {
+	fmt.Println(".data:")
+	for i, b := range c.Data() {
+		addr := uint8(vm.DataStart + i)
+		fmt.Printf("  0x%02X:  %02X  %3d  %s\n", addr, b, b, syms[addr])
+	}
+}
+
+func printBanks(c *core.Core) {
+	fmt.Printf("selected bank: %d\n", c.Bank)
+	for bank := 0; bank < g.BankCount; bank++ {
+		page := c.Banks[bank*g.BankLength : (bank+1)*g.BankLength]
+		used := false
+		for _, b := range page {
+			used = used || b != 0
+		}
+		if used {
+			fmt.Printf("  bank %d: % X\n", bank+1, page)
+		}
+	}
+}
+
+func main() {
+	src := flag.String("src", "", "assembly source the core was produced from")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [-src program.asm] core\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	raw, err := os.ReadFile(flag.Arg(0))
+	if err != nil {
+		fmt.Printf("reading core: %v\n", err)
+		os.Exit(1)
+	}
+	c, err := core.Parse(raw)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+
+	var syms disasm.Symbols
+	var debug *debuginfo.Info
+	if *src != "" {
+		prog, err := assembler.AssembleFile(*src, assembler.Options{})
+		if err != nil {
+			fmt.Printf("assembler error: %s\n", assembler.Explain(err))
+			os.Exit(1)
+		}
+		if core.ProgramHash(prog.Data[:], prog.Text[:]) != c.ProgramHash {
+			fmt.Printf("warning: %s does not match the program in this core, labels may be wrong\n\n", *src)
+		}
+		syms = disasm.SymbolsFromLabels(prog.Symbols)
+		debug = prog.DebugInfo()
+	}
+
+	fmt.Printf("fault: %s\n", c.Reason)
+	fmt.Printf("  at %s", describe(c.FaultPC, syms))
+	if in, err := disasm.Decode(c.Memory[:], int(c.FaultPC), 0); err == nil {
+		fmt.Printf(": %s", in.Format(syms))
+	}
+	fmt.Println()
+	if debug != nil {
+		if _, _, ok := debug.Lookup(c.FaultPC); ok {
+			fmt.Printf("  source: %s\n", debug.Describe(c.FaultPC))
+		}
+	}
+	fmt.Printf("steps: %d\n", c.Steps)
+	fmt.Printf("program: %x\n", c.ProgramHash)
+	fmt.Printf("registers: R0: %d, R1: %d, SP: %d, PC: %d\n\n", c.R0, c.R1, c.SP, c.PC)
+
+	printText(c, syms)
+	fmt.Println()
+	printStack(c)
+	fmt.Println()
+	printData(c, syms)
+	fmt.Println()
+	printBanks(c)
+}
@@ -0,0 +1,3 @@
+module tcp-vm/disasm
+
+go 1.24.0
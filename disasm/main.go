@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"tcp-vm/shared/assembler"
+	"tcp-vm/shared/disasm"
+	g "tcp-vm/shared/globals"
+	o "tcp-vm/shared/ofstp"
+	"tcp-vm/shared/vm"
+)
+
+// parseHexDump accepts whitespace separated hex bytes, `xxd -p` style runs of
+// hex digits, optional `0x` prefixes, `addr:` columns and `#` comments
+func parseHexDump(raw []byte) ([]byte, error) {
+	var out []byte
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	line_number := 0
+	for scanner.Scan() {
+		line := scanner.Text()
+		line_number += 1
+
+		if idx := strings.Index(line, "#"); idx != -1 {
+			line = line[:idx]
+		}
+
+		for _, field := range strings.Fields(line) {
+			if strings.HasSuffix(field, ":") {
+				continue
+			}
+			field = strings.TrimPrefix(strings.ToLower(field), "0x")
+			if len(field)%2 != 0 {
+				return nil, fmt.Errorf("line %d: odd number of hex digits in '%s'", line_number, field)
+			}
+			for i := 0; i < len(field); i += 2 {
+				b, err := strconv.ParseUint(field[i:i+2], 16, 8)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid hex byte '%s'", line_number, field[i:i+2])
+				}
+				out = append(out, byte(b))
+			}
+		}
+	}
+	return out, scanner.Err()
+}
+
+// splitImage works out what kind of image raw is: a full stateless packet, or
+// from its length a .data + .text image or just .text
+func splitImage(raw []byte) (data []byte, text []byte) {
+	if len(raw) > 0 && o.PacketType(raw[0]) == o.Stateless {
+		// the entry point and debug info that follow are not part of .text
+		if pkt, err := o.ParsePacket(raw); err == nil {
+			st := pkt.(*o.StatelessPacket)
+			return st.Data[:], st.Text[:]
+		}
+	}
+	switch {
+	case len(raw) == g.DataSectionLength+g.TextSectionLength:
+		return raw[:g.DataSectionLength], raw[g.DataSectionLength:]
+	}
+	return nil, raw
+}
+
+func main() {
+	hex := flag.Bool("hex", false, "input is a hex dump instead of a raw image")
+	src := flag.String("src", "", "assembly source to take label names from")
+	all := flag.Bool("all", false, "keep the zero padding at the end of .text")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] [image]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	raw, err := os.ReadFile(flag.Arg(0))
+	if err != nil {
+		fmt.Printf("reading image: %v\n", err)
+		os.Exit(1)
+	}
+	if *hex {
+		raw, err = parseHexDump(raw)
+		if err != nil {
+			fmt.Printf("parsing hex dump: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var syms disasm.Symbols
+	if *src != "" {
+		labels, err := assembler.Symbols(*src)
+		if err != nil {
+			fmt.Printf("assembler error: %v\n", err)
+			os.Exit(1)
+		}
+		syms = disasm.SymbolsFromLabels(labels)
+	}
+
+	data, text := splitImage(raw)
+	if !*all {
+		text = disasm.TrimPadding(text)
+	}
+
+	if data != nil {
+		fmt.Println(".data")
+		for i, b := range data {
+			addr := uint8(vm.DataStart + i)
+			name := ""
+			if label, ok := syms[addr]; ok {
+				name = label
+			}
+			fmt.Printf("\t0x%02X:  %02X      %s\n", addr, b, name)
+		}
+		fmt.Println()
+	}
+
+	fmt.Println(".text")
+	if err := disasm.Write(os.Stdout, text, vm.TextStart, syms); err != nil {
+		fmt.Printf("disassembler error: %v\n", err)
+		os.Exit(1)
+	}
+}
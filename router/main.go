@@ -8,8 +8,10 @@ import (
 	"sync"
 	"time"
 
+	"tcp-vm/shared/clock"
 	g "tcp-vm/shared/globals"
 	o "tcp-vm/shared/ofstp"
+	"tcp-vm/shared/verify"
 )
 
 type session struct {
@@ -25,6 +27,7 @@ type programJob struct {
 
 type Router struct {
 	*o.Server
+	clock          clock.Clock
 	mutex          sync.Mutex
 	waitingClients []net.Conn
 	waitingServers []net.Conn
@@ -32,10 +35,11 @@ type Router struct {
 	programQueue   []*programJob
 }
 
-func NewRouter() *Router {
+func NewRouter(clk clock.Clock) *Router {
 	s := o.NewServer()
 	r := &Router{
 		Server:       s,
+		clock:        clk,
 		sessions:     make(map[net.Conn]*session),
 		programQueue: make([]*programJob, 0),
 	}
@@ -49,7 +53,7 @@ func (r *Router) tryMatch() {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
-	now := time.Now()
+	now := r.clock.Now()
 	// wake sleeping jobs
 	i := 0
 	for i < len(r.programQueue) {
@@ -106,6 +110,21 @@ func (r *Router) handleReturn(req *o.Request) {
 
 func (r *Router) handleStateless(req *o.Request) {
 	st := req.Packet.(*o.StatelessPacket)
+
+	// reject programs that are known to fault before they take up a vm
+	if report := verify.Verify(st.Text, st.Entry); report.HasErrors() {
+		msg := "rejected by verifier:"
+		for _, f := range report.Errors() {
+			msg += "\n" + f.String()
+		}
+		if len(msg) > 1498 {
+			msg = msg[:1498]
+		}
+		rejectPkt, _ := o.NewReturnPacket(1, []byte(msg))
+		req.Respond(rejectPkt)
+		return
+	}
+
 	sess := r.sessions[req.Conn()]
 	sess.server.Write(o.MustMarshal(st))
 }
@@ -138,7 +157,7 @@ func main() {
 
 	addr := ":" + *port
 	log.Printf("router listening on %s\n", addr)
-	r := NewRouter()
+	r := NewRouter(clock.Real{})
 	if err := r.Listen(addr); err != nil {
 		log.Fatal(err)
 	}
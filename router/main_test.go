@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"tcp-vm/shared/clock"
+)
+
+func Test_sleepingJobWakesOnSimulatedClock(t *testing.T) {
+	sim := clock.NewSimulated(time.Unix(1000, 0))
+	r := NewRouter(sim)
+
+	srv, other := net.Pipe()
+	defer srv.Close()
+	defer other.Close()
+
+	r.programQueue = append(r.programQueue, &programJob{
+		wake: sim.Now().Add(5 * time.Second),
+		conn: srv,
+	})
+
+	r.tryMatch()
+	if len(r.programQueue) != 1 || len(r.waitingServers) != 0 {
+		t.Fatalf("job woke up before its wake time")
+	}
+
+	sim.Advance(4 * time.Second)
+	r.tryMatch()
+	if len(r.programQueue) != 1 {
+		t.Fatalf("job woke up a second early")
+	}
+
+	sim.Advance(time.Second)
+	r.tryMatch()
+	if len(r.programQueue) != 0 || len(r.waitingServers) != 1 {
+		t.Fatalf("job did not wake up at its wake time")
+	}
+	if r.waitingServers[0] != srv {
+		t.Fatalf("woken job did not give back its server")
+	}
+}
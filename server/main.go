@@ -3,13 +3,16 @@ package main
 import (
 	"bufio"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
-	"time"
 
+	"tcp-vm/shared/clock"
+	"tcp-vm/shared/core"
+	"tcp-vm/shared/debuginfo"
 	g "tcp-vm/shared/globals"
 	o "tcp-vm/shared/ofstp"
 	vm "tcp-vm/shared/vm"
@@ -23,11 +26,16 @@ func recvPacket(r io.Reader) (o.Packet, error) {
 	pt := o.PacketType(header[0])
 	switch pt {
 	case o.Stateless:
-		rest := make([]byte, g.DataSectionLength+g.TextSectionLength)
+		// .data, .text, the entry point and the length of the debug info
+		rest := make([]byte, g.DataSectionLength+g.TextSectionLength+1+2)
 		if _, err := io.ReadFull(r, rest); err != nil {
 			return nil, err
 		}
-		return o.ParsePacket(append(header, rest...))
+		debug := make([]byte, o.StatelessDebugLength(rest[len(rest)-2:]))
+		if _, err := io.ReadFull(r, debug); err != nil {
+			return nil, err
+		}
+		return o.ParsePacket(append(append(header, rest...), debug...))
 	case o.Return:
 		exit := make([]byte, 1)
 		if _, err := io.ReadFull(r, exit); err != nil {
@@ -37,7 +45,8 @@ func recvPacket(r io.Reader) (o.Packet, error) {
 		n, _ := r.Read(payload)
 		return o.ParsePacket(append(append(header, exit...), payload[:n]...))
 	case o.Stateful:
-		size := 4 + g.DataSectionLength + g.StackLength + g.FlagLength + g.TextSectionLength
+		size := 4 + g.DataSectionLength + g.StackLength + g.FlagLength + g.TextSectionLength +
+			1 + g.BankCount*g.BankLength
 		rest := make([]byte, size)
 		if _, err := io.ReadFull(r, rest); err != nil {
 			return nil, err
@@ -48,6 +57,20 @@ func recvPacket(r io.Reader) (o.Packet, error) {
 	}
 }
 
+// describeFault names the source line and label of a fault when the job came
+// with debug info, `segfault on POP: underflow at adder.asm:42 in loop`
+func describeFault(err error, raw []byte) error {
+	var f *vm.Fault
+	if len(raw) == 0 || !errors.As(err, &f) {
+		return err
+	}
+	info, perr := debuginfo.Parse(raw)
+	if perr != nil {
+		return err
+	}
+	return fmt.Errorf("%w at %s", err, info.Describe(uint8(f.PC)))
+}
+
 func main() {
 	routerID := os.Getenv("ROUTER_ID")
 	if routerID == "" {
@@ -59,6 +82,9 @@ func main() {
 	}
 	defer conn.Close()
 
+	// every machine on this server reads time from here
+	var clk clock.Clock = clock.Real{}
+
 	// register as a server
 	regPkt, _ := o.NewReturnPacket(o.RegisterServerCode, nil)
 	conn.Write(o.MustMarshal(regPkt))
@@ -77,10 +103,22 @@ func main() {
 			copy(textArr[:], p.Text[:])
 
 			machine := new(vm.VirtualMachine)
-			machine.ResetFromStateless(dataArr, textArr)
+			machine.Clock = clk
+			machine.ResetFromStateless(dataArr, textArr, p.Entry)
 			if err := machine.RunUntilStop(); err != nil {
-				errPkt, _ := o.NewReturnPacket(1, []byte(err.Error()))
-				conn.Write(o.MustMarshal(errPkt))
+				err = describeFault(err, p.Debug)
+				log.Printf("program faulted: %v", err)
+
+				// send a core file back so the client can inspect the fault
+				hash := core.ProgramHash(dataArr[:], textArr[:])
+				raw, cerr := core.New(machine, err, hash).Marshal()
+				if cerr != nil {
+					errPkt, _ := o.NewReturnPacket(1, []byte(err.Error()))
+					conn.Write(o.MustMarshal(errPkt))
+					continue
+				}
+				corePkt, _ := o.NewReturnPacket(o.CoreDumpCode, raw)
+				conn.Write(o.MustMarshal(corePkt))
 				continue
 			}
 
@@ -92,7 +130,7 @@ func main() {
 				conn.Write(o.MustMarshal(outPkt))
 
 			} else if flag&g.SleepFlag != 0 {
-				wake := time.Now().Unix() + int64(machine.R0)
+				wake := machine.WakeAt.Unix()
 				buf := make([]byte, 8)
 				binary.BigEndian.PutUint64(buf, uint64(wake))
 				sleepPkt, _ := o.NewReturnPacket(0, buf)
@@ -105,6 +143,7 @@ func main() {
 					machine.Memory[vm.StackStart:vm.StackStart+g.StackLength],
 					machine.Memory[vm.FlagStart],
 					machine.Memory[vm.TextStart:vm.TextStart+g.TextSectionLength],
+					machine.Bank, machine.BankBytes(),
 				)
 				conn.Write(o.MustMarshal(statePkt))
 			}
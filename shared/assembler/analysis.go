@@ -0,0 +1,406 @@
+package assembler
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Kinds of Symbol
+const (
+	SymbolLabel    = "label"
+	SymbolData     = "data"
+	SymbolConstant = "constant"
+	SymbolMacro    = "macro"
+)
+
+// Analysis is what an editor needs to know about a source file: what is
+// wrong with it, where its names are defined and used and what each line
+// assembles to. It covers the files the source includes as well.
+type Analysis struct {
+	// the assembled program, nil when the source has errors
+	Program *Program
+	// the errors that stopped it, or the warnings of a program that
+	// assembled, in source order
+	Problems []Problem
+	// every label, data name, constant and macro, in source order with the
+	// source itself first
+	Symbols []Symbol
+	// every place a symbol is named, definitions included
+	Refs []Ref
+
+	file   string
+	widths map[Position]int // length of the token at each position
+}
+
+// Problem is an error or warning found by Analyze
+type Problem struct {
+	Pos Position
+	// columns it covers, at least one
+	Length  int
+	Message string
+	Warning bool
+	// the lint rule of a warning, see the Rule constants
+	Rule string
+}
+
+// Symbol is a name defined in the source
+type Symbol struct {
+	// a local label is named with the label it belongs to, `adder.loop`, a
+	// numeric label with its number and where it is, see DisplayName
+	Name string
+	Kind string
+	// where the name is written in its definition
+	Pos    Position
+	Length int
+	// the defining line without its comment, `.equ N 0x05`
+	Detail string
+}
+
+// DisplayName is the name as the source writes it, local labels keep the
+// label they belong to
+func (sym Symbol) DisplayName() string {
+	return displayName(sym.Name)
+}
+
+// Ref is one place a symbol is named
+type Ref struct {
+	Name       string
+	Pos        Position
+	Length     int
+	Definition bool
+}
+
+// Encoded is what part of a line assembled to
+type Encoded struct {
+	Addr  uint8
+	Words []byte
+	// the instruction written out, pseudo-instructions and macros show what
+	// they expanded to
+	Text string
+}
+
+// Analyze assembles src and works out where its names are defined and used.
+// name is used in positions and for relative includes like in
+// AssembleString. A source with errors still has its symbols and refs.
+func Analyze(name string, src string, opts Options) *Analysis {
+	a := &Analysis{widths: map[Position]int{}}
+	if name != "" {
+		a.file = filepath.Clean(name)
+	}
+
+	prog, err := AssembleString(name, src, opts)
+	if err != nil {
+		a.Problems = problems(err, a.file)
+	} else {
+		a.Program = prog
+	}
+
+	// read again for the files included, a source with errors has no Program
+	// to take them from
+	pp := newPreprocessor(opts)
+	pp.read(strings.NewReader(src), name, nil)
+	files := make([]string, 0, len(pp.sources))
+	for file := range pp.sources {
+		if file != a.file {
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+
+	var uses []Ref
+	for _, file := range append([]string{a.file}, files...) {
+		uses = append(uses, a.collect(file, pp.sources[file])...)
+	}
+	defined := map[string]bool{}
+	for _, sym := range a.Symbols {
+		defined[sym.Name] = true
+	}
+	for _, ref := range uses {
+		if ref.Definition || defined[ref.Name] {
+			a.Refs = append(a.Refs, ref)
+		}
+	}
+
+	if prog != nil {
+		for _, w := range prog.Warnings {
+			a.Problems = append(a.Problems, Problem{
+				Pos:     w.Pos,
+				Length:  max(a.widths[w.Pos], 1),
+				Message: w.Message,
+				Warning: true,
+				Rule:    w.Rule,
+			})
+		}
+		sort.SliceStable(a.Problems, func(i, j int) bool {
+			p, q := a.Problems[i].Pos, a.Problems[j].Pos
+			if p.File != q.File {
+				return p.File < q.File
+			}
+			if p.Line != q.Line {
+				return p.Line < q.Line
+			}
+			return p.Col < q.Col
+		})
+	}
+	return a
+}
+
+// problems turns an assembly error into Problems, one without a position is
+// put at the start of file
+func problems(err error, file string) []Problem {
+	var ds Diagnostics
+	if !errors.As(err, &ds) {
+		return []Problem{{Pos: Position{File: file, Line: 1, Col: 1}, Length: 1, Message: err.Error()}}
+	}
+	var out []Problem
+	for _, d := range ds {
+		msg := d.Message
+		if d.Context != "" {
+			msg = fmt.Sprintf("%s (%s)", msg, d.Context)
+		}
+		out = append(out, Problem{Pos: d.Pos, Length: max(d.Length, 1), Message: msg})
+	}
+	return out
+}
+
+// collect adds the symbols file defines and returns every name it writes,
+// whether or not it turns out to be a symbol
+func (a *Analysis) collect(file string, text []string) []Ref {
+	lines, err := lexTrivia(strings.NewReader(strings.Join(text, "\n")), file)
+	if err != nil {
+		return nil
+	}
+	for _, line := range lines {
+		for _, t := range line {
+			a.widths[t.position()] = len(t.val)
+		}
+	}
+	// errors are reported by assembling, what can be renamed still is
+	scopeLabels(lines)
+
+	var refs []Ref
+	define := func(t token, kind string) {
+		a.Symbols = append(a.Symbols, Symbol{
+			Name:   t.val,
+			Kind:   kind,
+			Pos:    t.position(),
+			Length: a.widths[t.position()],
+			Detail: strings.TrimSpace(stripComment(text[t.lin-1])),
+		})
+		refs = append(refs, Ref{Name: t.val, Pos: t.position(), Length: a.widths[t.position()], Definition: true})
+	}
+
+	section := ""
+	for _, line := range lines {
+		if n := len(line); n > 0 && line[n-1].typ == Comment {
+			line = line[:n-1]
+		}
+		// numeric labels are numbered per file, keep them apart
+		for i := range line {
+			if n, _, ok := strings.Cut(line[i].val, "@"); ok && line[i].typ == Identifier && isDecimal(n) {
+				line[i].val += "@" + file
+			}
+		}
+
+		def := -1
+		switch {
+		case isDirective(line, ".data"), isDirective(line, ".text"):
+			section = line[0].val
+		case isLabel(line):
+			kind := SymbolLabel
+			if section == ".data" {
+				kind = SymbolData
+			}
+			define(line[0], kind)
+			def = 0
+		case len(line) >= 2 && line[0].typ == Identifier && line[1].typ == Equals:
+			define(line[0], SymbolData)
+			def = 0
+		case (isDirective(line, ".equ") || isDirective(line, ".macro")) && len(line) >= 2 && line[1].typ == Identifier:
+			kind := SymbolConstant
+			if line[0].val == ".macro" {
+				kind = SymbolMacro
+			}
+			define(line[1], kind)
+			def = 1
+		}
+
+		for i, t := range line {
+			if t.typ == Identifier && i != def {
+				refs = append(refs, Ref{Name: t.val, Pos: t.position(), Length: a.widths[t.position()]})
+			}
+		}
+	}
+	return refs
+}
+
+// NameAt is the symbol name written at pos, if any
+func (a *Analysis) NameAt(pos Position) (Ref, bool) {
+	for _, ref := range a.Refs {
+		if ref.Pos.File == pos.File && ref.Pos.Line == pos.Line && pos.Col >= ref.Pos.Col && pos.Col < ref.Pos.Col+ref.Length {
+			return ref, true
+		}
+	}
+	return Ref{}, false
+}
+
+// Lookup finds the definition of name, the first one if there are several
+func (a *Analysis) Lookup(name string) (Symbol, bool) {
+	for _, sym := range a.Symbols {
+		if sym.Name == name {
+			return sym, true
+		}
+	}
+	return Symbol{}, false
+}
+
+// Uses is every place name is written, its definition included
+func (a *Analysis) Uses(name string) []Ref {
+	var out []Ref
+	for _, ref := range a.Refs {
+		if ref.Name == name {
+			out = append(out, ref)
+		}
+	}
+	return out
+}
+
+// Address is where a label or data name ended up, known when the program
+// assembled
+func (a *Analysis) Address(sym Symbol) (uint8, bool) {
+	if a.Program == nil || (sym.Kind != SymbolLabel && sym.Kind != SymbolData) {
+		return 0, false
+	}
+	for _, row := range a.Program.listing {
+		if row.pos == sym.Pos {
+			return row.addr, true
+		}
+	}
+	addr, ok := a.Program.Symbols[sym.Name]
+	return addr, ok
+}
+
+// Encoding is what the line of file assembled to, in address order. A macro
+// or pseudo-instruction call gives everything it expanded to.
+func (a *Analysis) Encoding(file string, line int) []Encoded {
+	if a.Program == nil {
+		return nil
+	}
+	var out []Encoded
+	for _, row := range a.Program.listing {
+		if row.site.File != file || row.site.Line != line || len(row.words) == 0 {
+			continue
+		}
+		text := row.text
+		if text == "" {
+			text = a.Program.sourceLine(row.pos)
+		}
+		out = append(out, Encoded{Addr: row.addr, Words: row.words, Text: text})
+	}
+	return out
+}
+
+// Keyword is a mnemonic, directive or register of the dialect
+type Keyword struct {
+	Name string
+	// "instruction", "pseudo-instruction", "directive" or "register"
+	Kind string
+	// how it is written, `ADD ra rb`
+	Syntax string
+	Doc    string
+}
+
+// instruction encodings, a and b are the register bits, m the mask and i the
+// immediate byte
+var instructionDocs = []Keyword{
+	{Name: "MOV", Syntax: "MOV ra rb", Doc: "ra = rb. X-type `0000aabb`"},
+	{Name: "CMP", Syntax: "CMP ra rb", Doc: "compare ra with rb, the flag becomes LT 100, EQ 010 or GT 001. X-type `0001aabb`"},
+	{Name: "SHL", Syntax: "SHL ra rb", Doc: "ra = ra << rb. X-type `0010aabb`"},
+	{Name: "SHR", Syntax: "SHR ra rb", Doc: "ra = ra >> rb. X-type `0011aabb`"},
+	{Name: "ADD", Syntax: "ADD ra rb", Doc: "ra = ra + rb. X-type `0100aabb`"},
+	{Name: "SUB", Syntax: "SUB ra rb", Doc: "ra = ra - rb. X-type `0101aabb`"},
+	{Name: "AND", Syntax: "AND ra rb", Doc: "ra = ra & rb. X-type `0110aabb`"},
+	{Name: "ORR", Syntax: "ORR ra rb", Doc: "ra = ra | rb. X-type `0111aabb`"},
+	{Name: "NOT", Syntax: "NOT ra", Doc: "ra = ^ra. Y-type `100000aa`"},
+	{Name: "PSH", Syntax: "PSH ra", Doc: "push ra onto the stack. Y-type `100100aa`"},
+	{Name: "POP", Syntax: "POP ra", Doc: "pop the top of the stack into ra. Y-type `101000aa`"},
+	{Name: "SYS", Syntax: "SYS ra", Doc: "system call ra (exit 0, sleep 1, bank 2) with the argument popped from the stack. Y-type `101100aa`"},
+	{Name: "JMP", Syntax: "JMP mask, addr", Doc: "jump to addr when the flag has a bit of the mask set. Z-type `11000mmm iiiiiiii`"},
+	{Name: "LDI", Syntax: "LDI ra, imm", Doc: "ra = imm. Z-type `1101aa00 iiiiiiii`"},
+	{Name: "LDA", Syntax: "LDA ra, addr", Doc: "ra = memory[addr]. Z-type `1110aa00 iiiiiiii`"},
+	{Name: "STA", Syntax: "STA ra, addr", Doc: "memory[addr] = ra. Z-type `1111aa00 iiiiiiii`"},
+}
+
+var pseudoDocs = map[string]Keyword{
+	"JMPA":  {Syntax: "JMPA label", Doc: "always jump, `CMP R0 R0` then `JMP 010, label`"},
+	"EXIT":  {Syntax: "EXIT imm", Doc: "stop with imm in R0, system call 0"},
+	"SLEEP": {Syntax: "SLEEP imm", Doc: "sleep for imm seconds, system call 1"},
+	"INC":   {Syntax: "INC ra", Doc: "ra = ra + 1, the other general register holds the 1"},
+	"DEC":   {Syntax: "DEC ra", Doc: "ra = ra - 1, the other general register holds the 1"},
+	"CLR":   {Syntax: "CLR ra", Doc: "ra = 0, `SUB ra ra`"},
+	"PUSHI": {Syntax: "PUSHI imm", Doc: "push imm, `LDI R0, imm` then `PSH R0`"},
+}
+
+var directiveDocs = []Keyword{
+	{Name: ".data", Syntax: ".data", Doc: "start of the data section, 16 words from 0x00"},
+	{Name: ".text", Syntax: ".text", Doc: "start of the text section, code from 0x51"},
+	{Name: ".equ", Syntax: ".equ NAME expr", Doc: "define a constant"},
+	{Name: ".entry", Syntax: ".entry label", Doc: "start execution at label instead of main"},
+	{Name: ".global", Syntax: ".global label", Doc: "let other objects use label"},
+	{Name: ".extern", Syntax: ".extern name", Doc: "a label another object exports"},
+	{Name: ".byte", Syntax: ".byte expr, ...", Doc: "one word per expression"},
+	{Name: ".string", Syntax: `.string "text"`, Doc: "the characters, no terminator"},
+	{Name: ".pstring", Syntax: `.pstring "text"`, Doc: "a length byte, then the characters"},
+	{Name: ".fill", Syntax: ".fill count, value", Doc: "count words of value"},
+	{Name: ".zero", Syntax: ".zero count", Doc: "count words of 0"},
+	{Name: ".include", Syntax: `.include "file.asm"`, Doc: "paste in another file, `std/` names the standard library"},
+	{Name: ".macro", Syntax: ".macro name param, ...", Doc: "define a macro, closed by .endm"},
+	{Name: ".endm", Syntax: ".endm", Doc: "end of a macro"},
+	{Name: ".if", Syntax: ".if expr", Doc: "assemble what follows when expr is not zero"},
+	{Name: ".ifdef", Syntax: ".ifdef NAME", Doc: "assemble what follows when NAME is a constant or macro"},
+	{Name: ".ifndef", Syntax: ".ifndef NAME", Doc: "assemble what follows when NAME is not defined"},
+	{Name: ".else", Syntax: ".else", Doc: "the other branch of a conditional"},
+	{Name: ".endif", Syntax: ".endif", Doc: "end of a conditional"},
+}
+
+var registerDocs = []Keyword{
+	{Name: "R0", Doc: "general register, `00`"},
+	{Name: "R1", Doc: "general register, `01`"},
+	{Name: "SP", Doc: "stack pointer, `10`"},
+	{Name: "PC", Doc: "program counter, `11`"},
+}
+
+// Keywords lists the instructions, pseudo-instructions, directives and
+// registers of the dialect with what they do
+func Keywords() []Keyword {
+	var out []Keyword
+	for _, k := range instructionDocs {
+		k.Kind = "instruction"
+		out = append(out, k)
+	}
+	names := make([]string, 0, len(pseudos))
+	for name := range pseudos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		k := pseudoDocs[name]
+		k.Name, k.Kind = name, "pseudo-instruction"
+		if clobbers := pseudos[name].clobbers; len(clobbers) > 0 {
+			k.Doc += ", clobbers " + strings.Join(clobbers, ", ")
+		}
+		out = append(out, k)
+	}
+	for _, k := range directiveDocs {
+		k.Kind = "directive"
+		out = append(out, k)
+	}
+	for _, k := range registerDocs {
+		k.Kind, k.Syntax = "register", k.Name
+		out = append(out, k)
+	}
+	return out
+}
@@ -0,0 +1,121 @@
+package assembler
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_Analyze(t *testing.T) {
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "lib.asm")
+	if err := os.WriteFile(lib, []byte(".macro twice r\n\tADD r r\n.endm\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	src := `.include "lib.asm"
+.equ N 0x05
+.data
+count = N
+.text
+main:
+	LDA R0, count
+.loop:
+	twice R0
+	JMP 010, .loop
+	JMPA main
+`
+	path := filepath.Join(dir, "prog.asm")
+	a := Analyze(path, src, Options{Lint: true})
+	if a.Program == nil {
+		t.Fatalf("expected the program to assemble, got %v", a.Problems)
+	}
+	if len(a.Problems) != 0 {
+		t.Fatalf("expected no problems, got %v", a.Problems)
+	}
+
+	var names []string
+	for _, sym := range a.Symbols {
+		names = append(names, sym.Kind+" "+sym.DisplayName())
+	}
+	want := "constant N, data count, label main, label main.loop, macro twice"
+	if got := strings.Join(names, ", "); got != want {
+		t.Fatalf("Symbols = %s, want %s", got, want)
+	}
+
+	// the reference to .loop on line 10 leads to its definition
+	ref, ok := a.NameAt(Position{File: path, Line: 10, Col: 12})
+	if !ok || ref.Name != "main.loop" || ref.Definition {
+		t.Fatalf("NameAt() = %+v, %v", ref, ok)
+	}
+	sym, ok := a.Lookup(ref.Name)
+	if !ok || sym.Pos != (Position{File: path, Line: 8, Col: 1}) || sym.Length != 5 {
+		t.Fatalf("Lookup() = %+v, %v", sym, ok)
+	}
+	if addr, ok := a.Address(sym); !ok || addr != 0x53 {
+		t.Fatalf("Address(.loop) = 0x%02X, %v, want 0x53", addr, ok)
+	}
+	if n := len(a.Uses("main.loop")); n != 2 {
+		t.Fatalf("expected .loop to be written twice, got %d", n)
+	}
+	if n := len(a.Uses("main")); n != 2 {
+		t.Fatalf("expected main to be written twice, got %d", n)
+	}
+
+	// the macro is defined in the included file
+	mac, ok := a.Lookup("twice")
+	if !ok || mac.Pos.File != lib || mac.Detail != ".macro twice r" {
+		t.Fatalf("Lookup(twice) = %+v, %v", mac, ok)
+	}
+
+	enc := a.Encoding(path, 9)
+	if len(enc) != 1 || enc[0].Addr != 0x53 || enc[0].Text != "ADD R0 R0" || enc[0].Words[0] != 0x40 {
+		t.Fatalf("Encoding(twice R0) = %+v", enc)
+	}
+	if enc := a.Encoding(path, 11); len(enc) != 2 || enc[0].Text != "CMP R0 R0" || enc[1].Text != "JMP 010, main" {
+		t.Fatalf("Encoding(JMPA main) = %+v", enc)
+	}
+}
+
+func Test_AnalyzeProblems(t *testing.T) {
+	// a broken source still has its symbols
+	a := Analyze("bad.asm", ".text\nmain:\n\tLDI R0, missing\n\tJMPA main\n", Options{})
+	if a.Program != nil || len(a.Problems) != 1 {
+		t.Fatalf("expected one error, got %+v", a.Problems)
+	}
+	if p := a.Problems[0]; p.Warning || p.Pos.Line != 3 || !strings.Contains(p.Message, "missing") {
+		t.Fatalf("unexpected problem %+v", p)
+	}
+	if _, ok := a.Lookup("main"); !ok {
+		t.Fatal("expected main to be found in a source with errors")
+	}
+
+	a = Analyze("warn.asm", ".text\nmain:\n\tEXIT 0\nunused:\n\tEXIT 1\n", Options{Lint: true})
+	if len(a.Problems) != 1 {
+		t.Fatalf("expected one warning, got %+v", a.Problems)
+	}
+	p := a.Problems[0]
+	if !p.Warning || p.Rule != RuleUnusedLabel || p.Pos.Line != 4 || p.Length != len("unused") {
+		t.Fatalf("unexpected warning %+v", p)
+	}
+}
+
+func Test_Keywords(t *testing.T) {
+	seen := map[string]bool{}
+	for _, k := range Keywords() {
+		if k.Syntax == "" || k.Doc == "" {
+			t.Errorf("%s %s is not documented", k.Kind, k.Name)
+		}
+		seen[k.Name] = true
+	}
+	for name := range pseudos {
+		if !seen[name] {
+			t.Errorf("pseudo-instruction %s is missing", name)
+		}
+	}
+	for name := range directives {
+		if !seen[name] {
+			t.Errorf("directive %s is missing", name)
+		}
+	}
+}
@@ -3,10 +3,10 @@ package assembler
 import (
 	"bufio"
 	"fmt"
-	"os"
+	"io"
 	"regexp"
+	"sort"
 	"strings"
-	g "tcp-vm/shared/globals"
 	"tcp-vm/shared/util"
 )
 
@@ -25,7 +25,12 @@ const (
 	Comma
 	Equals
 	Colon
+	Operator
+	Paren
+	String
 	Unknown
+	// the rest of a line from `#`, only kept by lexTrivia
+	Comment
 )
 
 func (tt ttype) String() string {
@@ -54,39 +59,98 @@ func (tt ttype) String() string {
 		return "ttype.Equals"
 	case Colon:
 		return "ttype.Colon"
+	case Operator:
+		return "ttype.Operator"
+	case Paren:
+		return "ttype.Paren"
+	case String:
+		return "ttype.String"
+	case Comment:
+		return "ttype.Comment"
 	default:
 		return "ttype.Unknown"
 	}
 }
 
 type token struct {
-	val string
-	typ ttype
-	lin int
+	val  string
+	typ  ttype
+	file string
+	lin  int
+	col  int
+	exp  *expansion // set when the token was pasted in by a macro
 }
 
 func (t token) String() string {
 	return fmt.Sprintf("token('%s'/%v/%d)", t.val, t.typ, t.lin)
 }
 
-func Assemble(sourcePath string) ([g.DataSectionLength]byte, [g.TextSectionLength]byte, error) {
-	logTag := "tcp-vm/shared/assembler - assembler.go - Assemble()"
-	util.LogStart(logTag)
-	defer util.LogEnd(logTag)
+// where the token came from, used to prefix error messages
+func (t token) pos() string {
+	at := fmt.Sprintf("line %d:%d", t.lin, t.col)
+	if t.file != "" {
+		at = fmt.Sprintf("%s:%d:%d", t.file, t.lin, t.col)
+	}
+	if t.exp != nil {
+		return fmt.Sprintf("%s (%v)", at, t.exp)
+	}
+	return at
+}
 
-	tokens, err := lex(sourcePath)
-	if err != nil {
-		return ErrorData, ErrorText, fmt.Errorf("lex() failed: %v", err)
+func (t token) position() Position {
+	return Position{
+		File: t.file,
+		Line: t.lin,
+		Col:  t.col,
 	}
+}
 
-	g, err := newGrammar()
+// Options change how a program is assembled, the zero value assembles a single
+// file that only includes files relative to itself
+type Options struct {
+	// directories searched for `.include` files that are not found next to
+	// the file including them, in order
+	IncludePaths []string
+	// constants set from outside the source, `-D NAME=value`. They are
+	// defined before the first line and can be tested with .if and .ifdef.
+	Defines map[string]int
+	// run the peephole optimizer over the text section, see optimize
+	Optimize bool
+	// add the lint warnings to Program.Warnings, see lintProgram
+	Lint bool
+}
+
+// assemble parses and compiles tokens that have been through the preprocessor
+func assemble(tokens []token, opts Options) (*Program, error) {
+	simp, err := parseTokens(tokens)
+	if err != nil {
+		return nil, err
+	}
+	var optimized []Optimization
+	if opts.Optimize {
+		optimized = optimize(simp)
+	}
+	syms := newSymbolTable()
+	prog, err := simp.compileWith(syms)
 	if err != nil {
-		return ErrorData, ErrorText, fmt.Errorf("newGrammar() failed: %v", err)
+		return nil, fmt.Errorf("compile() filed: %w", err)
 	}
+	prog.Optimizations = optimized
+	if opts.Lint {
+		prog.Warnings = append(prog.Warnings, lintProgram(simp, syms)...)
+	}
+	return prog, nil
+}
 
-	llpt, err := newLLParseTable(*g)
+// parseTokens parses tokens and simplifies the tree for compile
+func parseTokens(tokens []token) (*syntaxTree, error) {
+	logTag := "tcp-vm/shared/assembler - assembler.go - Assemble()"
+	util.LogStart(logTag)
+	defer util.LogEnd(logTag)
+
+	llpt, err := parseTable()
 	if err != nil {
-		return ErrorData, ErrorText, fmt.Errorf("newLLParseTable() failed: %v", err)
+		return nil, err
 	}
 
 	start := grammarItem{
@@ -96,7 +160,7 @@ func Assemble(sourcePath string) ([g.DataSectionLength]byte, [g.TextSectionLengt
 
 	st, err := llpt.llTabularParse(tokens, start)
 	if err != nil {
-		return ErrorData, ErrorText, fmt.Errorf("llTabularParse() failed: %v", err)
+		return nil, fmt.Errorf("llTabularParse() failed: %w", err)
 	}
 
 	util.LogMessage(func() {
@@ -104,37 +168,92 @@ func Assemble(sourcePath string) ([g.DataSectionLength]byte, [g.TextSectionLengt
 		st.prettyPrint()
 	})
 
-	if simp := st.applySDT(); simp != nil {
-		util.LogMessage(func() {
-			fmt.Println("AST:")
-			simp.prettyPrint()
-		})
+	simp := st.applySDT()
+	if simp == nil {
+		return nil, fmt.Errorf("appltSDT() returned nil")
+	}
+	util.LogMessage(func() {
+		fmt.Println("AST:")
+		simp.prettyPrint()
+	})
+	return simp, nil
+}
 
-		data, text, err := simp.compile()
-		if err != nil {
-			return ErrorData, ErrorText, fmt.Errorf("compile() filed: %v", err)
-		}
+// parseTable builds the LL(1) table of the grammar
+func parseTable() (*llParseTable, error) {
+	g, err := newGrammar()
+	if err != nil {
+		return nil, fmt.Errorf("newGrammar() failed: %v", err)
+	}
+	llpt, err := newLLParseTable(*g)
+	if err != nil {
+		return nil, fmt.Errorf("newLLParseTable() failed: %v", err)
+	}
+	return llpt, nil
+}
+
+func lex(sourcePath string, opts Options) ([]token, error) {
+	pp := newPreprocessor(opts)
+	lines, err := pp.include(sourcePath, nil)
+	if err != nil {
+		return nil, err
+	}
+	return pp.finish(lines)
+}
 
-		return data, text, nil
+func flatten(lines [][]token) []token {
+	var tokens []token
+	for _, line := range lines {
+		tokens = append(tokens, line...)
 	}
+	return tokens
+}
 
-	return ErrorData, ErrorText, fmt.Errorf("appltSDT() returned nil")
+// lexLines tokenizes source one line at a time, blank and comment only lines
+// are dropped so every line holds at least one token. file is only used for
+// positions.
+func lexLines(r io.Reader, file string) ([][]token, error) {
+	all, err := lexTrivia(r, file)
+	if err != nil {
+		return nil, err
+	}
+	var lines [][]token
+	for _, line := range all {
+		if n := len(line); n > 0 && line[n-1].typ == Comment {
+			line = line[:n-1]
+		}
+		if len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
 }
 
-func lex(sourcePath string) ([]token, error) {
+// lexTrivia tokenizes every line of the source, a blank line is an empty line
+// and a comment is a Comment token at the end of its line
+func lexTrivia(r io.Reader, file string) ([][]token, error) {
 	tokenSpecs := map[ttype]string{
-		Section:    `\..*`,
-		CommandX:   `(MOV|CMP|SHL|SHR|ADD|SUB|AND|ORR)`,
-		CommandY:   `(NOT|PSH|POP|SYS)`,
-		CommandZ:   `(LDI|LDA|STA)`,
-		CommandZJ:  `(JMP)`,
-		Register:   `(R\d|PC|SP)`,
-		Mask:       `[0-1]{3}`,
-		Immediate:  `0x[A-F0-9]{2}`,
-		Identifier: `[a-z]*`,
+		// a name that is not a directive is a local label, see scopeLabels
+		Section:   `\.[A-Za-z_][A-Za-z0-9_]*`,
+		CommandX:  `(MOV|CMP|SHL|SHR|ADD|SUB|AND|ORR)`,
+		CommandY:  `(NOT|PSH|POP|SYS)`,
+		CommandZ:  `(LDI|LDA|STA)`,
+		CommandZJ: `(JMP)`,
+		Register:  `(R\d|PC|SP)`,
+		Mask:      `[0-1]{3}`,
+		// the longest run that starts like a number or a char, parseLiteral
+		// tells a malformed one like `12ab` or `'AB'` apart
+		Immediate: `(\d[0-9A-Za-z_]*|'(\\.|[^'\\])*'?)`,
+		// mnemonics and registers also match, they win the tie below. `1f`
+		// and `1b` refer to numeric local labels.
+		Identifier: `([A-Za-z_][A-Za-z0-9_]*|\d+[fb]\b)`,
 		Comma:      `,`,
 		Equals:     `=`,
 		Colon:      `:`,
+		// a leading - is an operator too, see exprAtom in the grammar
+		Operator: `(<<|>>|==|!=|<=|>=|[-+*/&|<>])`,
+		Paren:    `[()]`,
+		String:   `"(\\.|[^"\\])*"`,
 	}
 
 	type spec struct {
@@ -157,36 +276,31 @@ func lex(sourcePath string) ([]token, error) {
 			re:  re,
 		})
 	}
+	// equal length matches go to the earlier ttype, a mask beats a decimal
+	// and `MOV` is a command, `MOVE` is still an identifier
+	sort.Slice(specs, func(i, j int) bool {
+		return specs[i].typ < specs[j].typ
+	})
 
-	f, err := os.Open(sourcePath)
-	if err != nil {
-		return nil, fmt.Errorf("opening file: %v", err)
-	}
-	defer f.Close()
-
-	var tokens []token
-	scanner := bufio.NewScanner(f)
+	var lines [][]token
+	scanner := bufio.NewScanner(r)
 	line_number := 0
 
 	// TODO: make a more robust loop
 	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
+		line := scanner.Text()
 		line_number += 1
 
-		// convert comment lines to empty and remove inline comments
-		if idx := strings.Index(line, "#"); idx != -1 {
-			line = line[:idx]
-		}
-
-		// remove empty lines
-		if line == "" {
-			continue
-		}
+		// the comment is lexed last, whatever it holds
+		full := line
+		line = stripComment(line)
 
+		var tokens []token
 		pos := 0
+		prevType := Unknown
 		for pos < len(line) {
 			// skip spaces or tabs
-			if c := line[pos]; c == ' ' || c == '\t' {
+			if c := line[pos]; c == ' ' || c == '\t' || c == '\r' {
 				pos += 1
 				continue
 			}
@@ -196,6 +310,10 @@ func lex(sourcePath string) ([]token, error) {
 			bestType := Unknown
 
 			for _, s := range specs {
+				// masks only appear as the first operand of JMP
+				if s.typ == Mask && prevType != CommandZJ {
+					continue
+				}
 				if m := s.re.FindString(sub); len(m) > len(best) {
 					best = m
 					bestType = s.typ
@@ -207,20 +325,59 @@ func lex(sourcePath string) ([]token, error) {
 				best = sub[:1]
 				bestType = Unknown
 			}
+			if bestType == Section && !directives[best] {
+				bestType = Identifier
+			}
+			if _, _, ok := numericRef(best); ok && bestType == Immediate {
+				bestType = Identifier
+			}
 
 			tokens = append(tokens, token{
-				val: best,
-				typ: bestType,
-				lin: line_number,
+				val:  best,
+				typ:  bestType,
+				file: file,
+				lin:  line_number,
+				col:  pos + 1,
 			})
 
 			pos += len(best)
+			prevType = bestType
+		}
+		if len(line) < len(full) {
+			tokens = append(tokens, token{
+				val:  strings.TrimRight(full[len(line):], " \t\r"),
+				typ:  Comment,
+				file: file,
+				lin:  line_number,
+				col:  len(line) + 1,
+			})
 		}
+		lines = append(lines, tokens)
 	}
 
 	if err := scanner.Err(); err != nil {
 		return nil, fmt.Errorf("reading file: %v", err)
 	}
 
-	return tokens, nil
+	return lines, nil
+}
+
+// cut a trailing # comment, a # inside a quoted literal is kept
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0 && c == '\\':
+			i++ // skip the escaped character
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
 }
@@ -2,6 +2,7 @@ package assembler
 
 import (
 	"fmt"
+	"os"
 	"testing"
 )
 
@@ -13,10 +14,115 @@ func Test_lex(t *testing.T) {
 
 	for _, file := range files {
 		fmt.Printf("lexing file: %s\n", file)
-		tokens, err := lex(file)
+		tokens, err := lex(file, Options{})
 		if err != nil {
 			t.Fatalf("lexing error: %v", err)
 		}
 		fmt.Printf("lexed tokens: %+v\n", tokens)
 	}
 }
+
+func Test_parseImmediate(t *testing.T) {
+	valid := map[string]uint8{
+		"0x05":  0x05,
+		"0xff":  0xFF,
+		"0X1f":  0x1F,
+		"0b101": 5,
+		"42":    42,
+		"255":   255,
+		"-1":    0xFF,
+		"-128":  0x80,
+		"'A'":   'A',
+		"'\\n'": '\n',
+		"'\\''": '\'',
+	}
+	for lit, want := range valid {
+		got, err := parseImmediate(lit)
+		if err != nil {
+			t.Fatalf("parseImmediate(%s) failed: %v", lit, err)
+		}
+		if got != want {
+			t.Fatalf("parseImmediate(%s) = %d, want %d", lit, got, want)
+		}
+	}
+
+	invalid := []string{"256", "-129", "0x100", "0b111111111", "'ab'", "'\\q'", "x"}
+	for _, lit := range invalid {
+		if _, err := parseImmediate(lit); err == nil {
+			t.Fatalf("parseImmediate(%s) should have failed", lit)
+		}
+	}
+}
+
+func Test_lexMaskContext(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "*.asm")
+	if err != nil {
+		t.Fatalf("CreateTemp() failed: %v", err)
+	}
+	f.WriteString("JMP 010, main\nLDI R0, 010 # '#' is not a comment in quotes\nLDI R1, '#'\n")
+	f.Close()
+
+	tokens, err := lex(f.Name(), Options{})
+	if err != nil {
+		t.Fatalf("lex() failed: %v", err)
+	}
+
+	want := []ttype{
+		CommandZJ, Mask, Comma, Identifier,
+		CommandZ, Register, Comma, Immediate,
+		CommandZ, Register, Comma, Immediate,
+	}
+	if len(tokens) != len(want) {
+		t.Fatalf("expected %d tokens, got %d: %v", len(want), len(tokens), tokens)
+	}
+	for i, tok := range tokens {
+		if tok.typ != want[i] {
+			t.Fatalf("token %d: expected %v, got %v", i, want[i], tok)
+		}
+	}
+}
+
+func Test_lexIdentifiers(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "*.asm")
+	if err != nil {
+		t.Fatalf("CreateTemp() failed: %v", err)
+	}
+	f.WriteString("loop_start: print2 Buffer _tmp MOVE MOV R0x R0 SPx SP register\n")
+	f.Close()
+
+	tokens, err := lex(f.Name(), Options{})
+	if err != nil {
+		t.Fatalf("lex() failed: %v", err)
+	}
+
+	want := []ttype{
+		Identifier, Colon, Identifier, Identifier, Identifier,
+		Identifier, CommandX, Identifier, Register, Identifier, Register,
+		Identifier,
+	}
+	if len(tokens) != len(want) {
+		t.Fatalf("expected %d tokens, got %d: %v", len(want), len(tokens), tokens)
+	}
+	for i, tok := range tokens {
+		if tok.typ != want[i] {
+			t.Fatalf("token %d: expected %v, got %v", i, want[i], tok)
+		}
+	}
+}
+
+func Test_assembleIdentifiers(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "*.asm")
+	if err != nil {
+		t.Fatalf("CreateTemp() failed: %v", err)
+	}
+	f.WriteString(".data\nBuffer = 0x01\nregister = 0x02\n.text\nmain:\nloop_2:\n\tLDA R0, Buffer\n\tLDA R1, register\n\tJMP 010, loop_2\n")
+	f.Close()
+
+	labels, err := Symbols(f.Name())
+	if err != nil {
+		t.Fatalf("Symbols() failed: %v", err)
+	}
+	if labels["Buffer"] != 0 || labels["register"] != 1 || labels["loop_2"] != 81 {
+		t.Fatalf("unexpected labels: %v", labels)
+	}
+}
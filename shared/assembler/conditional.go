@@ -0,0 +1,238 @@
+package assembler
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Conditional assembly keeps or drops lines before they are parsed:
+//
+//	.ifdef TRACE        # defined with -D or .equ above
+//	.if DEPTH > 4       # a constant expression, nonzero is true
+//	.ifndef DEPTH       # the opposite of .ifdef
+//	.else
+//	.endif
+//
+// Conditions may nest and have to be closed in the file or macro body that
+// opened them. .if can use -D defines and .equ constants defined above it
+// that do not depend on labels, those are only known once the program is laid
+// out.
+
+// an open .if, .ifdef or .ifndef
+type conditional struct {
+	at     token
+	parent bool // the lines around the .if are assembled
+	taking bool // the lines of the current branch are assembled
+	inElse bool
+}
+
+func isConditional(line []token) bool {
+	if len(line) == 0 || line[0].typ != Section {
+		return false
+	}
+	switch line[0].val {
+	case ".if", ".ifdef", ".ifndef", ".else", ".endif":
+		return true
+	}
+	return false
+}
+
+// assembling reports whether lines under the open conditions are kept
+func assembling(conds []conditional) bool {
+	return len(conds) == 0 || conds[len(conds)-1].taking
+}
+
+// conditional runs one of the conditional directives
+func (pp *preprocessor) conditional(conds []conditional, line []token) ([]conditional, error) {
+	dir := line[0]
+	switch dir.val {
+	case ".if", ".ifdef", ".ifndef":
+		c := conditional{at: dir, parent: assembling(conds)}
+		// a condition that is skipped anyway is not looked at, it may use
+		// names that are only defined in the other branch
+		if c.parent {
+			ok, err := pp.test(line)
+			if err != nil {
+				return nil, err
+			}
+			c.taking = ok
+		}
+		return append(conds, c), nil
+
+	case ".else":
+		if len(line) > 1 {
+			return nil, errorfToken(line[1], ".else takes no operand")
+		}
+		if len(conds) == 0 {
+			return nil, errorfToken(dir, ".else without .if")
+		}
+		c := &conds[len(conds)-1]
+		if c.inElse {
+			return nil, errorfToken(dir, "second .else for the %s at %s", c.at.val, c.at.pos())
+		}
+		c.inElse = true
+		c.taking = c.parent && !c.taking
+		return conds, nil
+
+	default: // .endif
+		if len(line) > 1 {
+			return nil, errorfToken(line[1], ".endif takes no operand")
+		}
+		if len(conds) == 0 {
+			return nil, errorfToken(dir, ".endif without .if")
+		}
+		return conds[:len(conds)-1], nil
+	}
+}
+
+// test works out the condition of an .if, .ifdef or .ifndef line
+func (pp *preprocessor) test(line []token) (bool, error) {
+	dir := line[0]
+	if dir.val == ".if" {
+		if len(line) == 1 {
+			return false, errorfToken(dir, ".if takes an expression")
+		}
+		v, err := pp.eval(line[1:])
+		if err != nil {
+			return false, errorfToken(dir, "in %s: %v", dir.val, err)
+		}
+		return v != 0, nil
+	}
+
+	if len(line) != 2 || line[1].typ != Identifier {
+		return false, errorfToken(dir, "%s takes a name", dir.val)
+	}
+	name := line[1].val
+	_, define := pp.defines[name]
+	defined := define || pp.equs[name] || pp.macros[name] != nil
+	return defined == (dir.val == ".ifdef"), nil
+}
+
+// record notes an .equ that is being assembled so later conditions can test
+// and use it
+func (pp *preprocessor) record(line []token) {
+	if len(line) < 3 || line[1].typ != Identifier {
+		// the parser reports it
+		return
+	}
+	name := line[1].val
+	pp.equs[name] = true
+	if v, err := pp.eval(line[2:]); err == nil {
+		pp.values[name] = v
+	}
+}
+
+// eval parses toks as an expression and works it out with the constants known
+// so far
+func (pp *preprocessor) eval(toks []token) (int, error) {
+	if pp.table == nil {
+		table, err := parseTable()
+		if err != nil {
+			return 0, err
+		}
+		pp.table = table
+	}
+
+	st, err := pp.table.llTabularParse(toks, grammarItem{Value: "expr", Type: NonTerminal})
+	if err != nil {
+		return 0, err
+	}
+	expr := st.applySDT()
+	if expr == nil {
+		return 0, fmt.Errorf("empty expression")
+	}
+
+	syms := newSymbolTable()
+	for name, v := range pp.values {
+		// already worked out, lookup never looks at the node
+		syms.equs[name] = nil
+		syms.consts[name] = v
+	}
+	v, err := syms.eval(expr)
+	if err != nil && strings.HasPrefix(err.Error(), "undefined") {
+		return 0, fmt.Errorf("%v, conditions can only use -D defines and constants above them that do not use labels", err)
+	}
+	return v, err
+}
+
+// finish turns the processed lines of a program into tokens for the parser:
+// the -D defines go first as .equ lines and local labels are renamed
+func (pp *preprocessor) finish(lines [][]token) ([]token, error) {
+	names := make([]string, 0, len(pp.defines))
+	for name := range pp.defines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var defs [][]token
+	for i, name := range names {
+		at := token{file: "command line", lin: i + 1, col: 1}
+		equ, id, v := at, at, at
+		equ.val, equ.typ = ".equ", Section
+		id.val, id.typ = name, Identifier
+		v.val, v.typ = fmt.Sprint(pp.defines[name]), Immediate
+		if pp.defines[name] < 0 {
+			// negative literals are an operator and a number
+			minus := at
+			minus.val, minus.typ = "-", Operator
+			v.val = fmt.Sprint(-pp.defines[name])
+			defs = append(defs, []token{equ, id, minus, v})
+			continue
+		}
+		defs = append(defs, []token{equ, id, v})
+	}
+	lines = append(defs, lines...)
+
+	if err := scopeLabels(lines); err != nil {
+		return nil, err
+	}
+	return flatten(lines), nil
+}
+
+var defineName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ParseDefine reads a `-D` flag, `NAME=value` or just `NAME` for 1. The value
+// is a literal as in the source, `0x10`, `0b101`, `'A'` or a decimal, and may
+// be negative.
+func ParseDefine(def string) (string, int, error) {
+	name, lit, hasValue := strings.Cut(def, "=")
+	if !defineName.MatchString(name) {
+		return "", 0, fmt.Errorf("invalid define name '%s'", name)
+	}
+	if !hasValue {
+		return name, 1, nil
+	}
+	neg := strings.HasPrefix(lit, "-")
+	v, err := parseLiteral(strings.TrimPrefix(lit, "-"))
+	if err != nil {
+		return "", 0, fmt.Errorf("define %s: %v", name, err)
+	}
+	if neg {
+		v = -v
+	}
+	return name, v, nil
+}
+
+// DefineFlag collects every `-D` flag for Options.Defines, a later one for the
+// same name wins. Use it with flag.Var.
+type DefineFlag map[string]int
+
+func (d DefineFlag) String() string {
+	var defs []string
+	for name, v := range d {
+		defs = append(defs, fmt.Sprintf("%s=%d", name, v))
+	}
+	sort.Strings(defs)
+	return strings.Join(defs, ", ")
+}
+
+func (d DefineFlag) Set(def string) error {
+	name, v, err := ParseDefine(def)
+	if err != nil {
+		return err
+	}
+	d[name] = v
+	return nil
+}
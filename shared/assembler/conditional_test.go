@@ -0,0 +1,131 @@
+package assembler
+
+import (
+	"strings"
+	"tcp-vm/shared/vm"
+	"testing"
+)
+
+func Test_conditional(t *testing.T) {
+	src := `.ifndef DEPTH
+.equ DEPTH 2
+.endif
+.equ TWICE DEPTH * 2
+.macro leave code
+.if code > 0x0F
+	EXIT 0x0F
+.else
+	EXIT code
+.endif
+.endm
+.text
+main:
+.ifdef TRACE
+.if TWICE > 4
+	leave 0x30
+.else
+	leave TWICE + 1
+.endif
+.else
+	leave DEPTH
+.endif
+`
+	tests := []struct {
+		name    string
+		defines map[string]int
+		want    vm.Register
+	}{
+		{"release", nil, 2},
+		{"trace", map[string]int{"TRACE": 1}, 5},
+		{"deep trace", map[string]int{"TRACE": 1, "DEPTH": 3}, 0x0F},
+		{"deep release", map[string]int{"DEPTH": -1}, 0xFF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prog, err := AssembleString("cond.asm", src, Options{Defines: tt.defines})
+			if err != nil {
+				t.Fatalf("AssembleString() failed: %s", Explain(err))
+			}
+			machine := new(vm.VirtualMachine)
+			machine.ResetFromStateless(prog.Data, prog.Text, prog.Entry)
+			if err := machine.RunUntilStop(); err != nil {
+				t.Fatalf("RunUntilStop() failed: %v", err)
+			}
+			if machine.R0 != tt.want {
+				t.Fatalf("exit code = %d, want %d", machine.R0, tt.want)
+			}
+		})
+	}
+}
+
+func Test_conditionalErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{".if 1\n.text\n", "bad.asm:1:1: .if without .endif"},
+		{".else\n", "bad.asm:1:1: .else without .if"},
+		{".endif\n", "bad.asm:1:1: .endif without .if"},
+		{".if 0\n.else\n.else\n.endif\n", "bad.asm:3:1: second .else for the .if at bad.asm:1:1"},
+		{".ifdef 3\n.endif\n", "bad.asm:1:1: .ifdef takes a name"},
+		{".if\n.endif\n", "bad.asm:1:1: .if takes an expression"},
+		{".if 1 2\n.endif\n", "bad.asm:1:1: in .if: bad.asm:1:7: unexpected '2'"},
+		{".text\nmain:\n.if main\n.endif\n", "undefined label or constant 'main', conditions can only use"},
+		{".macro m\n.if 1\n.endm\n.text\nmain:\n\tm\n.endif\n", "expanded at bad.asm:6:2): .if without .endif"},
+	}
+	for _, tt := range tests {
+		_, err := AssembleString("bad.asm", tt.src, Options{})
+		if err == nil {
+			t.Fatalf("%q: expected an error", tt.src)
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Fatalf("%q: error = %q, want %q", tt.src, err, tt.want)
+		}
+	}
+
+	// a skipped branch is not looked at
+	src := ".if 0\n.if UNDEFINED\n.endif\n.endif\n.text\nmain:\n\tEXIT 0x00\n"
+	if _, err := AssembleString("skip.asm", src, Options{}); err != nil {
+		t.Fatalf("AssembleString() failed: %s", Explain(err))
+	}
+}
+
+func Test_ParseDefine(t *testing.T) {
+	tests := []struct {
+		def   string
+		name  string
+		value int
+		err   bool
+	}{
+		{"TRACE", "TRACE", 1, false},
+		{"DEPTH=4", "DEPTH", 4, false},
+		{"BASE=0x10", "BASE", 16, false},
+		{"OFF=-3", "OFF", -3, false},
+		{"1X=2", "", 0, true},
+		{"X=abc", "", 0, true},
+	}
+	for _, tt := range tests {
+		name, v, err := ParseDefine(tt.def)
+		if (err != nil) != tt.err {
+			t.Fatalf("ParseDefine(%q) error = %v, want error %v", tt.def, err, tt.err)
+		}
+		if name != tt.name || v != tt.value {
+			t.Fatalf("ParseDefine(%q) = %s, %d, want %s, %d", tt.def, name, v, tt.name, tt.value)
+		}
+	}
+}
+
+func Test_DefineFlag(t *testing.T) {
+	d := DefineFlag{}
+	for _, def := range []string{"TRACE", "DEPTH=2", "DEPTH=0x04"} {
+		if err := d.Set(def); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := d.String(); got != "DEPTH=4, TRACE=1" {
+		t.Fatalf("String() = %s, want DEPTH=4, TRACE=1", got)
+	}
+	if err := d.Set("1X"); err == nil {
+		t.Fatal("expected an error for an invalid name")
+	}
+}
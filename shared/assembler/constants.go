@@ -2,6 +2,7 @@ package assembler
 
 import (
 	"os"
+	"regexp"
 	"strings"
 	g "tcp-vm/shared/globals"
 )
@@ -17,19 +18,55 @@ var LOG_PARSED_GRAMMAR_OBJECT bool
 
 var grammarText string
 
+// every directive, a `.name` that is not one of them is a local label
+var directives = map[string]bool{
+	".include": true,
+	".macro":   true,
+	".endm":    true,
+	".if":      true,
+	".ifdef":   true,
+	".ifndef":  true,
+	".else":    true,
+	".endif":   true,
+}
+
 func init() {
 	LOG_PARSED_GRAMMAR_OBJECT = os.Getenv("LOG_PARSED") != ""
 
 	grammarText = strings.TrimSpace(`
-asm -> data text $
+asm -> defs data text $
+
+defs -> equ defs
+defs -> linkage defs
+defs -> lambda
+
+equ -> .equ identifier expr
+
+linkage -> .global identifier
+linkage -> .extern identifier
 
 data -> .data dataList
 data -> lambda
 
 dataList -> dataItem dataList
+dataList -> directive dataList
+dataList -> equ dataList
+dataList -> linkage dataList
 dataList -> lambda
 
-dataItem -> identifier = immediate
+dataItem -> identifier dataValue
+
+dataValue -> = expr
+dataValue -> :
+
+directive -> .byte expr exprMore
+directive -> .string string
+directive -> .pstring string
+directive -> .fill expr , expr
+directive -> .zero expr
+
+exprMore -> , expr exprMore
+exprMore -> lambda
 
 text -> .text textList
 text -> lambda
@@ -38,8 +75,14 @@ textList -> identifier : textList
 textList -> xInstruction textList
 textList -> yInstruction textList
 textList -> zInstruction textList
+textList -> entry textList
+textList -> directive textList
+textList -> equ textList
+textList -> linkage textList
 textList -> lambda
 
+entry -> .entry identifier
+
 xInstruction -> CommandX register register
 
 yInstruction -> CommandY register
@@ -47,7 +90,23 @@ yInstruction -> CommandY register
 zInstruction -> CommandZ register , zItem
 zInstruction -> CommandZJ mask , zItem
 
-zItem -> immediate
-zItem -> identifier
+zItem -> expr
+
+expr -> exprAtom exprTail
+
+exprTail -> operator exprAtom exprTail
+exprTail -> lambda
+
+exprAtom -> immediate
+exprAtom -> identifier exprCall
+exprAtom -> ( expr )
+exprAtom -> - exprAtom
+
+exprCall -> ( expr )
+exprCall -> lambda
 	`)
+
+	for _, d := range regexp.MustCompile(`\.[a-z]+`).FindAllString(grammarText, -1) {
+		directives[d] = true
+	}
 }
@@ -0,0 +1,153 @@
+package assembler
+
+import (
+	"fmt"
+	g "tcp-vm/shared/globals"
+)
+
+// data directives (.byte, .string, .pstring, .fill, .zero) may appear in
+// either section. Their size is fixed when the section is laid out, their
+// values are worked out once every label is known.
+
+// the expressions given to .byte, exprMore holds every one after the first
+func directiveArgs(dir *syntaxTree) []*syntaxTree {
+	var args []*syntaxTree
+	for _, c := range dir.Children[1:] {
+		if c.Symbol.Value == "exprMore" {
+			args = append(args, c.Children...)
+			continue
+		}
+		args = append(args, c)
+	}
+	return args
+}
+
+// repeat count for .fill and .zero, anything larger than .text cannot fit
+func (syms *symbolTable) repeat(node *syntaxTree) (int, error) {
+	n, err := syms.eval(node)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 || n > g.TextSectionLength {
+		return 0, fmt.Errorf("count %d must be 0 to %d", n, g.TextSectionLength)
+	}
+	return n, nil
+}
+
+// layout returns the words a directive takes up and how many elements it
+// holds, a length prefix is not an element
+func (syms *symbolTable) layout(dir *syntaxTree) (int, int, error) {
+	switch dir.Children[0].Data {
+	case ".byte":
+		n := len(directiveArgs(dir))
+		return n, n, nil
+	case ".string", ".pstring":
+		s, err := parseString(dir.Children[1].Data)
+		if err != nil {
+			return 0, 0, err
+		}
+		if dir.Children[0].Data == ".pstring" {
+			return len(s) + 1, len(s), nil
+		}
+		return len(s), len(s), nil
+	case ".fill", ".zero":
+		n, err := syms.repeat(dir.Children[1])
+		return n, n, err
+	}
+	return 0, 0, fmt.Errorf("unknown data directive '%s'", dir.Children[0].Data)
+}
+
+// encode returns the words of a directive
+func (syms *symbolTable) encode(dir *syntaxTree) ([]byte, error) {
+	var out []byte
+	switch dir.Children[0].Data {
+	case ".byte":
+		for _, arg := range directiveArgs(dir) {
+			b, err := syms.byteValue(arg)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, b)
+		}
+
+	case ".string", ".pstring":
+		s, err := parseString(dir.Children[1].Data)
+		if err != nil {
+			return nil, err
+		}
+		if dir.Children[0].Data == ".pstring" {
+			if len(s) > 255 {
+				return nil, fmt.Errorf("string of %d characters is too long for a length prefix", len(s))
+			}
+			out = append(out, byte(len(s)))
+		}
+		out = append(out, s...)
+
+	case ".fill", ".zero":
+		n, err := syms.repeat(dir.Children[1])
+		if err != nil {
+			return nil, err
+		}
+		var value byte
+		if dir.Children[0].Data == ".fill" {
+			if value, err = syms.byteValue(dir.Children[2]); err != nil {
+				return nil, err
+			}
+		}
+		for range n {
+			out = append(out, value)
+		}
+	}
+	return out, nil
+}
+
+// relocateDirective records a relocation for every word of a directive at
+// offset whose value depends on where the object is placed, see relocate
+func (syms *symbolTable) relocateDirective(section SectionID, offset int, dir *syntaxTree) error {
+	switch dir.Children[0].Data {
+	case ".byte":
+		for i, arg := range directiveArgs(dir) {
+			if err := syms.relocate(section, offset+i, arg); err != nil {
+				return err
+			}
+		}
+	case ".fill":
+		n, err := syms.repeat(dir.Children[1])
+		if err != nil {
+			return err
+		}
+		for i := range n {
+			if err := syms.relocate(section, offset+i, dir.Children[2]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseString reads a double quoted string, escapes are the same as for
+// character literals
+func parseString(lit string) ([]byte, error) {
+	if len(lit) < 2 || lit[0] != '"' || lit[len(lit)-1] != '"' {
+		return nil, fmt.Errorf("malformed string literal %s", lit)
+	}
+	body := lit[1 : len(lit)-1]
+
+	var out []byte
+	for i := 0; i < len(body); i++ {
+		if body[i] != '\\' {
+			out = append(out, body[i])
+			continue
+		}
+		if i+1 == len(body) {
+			return nil, fmt.Errorf("unfinished escape in %s", lit)
+		}
+		c, ok := unescape(body[i+1])
+		if !ok {
+			return nil, fmt.Errorf("unsupported escape '\\%c' in %s", body[i+1], lit)
+		}
+		out = append(out, c)
+		i++
+	}
+	return out, nil
+}
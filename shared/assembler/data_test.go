@@ -0,0 +1,110 @@
+package assembler
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_dataDirectives(t *testing.T) {
+	src := `
+.equ N 3
+.data
+	flag = 0x01
+table:
+	.byte 1, N, N * 2
+greeting: .string "hi\n"
+counted:
+	.pstring "ok"
+pad:
+	.fill N, 0xAA
+	.zero 2
+
+.text
+main:
+	LDI R0, len(greeting)
+	LDI R1, sizeof(counted)
+	LDA R0, counted + 1
+	JMP 111, main
+digits:
+	.byte '0', '1', '2'
+	.string "3"
+`
+	prog, err := AssembleString("", src, Options{})
+	if err != nil {
+		t.Fatalf("AssembleString() failed: %v", err)
+	}
+
+	wantData := []byte{
+		0x01,
+		1, 3, 6,
+		'h', 'i', '\n',
+		2, 'o', 'k',
+		0xAA, 0xAA, 0xAA, 0, 0,
+	}
+	if !bytes.Equal(prog.Data[:len(wantData)], wantData) {
+		t.Fatalf("data = % X, want % X", prog.Data[:len(wantData)], wantData)
+	}
+
+	wantSyms := map[string]uint8{"flag": 0, "table": 1, "greeting": 4, "counted": 7, "pad": 10, "digits": 0x59}
+	for name, addr := range wantSyms {
+		if prog.Symbols[name] != addr {
+			t.Fatalf("Symbols[%s] = 0x%02X, want 0x%02X", name, prog.Symbols[name], addr)
+		}
+	}
+
+	wantText := []byte{
+		0xD0, 3, // LDI R0, len(greeting)
+		0xD4, 3, // LDI R1, sizeof(counted), length prefix included
+		0xE0, 8, // LDA R0, counted + 1
+		0xC7, 0x51,
+		'0', '1', '2', '3', // inline table
+	}
+	if !bytes.Equal(prog.Text[:len(wantText)], wantText) {
+		t.Fatalf("text = % X, want % X", prog.Text[:len(wantText)], wantText)
+	}
+	if prog.SourceMap[0x59].Line != 21 {
+		t.Fatalf("SourceMap[0x59] = %v, want line 21", prog.SourceMap[0x59])
+	}
+}
+
+func Test_tableSize(t *testing.T) {
+	src := ".text\nmain:\n\tLDI R0, len(digits)\n\tLDI R1, sizeof(main)\ndigits:\n\t.byte 1, 2\n\t.pstring \"abc\"\n"
+	_, err := AssembleString("", src, Options{})
+	if err == nil || !strings.Contains(err.Error(), "'main' is not a data label or table") {
+		t.Fatalf("expected sizeof(main) to fail, got %v", err)
+	}
+
+	src = ".text\nmain:\n\tLDI R0, len(digits)\n\tLDI R1, sizeof(digits)\ndigits:\n\t.byte 1, 2\n\t.pstring \"abc\"\n"
+	prog, err := AssembleString("", src, Options{})
+	if err != nil {
+		t.Fatalf("AssembleString() failed: %v", err)
+	}
+	if prog.Text[1] != 5 || prog.Text[3] != 6 {
+		t.Fatalf("len(digits) = %d, sizeof(digits) = %d, want 5 and 6", prog.Text[1], prog.Text[3])
+	}
+}
+
+func Test_dataDirectiveErrors(t *testing.T) {
+	tests := map[string]string{
+		".data\nbig:\n\t.zero 17\n":                                     "line 3:2: data section overflow: exceeds 16 words",
+		".data\na = 1\nb:\n\t.fill 16, 0\n":                             "line 4:2: data section overflow",
+		".data\ns: .string \"0123456789abcdefg\"\n":                     "data section overflow",
+		".data\nx:\n\t.byte 1, 300\n":                                   "immediate '300' does not fit",
+		".data\nx:\n\t.fill -1, 0\n":                                    "count -1 must be 0 to 175",
+		".data\nx:\n\t.string \"bad\\q\"\n":                             "unsupported escape '\\q'",
+		".data\nx:\n\t.fill later, 0\n":                                 "undefined label or constant 'later'",
+		".data\nx:\n\t.fill 1, 0\nx = 2\n":                              "duplicate data label 'x'",
+		".data\nx:\n\t.zero 1\n.text\nmain:\n\t.zero 100\n\t.zero 76\n": "text section overflow",
+	}
+
+	for src, want := range tests {
+		if !strings.Contains(src, ".text") {
+			src += ".text\nmain:\n\tPOP R0\n"
+		}
+		_, err := AssembleString("", src, Options{})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected an error containing %q, got %v\n%s", want, err, src)
+		}
+	}
+}
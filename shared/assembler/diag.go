@@ -0,0 +1,234 @@
+package assembler
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// stop reporting syntax errors after this many, later ones are usually fallout
+const maxDiagnostics = 20
+
+// Diagnostic is a problem found at a place in the source
+type Diagnostic struct {
+	Pos Position
+	// the macro or pseudo-instruction the text came from, if any
+	Context string
+	Message string
+	// what the parser would have accepted, set for syntax errors
+	Expected []string
+	// columns the caret covers, at least one
+	Length int
+	// the line Pos is on, filled in when the source is known
+	Source string
+}
+
+// Error is the one line form, `file:line:col: message`
+func (d Diagnostic) Error() string {
+	at := d.Pos.String()
+	if d.Context != "" {
+		at = fmt.Sprintf("%s (%s)", at, d.Context)
+	}
+	return fmt.Sprintf("%s: %s", at, d.Message)
+}
+
+// Detail adds the source line with a caret under the offending text and the
+// tokens that were expected there
+func (d Diagnostic) Detail() string {
+	var b strings.Builder
+	b.WriteString(d.Error())
+
+	if d.Source != "" && d.Pos.Col > 0 && d.Pos.Col <= len(d.Source)+1 {
+		b.WriteString("\n\t" + d.Source + "\n\t")
+		// keep tabs so the caret lines up however wide they are shown
+		for _, c := range d.Source[:d.Pos.Col-1] {
+			if c == '\t' {
+				b.WriteByte('\t')
+			} else {
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteString(strings.Repeat("^", max(d.Length, 1)))
+	}
+
+	// a single expected token is already in the message
+	if len(d.Expected) > 1 {
+		fmt.Fprintf(&b, "\n\texpected one of: %s", strings.Join(d.Expected, ", "))
+	}
+	return b.String()
+}
+
+// Diagnostics is every problem found in one run, in source order
+type Diagnostics []Diagnostic
+
+func (ds Diagnostics) Error() string {
+	lines := make([]string, len(ds))
+	for i, d := range ds {
+		lines[i] = d.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (ds Diagnostics) Detail() string {
+	parts := make([]string, len(ds))
+	for i, d := range ds {
+		parts[i] = d.Detail()
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// Explain formats an error from the assembler for people: diagnostics with
+// their excerpts, anything else as it is
+func Explain(err error) string {
+	var ds Diagnostics
+	if errors.As(err, &ds) {
+		return ds.Detail()
+	}
+	return err.Error()
+}
+
+// diagnostic builds a Diagnostic at tok
+func diagnostic(tok token, format string, args ...any) Diagnostic {
+	d := Diagnostic{
+		Pos:     tok.position(),
+		Message: fmt.Sprintf(format, args...),
+		Length:  len(tok.val),
+	}
+	if tok.exp != nil {
+		d.Context = tok.exp.String()
+	}
+	return d
+}
+
+// errorAt reports err at node, the caret covers the node as far as the end of
+// its first line. An err that already has a position, from a part of node,
+// is kept.
+func errorAt(node *syntaxTree, err error) error {
+	if ds, ok := err.(Diagnostics); ok {
+		return ds
+	}
+	return errorfAt(node, "%v", err)
+}
+
+func errorfAt(node *syntaxTree, format string, args ...any) error {
+	first, ok := node.first()
+	if !ok {
+		return fmt.Errorf(format, args...)
+	}
+	d := diagnostic(first, format, args...)
+	for _, t := range terminalTokens(node) {
+		if t.file == first.file && t.lin == first.lin && t.col >= first.col {
+			d.Length = max(d.Length, t.col+len(t.val)-first.col)
+		}
+	}
+	return Diagnostics{d}
+}
+
+// errorfToken reports a problem at tok, for the preprocessor which has no
+// syntax tree yet
+func errorfToken(tok token, format string, args ...any) error {
+	return Diagnostics{diagnostic(tok, format, args...)}
+}
+
+func terminalTokens(st *syntaxTree) []token {
+	if st.Symbol.Type == Terminal {
+		return []token{st.Token}
+	}
+	var out []token
+	for _, c := range st.Children {
+		out = append(out, terminalTokens(c)...)
+	}
+	return out
+}
+
+// withSource fills in the source line of every diagnostic in err
+func withSource(err error, sources map[string][]string) error {
+	var ds Diagnostics
+	if !errors.As(err, &ds) {
+		return err
+	}
+	for i := range ds {
+		lines := sources[ds[i].Pos.File]
+		if n := ds[i].Pos.Line; n >= 1 && n <= len(lines) {
+			ds[i].Source = strings.TrimRight(lines[n-1], "\r")
+		}
+	}
+	return err
+}
+
+// describeTerminal names a column of the parse table for an expected list
+func describeTerminal(gi grammarItem) string {
+	switch strings.ToLower(gi.Value) {
+	case "$":
+		return "end of input"
+	case "commandx", "commandy", "commandz", "commandzj":
+		return "an instruction"
+	case "register":
+		return "a register"
+	case "mask":
+		return "a jump mask"
+	case "immediate":
+		return "a number"
+	case "identifier":
+		return "an identifier"
+	case "operator":
+		return "an operator"
+	case "string":
+		return "a string"
+	}
+	return fmt.Sprintf("'%s'", gi.Value)
+}
+
+// expectedFrom lists what could come next when x has no entry for the token,
+// sorted and without repeats: what x starts with and, when x can be empty,
+// what the symbols under it on the stack start with. The lambda entries of a
+// row are the whole FOLLOW set, most of which cannot follow here.
+func (table *llParseTable) expectedFrom(x grammarItem, stack []grammarItem) []string {
+	seen := map[string]bool{}
+	var out []string
+	add := func(t grammarItem) {
+		name := describeTerminal(t)
+		if !seen[name] {
+			seen[name] = true
+			out = append(out, name)
+		}
+	}
+
+	// starts adds what sym can start with and reports whether it can be empty
+	starts := func(sym grammarItem) bool {
+		switch {
+		case sym == marker, sym.Type == Lambda:
+			return true
+		case sym.Type == Terminal:
+			add(sym)
+			return false
+		}
+		nullable := false
+		for t, rule := range table.Data[sym] {
+			if len(rule) == 1 && rule[0].Type == Lambda {
+				nullable = true
+			} else {
+				add(t)
+			}
+		}
+		return nullable
+	}
+	if starts(x) {
+		for i := len(stack) - 1; i >= 0; i-- {
+			if !starts(stack[i]) {
+				break
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// describeToken names what the parser found, for messages
+func describeToken(tok token) string {
+	if tok.val == "$" && tok.typ == Unknown {
+		return "end of input"
+	}
+	return fmt.Sprintf("'%s'", tok.val)
+}
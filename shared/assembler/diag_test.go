@@ -0,0 +1,163 @@
+package assembler
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func Test_syntaxRecovery(t *testing.T) {
+	src := `.data
+	count = 0x03
+	oops 0x04
+.text
+main:
+	LDI X, 3
+	ADD R0
+	LDA R1, count
+	JMP 010 main
+	SYS R0
+`
+	_, err := AssembleString("bad.asm", src, Options{})
+	var ds Diagnostics
+	if !errors.As(err, &ds) {
+		t.Fatalf("expected Diagnostics, got %v", err)
+	}
+
+	want := []string{
+		"bad.asm:3:7: unexpected '0x04'",
+		"bad.asm:6:6: expected a register but got 'X'",
+		"bad.asm:7:8: expected a register at the end of the line",
+		"bad.asm:9:10: expected ',' but got 'main'",
+	}
+	if len(ds) != len(want) {
+		t.Fatalf("expected %d diagnostics, got %d:\n%v", len(want), len(ds), ds)
+	}
+	for i, d := range ds {
+		if d.Error() != want[i] {
+			t.Fatalf("diagnostic %d = %q, want %q", i, d.Error(), want[i])
+		}
+	}
+
+	if got := strings.Join(ds[0].Expected, ", "); got != "':', '='" {
+		t.Fatalf("expected tokens = %q, want %q", got, "':', '='")
+	}
+
+	// an error where the list of instructions has already been popped still
+	// resumes at the next line
+	_, err = AssembleString("rows.asm", ".text\nmain:\n\tPSH R0 R1\n\tLDI R0\n\tCMP R0 R1 R1\n\tJMP 010 main\n\tMOV R0\n", Options{})
+	if !errors.As(err, &ds) {
+		t.Fatalf("expected Diagnostics, got %v", err)
+	}
+	want = []string{
+		"rows.asm:3:9: unexpected 'R1'",
+		"rows.asm:4:8: expected ',' at the end of the line",
+		"rows.asm:5:12: unexpected 'R1'",
+		"rows.asm:6:10: expected ',' but got 'main'",
+		"rows.asm:7:8: expected a register but got end of input",
+	}
+	if ds.Error() != strings.Join(want, "\n") {
+		t.Fatalf("diagnostics =\n%s\nwant\n%s", ds.Error(), strings.Join(want, "\n"))
+	}
+}
+
+func Test_diagnosticDetail(t *testing.T) {
+	src := ".text\nmain:\n\tLDI R0, 1\n\tSTA X, 0x00\n"
+	_, err := AssembleString("detail.asm", src, Options{})
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	want := "detail.asm:4:6: expected a register but got 'X'\n" +
+		"\t\tSTA X, 0x00\n" +
+		"\t\t    ^"
+	if got := Explain(err); got != want {
+		t.Fatalf("Explain() =\n%s\nwant\n%s", got, want)
+	}
+
+	// a row with several choices lists them
+	_, err = AssembleString("detail.asm", ".text\nmain:\n\t0x05\n", Options{})
+	detail := Explain(err)
+	for _, part := range []string{"unexpected '0x05'", "expected one of:", "an instruction", "'.entry'", "end of input"} {
+		if !strings.Contains(detail, part) {
+			t.Fatalf("Explain() is missing %q:\n%s", part, detail)
+		}
+	}
+
+	// only what can follow on the stack is listed, not all of FOLLOW
+	_, err = AssembleString("detail.asm", ".data\nx = 1 2\n", Options{})
+	var ds Diagnostics
+	if !errors.As(err, &ds) {
+		t.Fatalf("expected Diagnostics, got %v", err)
+	}
+	expected := strings.Join(ds[0].Expected, ", ")
+	for _, part := range []string{"an operator", "an identifier", "'.text'", "end of input"} {
+		if !strings.Contains(expected, part) {
+			t.Fatalf("expected tokens %s are missing %q", expected, part)
+		}
+	}
+	for _, part := range []string{"','", "')'", "'.data'", "an instruction"} {
+		if strings.Contains(expected, part) {
+			t.Fatalf("expected tokens %s list %q, which cannot come next", expected, part)
+		}
+	}
+}
+
+func Test_compileDiagnostics(t *testing.T) {
+	src := ".text\nmain:\n\tLDI R0, 200 + 100\n"
+	_, err := AssembleString("range.asm", src, Options{})
+	var ds Diagnostics
+	if !errors.As(err, &ds) || len(ds) != 1 {
+		t.Fatalf("expected one diagnostic, got %v", err)
+	}
+	d := ds[0]
+	if d.Pos != (Position{File: "range.asm", Line: 3, Col: 2}) {
+		t.Fatalf("diagnostic at %v, want range.asm:3:2", d.Pos)
+	}
+	// the caret covers the whole instruction
+	if d.Length != len("LDI R0, 200 + 100") {
+		t.Fatalf("caret length = %d, want %d", d.Length, len("LDI R0, 200 + 100"))
+	}
+}
+
+func Test_invalidLiterals(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{".text\nmain:\n\tLDI R0, 12ab\n", "lit.asm:3:10: invalid immediate '12ab'"},
+		{".text\nmain:\n\tLDI R0, 0b2\n", "lit.asm:3:10: invalid immediate '0b2'"},
+		{".text\nmain:\n\tLDI R0, 0x\n", "lit.asm:3:10: invalid immediate '0x'"},
+		{".data\nx = 0x1G\n.text\nmain:\n\tEXIT 0\n", "lit.asm:2:5: invalid immediate '0x1G'"},
+		{".text\nmain:\n\tLDI R0, 'AB'\n", "lit.asm:3:10: invalid immediate ''AB''"},
+		{".equ N 0x\n.text\nmain:\n\tLDI R0, N\n", "lit.asm:1:8: invalid immediate '0x'"},
+	}
+	for _, tt := range tests {
+		_, err := AssembleString("lit.asm", tt.src, Options{})
+		var ds Diagnostics
+		if !errors.As(err, &ds) {
+			t.Fatalf("%q: expected a diagnostic, got %v", tt.src, err)
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Fatalf("%q: expected an error containing %q, got %v", tt.src, tt.want, err)
+		}
+	}
+
+	// numeric label references still lex as names
+	src := ".text\nmain:\n1:\n\tJMP 010, 1f\n1:\n\tJMP 010, 1b\n"
+	if _, err := AssembleString("labels.asm", src, Options{}); err != nil {
+		t.Fatalf("AssembleString() failed: %v", err)
+	}
+}
+
+func Test_tooManyErrors(t *testing.T) {
+	src := ".text\nmain:\n" + strings.Repeat("\tLDI X, 1\n", maxDiagnostics+5)
+	_, err := AssembleString("", src, Options{})
+	var ds Diagnostics
+	if !errors.As(err, &ds) {
+		t.Fatalf("expected Diagnostics, got %v", err)
+	}
+	if len(ds) != maxDiagnostics+1 || !strings.Contains(ds[maxDiagnostics].Message, "too many errors") {
+		t.Fatalf("expected %d errors and a note, got %d:\n%v", maxDiagnostics, len(ds), ds)
+	}
+}
@@ -0,0 +1,368 @@
+package assembler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// recursive lists the SDT flattens into a single node
+func isListSymbol(sym string) bool {
+	switch sym {
+	case "defs", "dataList", "textList", "exprTail", "exprMore":
+		return true
+	}
+	return false
+}
+
+// non terminals that are dropped when nothing was matched under them
+func isOptionalSymbol(sym string) bool {
+	switch sym {
+	case "exprTail", "exprCall", "exprMore", "dataValue":
+		return true
+	}
+	return false
+}
+
+// sectionItems returns the items of a section, list is the name of its list
+// non terminal. A list holding a single item is collapsed by the SDT so the
+// item can also be a direct child of the section.
+func sectionItems(sec *syntaxTree, list string) []*syntaxTree {
+	var items []*syntaxTree
+	for _, c := range sec.Children {
+		switch {
+		case c.Symbol.Value == list:
+			items = append(items, c.Children...)
+		case c.Symbol.Type == NonTerminal || c.Symbol.Value == "identifier":
+			items = append(items, c)
+		}
+	}
+	return items
+}
+
+// a run of data words under one label, for sizeof and len
+type dataBlock struct {
+	size  int // words taken up
+	count int // elements
+}
+
+// symbolTable resolves names used in expressions: labels, .equ constants and
+// data blocks
+type symbolTable struct {
+	labels    map[string]uint8
+	equs      map[string]*syntaxTree // the equ node, evaluated on first use
+	consts    map[string]int
+	resolving map[string]bool
+	blocks    map[string]dataBlock
+
+	// .global and .extern by name, see linkage
+	globals map[string]*syntaxTree
+	externs map[string]*syntaxTree
+	// set when assembling to a relocatable object, see relocate
+	object *Object
+}
+
+func newSymbolTable() *symbolTable {
+	return &symbolTable{
+		labels:    map[string]uint8{},
+		equs:      map[string]*syntaxTree{},
+		consts:    map[string]int{},
+		resolving: map[string]bool{},
+		blocks:    map[string]dataBlock{},
+		globals:   map[string]*syntaxTree{},
+		externs:   map[string]*syntaxTree{},
+	}
+}
+
+// define adds `.equ NAME expr`, the value is worked out when it is first used
+// so constants may refer to labels defined further down
+func (syms *symbolTable) define(equ *syntaxTree) error {
+	name := equ.Children[1].Data
+	if prev, dup := syms.equs[name]; dup {
+		return errorfAt(equ, "duplicate constant '%s', first defined at %s", name, prev.pos())
+	}
+	if _, dup := syms.labels[name]; dup {
+		return errorfAt(equ, "constant '%s' has the same name as a label", name)
+	}
+	syms.equs[name] = equ
+	return nil
+}
+
+// declare adds a definition that is not a label, `.equ`, `.global` or
+// `.extern`
+func (syms *symbolTable) declare(def *syntaxTree) error {
+	if def.Symbol.Value == "linkage" {
+		return syms.linkage(def)
+	}
+	return syms.define(def)
+}
+
+// label adds a label, it may not share a name with anything else
+func (syms *symbolTable) label(name string, addr uint8, kind string) error {
+	if prev, dup := syms.labels[name]; dup {
+		return fmt.Errorf("duplicate %s label '%s' at address %d", kind, name, prev)
+	}
+	if equ, dup := syms.equs[name]; dup {
+		return fmt.Errorf("%s label '%s' has the same name as the constant defined at %s", kind, name, equ.pos())
+	}
+	syms.labels[name] = addr
+	return nil
+}
+
+func (syms *symbolTable) lookup(name string) (int, error) {
+	if addr, ok := syms.labels[name]; ok {
+		return int(addr), nil
+	}
+	if _, ok := syms.externs[name]; ok && syms.object != nil {
+		// placed by the linker, relocate records where it is used
+		return 0, nil
+	}
+	equ, ok := syms.equs[name]
+	if !ok {
+		return 0, fmt.Errorf("undefined label or constant '%s'", name)
+	}
+	if v, done := syms.consts[name]; done {
+		return v, nil
+	}
+	if syms.resolving[name] {
+		return 0, fmt.Errorf("constant '%s' is defined in terms of itself", name)
+	}
+
+	syms.resolving[name] = true
+	v, err := syms.eval(equ.Children[2])
+	delete(syms.resolving, name)
+	if _, ok := err.(Diagnostics); ok {
+		// already points into the constant
+		return 0, err
+	}
+	if err != nil {
+		return 0, fmt.Errorf("in constant '%s' at %s: %v", name, equ.pos(), err)
+	}
+	syms.consts[name] = v
+	return v, nil
+}
+
+// byteValue evaluates node and checks the result fits in a word, negative
+// values are stored as two's complement
+func (syms *symbolTable) byteValue(node *syntaxTree) (uint8, error) {
+	v, err := syms.eval(node)
+	if err != nil {
+		return 0, err
+	}
+	if v < -128 || v > 255 {
+		if node.Symbol.Value == "immediate" {
+			return 0, fmt.Errorf("immediate '%s' does not fit in 8 bits (-128 to 255)", node.Data)
+		}
+		return 0, fmt.Errorf("value %d does not fit in 8 bits (-128 to 255)", v)
+	}
+	return uint8(v), nil
+}
+
+// binding strength of the binary operators, higher binds tighter
+var precedence = map[string]int{
+	"==": 1,
+	"!=": 1,
+	"<":  1,
+	">":  1,
+	"<=": 1,
+	">=": 1,
+	"|":  2,
+	"&":  3,
+	"<<": 4,
+	">>": 4,
+	"+":  5,
+	"-":  5,
+	"*":  6,
+	"/":  6,
+}
+
+// eval works out the value of an expression node, see expr in the grammar
+func (syms *symbolTable) eval(node *syntaxTree) (int, error) {
+	switch node.Symbol.Value {
+	case "immediate":
+		v, err := parseLiteral(node.Data)
+		if err != nil {
+			return 0, errorAt(node, err)
+		}
+		return v, nil
+
+	case "identifier":
+		return syms.lookup(node.Data)
+
+	case "expr":
+		operands, operators := exprParts(node)
+		values := make([]int, len(operands))
+		for i, operand := range operands {
+			v, err := syms.eval(operand)
+			if err != nil {
+				return 0, err
+			}
+			values[i] = v
+		}
+		return climb(values, operators, 0, apply)
+
+	case "exprAtom":
+		first := node.Children[0]
+		switch first.Symbol.Value {
+		case "(":
+			return syms.eval(node.Children[1])
+		case "-":
+			v, err := syms.eval(node.Children[1])
+			return -v, err
+		case "identifier":
+			// identifier exprCall, the call is ( expr )
+			return syms.call(first.Data, node.Children[1].Children[1])
+		}
+	}
+
+	return 0, fmt.Errorf("unexpected %s in expression", node.Symbol.Value)
+}
+
+// exprParts splits an expr node into its operands and the operators between
+// them, it is an exprAtom followed by the flattened exprTail: op atom op atom ...
+func exprParts(node *syntaxTree) ([]*syntaxTree, []string) {
+	operands := []*syntaxTree{node.Children[0]}
+	var operators []string
+	tail := node.Children[1].Children
+	for i := 0; i+1 < len(tail); i += 2 {
+		operators = append(operators, tail[i].Data)
+		operands = append(operands, tail[i+1])
+	}
+	return operands, operators
+}
+
+// climb applies operators left to right honouring precedence, values has one
+// more element than operators
+func climb[T any](values []T, operators []string, minPrec int, apply func(string, T, T) (T, error)) (T, error) {
+	lhs := values[0]
+	i := 0
+	for i < len(operators) && precedence[operators[i]] >= minPrec {
+		op := operators[i]
+		// everything binding tighter than op belongs to its right operand
+		j := i + 1
+		for j < len(operators) && precedence[operators[j]] > precedence[op] {
+			j++
+		}
+		rhs, err := climb(values[i+1:j+1], operators[i+1:j], precedence[op]+1, apply)
+		if err != nil {
+			return rhs, err
+		}
+		if lhs, err = apply(op, lhs, rhs); err != nil {
+			return lhs, err
+		}
+		i = j
+	}
+	return lhs, nil
+}
+
+func apply(op string, a int, b int) (int, error) {
+	switch op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		if b == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return a / b, nil
+	case "&":
+		return a & b, nil
+	case "|":
+		return a | b, nil
+	case "<<", ">>":
+		if b < 0 || b > 16 {
+			return 0, fmt.Errorf("shift by %d, shifts must be 0 to 16", b)
+		}
+		if op == "<<" {
+			return a << b, nil
+		}
+		return a >> b, nil
+	case "==", "!=", "<", ">", "<=", ">=":
+		return truth(compare(op, a, b)), nil
+	}
+	return 0, fmt.Errorf("unknown operator '%s'", op)
+}
+
+// compare is a comparison operator applied to a and b
+func compare(op string, a int, b int) bool {
+	switch op {
+	case "==":
+		return a == b
+	case "!=":
+		return a != b
+	case "<":
+		return a < b
+	case ">":
+		return a > b
+	case "<=":
+		return a <= b
+	}
+	return a >= b
+}
+
+// truth is 1 for true and 0 for false, as comparisons evaluate
+func truth(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// call evaluates the built in functions
+func (syms *symbolTable) call(name string, arg *syntaxTree) (int, error) {
+	switch name {
+	case "lo", "hi":
+		v, err := syms.eval(arg)
+		if err != nil {
+			return 0, err
+		}
+		if name == "hi" {
+			v >>= 8
+		}
+		return v & 0xFF, nil
+
+	case "sizeof", "len":
+		if arg.Symbol.Value != "identifier" {
+			return 0, fmt.Errorf("%s takes a data label", name)
+		}
+		block, ok := syms.blocks[arg.Data]
+		if !ok {
+			return 0, fmt.Errorf("%s: '%s' is not a data label or table", name, arg.Data)
+		}
+		if name == "len" {
+			return block.count, nil
+		}
+		return block.size, nil
+	}
+	return 0, fmt.Errorf("unknown function '%s', expected sizeof, len, lo or hi", name)
+}
+
+// parseLiteral reads a single hex, binary, char or decimal literal. Literals
+// inside expressions may be up to 16 bits, only the final value has to fit in
+// a word.
+func parseLiteral(lit string) (int, error) {
+	var v int64
+	var err error
+	switch {
+	case strings.HasPrefix(lit, "0x") || strings.HasPrefix(lit, "0X"):
+		v, err = strconv.ParseInt(lit[2:], 16, 64)
+	case strings.HasPrefix(lit, "0b") || strings.HasPrefix(lit, "0B"):
+		v, err = strconv.ParseInt(lit[2:], 2, 64)
+	case strings.HasPrefix(lit, "'"):
+		var c byte
+		c, err = parseChar(lit)
+		v = int64(c)
+	default:
+		v, err = strconv.ParseInt(lit, 10, 64)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid immediate '%s'", lit)
+	}
+	if v > 0xFFFF {
+		return 0, fmt.Errorf("literal '%s' does not fit in 16 bits", lit)
+	}
+	return int(v), nil
+}
@@ -0,0 +1,120 @@
+package assembler
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_expressions(t *testing.T) {
+	tests := map[string]uint8{
+		"1+2*3":              7,
+		"(1+2)*3":            9,
+		"10-4-3":             3,
+		"2 - -1":             3,
+		"-1":                 0xFF,
+		"-(2*3)":             0xFA,
+		"1 << 4 | 3":         0x13,
+		"0xF0 & 0x3C >> 2":   0x00,
+		"0xF0 | 0x0F & 0x3C": 0xFC,
+		"100/7":              14,
+		"2 + 1 == 3":         1,
+		"SIZE*2 < 8":         0,
+		"1 | 2 != 3":         0,
+		"3 >= 3 & 1":         1,
+		"lo(0x1234)":         0x34,
+		"hi(0x1234)":         0x12,
+		"hi(300*2)":          0x02,
+		"'A' + 1":            'B',
+		"SIZE*2":             8,
+		"LAST":               7,
+		"pair+1":             0x01,
+		"sizeof(pair)":       1,
+		"len(single)":        1,
+		"main+2":             0x53,
+	}
+
+	for expr, want := range tests {
+		src := ".equ SIZE 4\n.equ LAST SIZE + 3\n" +
+			".data\npair = 0x01\nsecond = 0x02\nsingle = SIZE - 1\n" +
+			".text\nmain:\n\tLDI R0, " + expr + "\n"
+		prog, err := AssembleString("", src, Options{})
+		if err != nil {
+			t.Fatalf("%s: AssembleString() failed: %v", expr, err)
+		}
+		if got := prog.Text[1]; got != want {
+			t.Fatalf("%s = 0x%02X, want 0x%02X", expr, got, want)
+		}
+		if prog.Data[2] != 3 {
+			t.Fatalf("%s: data value SIZE - 1 = %d, want 3", expr, prog.Data[2])
+		}
+	}
+}
+
+func Test_expressionPlacement(t *testing.T) {
+	src := `
+.equ EXIT 0x00
+.data
+	count = 0x03
+	.equ STEP 1
+.text
+.equ BACK 2
+main:
+	LDA R0, count
+loop:
+	LDI R1, STEP
+	SUB R0 R1
+	JMP 001, loop + BACK - 2
+	LDI R0, EXIT
+	PSH R0
+	SYS R0
+`
+	prog, err := AssembleString("", src, Options{})
+	if err != nil {
+		t.Fatalf("AssembleString() failed: %v", err)
+	}
+	want := []byte{
+		0xE0, 0x00, // LDA R0, count
+		0xD4, 0x01, // LDI R1, STEP
+		0x51,       // SUB R0 R1
+		0xC1, 0x53, // JMP 001, loop
+	}
+	for i, b := range want {
+		if prog.Text[i] != b {
+			t.Fatalf("text[%d] = 0x%02X, want 0x%02X (text: % X)", i, prog.Text[i], b, prog.Text[:len(want)])
+		}
+	}
+
+	// a single data item used to be lost by the SDT
+	prog, err = AssembleString("", ".data\nx = 5\n.text\nmain:\n\tLDA R0, x\n", Options{})
+	if err != nil {
+		t.Fatalf("AssembleString() failed: %v", err)
+	}
+	if prog.Data[0] != 5 || prog.Symbols["x"] != 0 {
+		t.Fatalf("single data item not assembled: data % X, symbols %v", prog.Data[:1], prog.Symbols)
+	}
+}
+
+func Test_expressionErrors(t *testing.T) {
+	tests := map[string]string{
+		"LDI R0, nowhere":      "undefined label or constant 'nowhere'",
+		"LDI R0, 1/0":          "division by zero",
+		"LDI R0, 200+100":      "value 300 does not fit in 8 bits",
+		"LDI R0, 256":          "immediate '256' does not fit in 8 bits",
+		"LDI R0, 1 << 20":      "shift by 20",
+		"LDI R0, A":            "constant 'A' is defined in terms of itself",
+		"LDI R0, sqrt(4)":      "unknown function 'sqrt'",
+		"LDI R0, sizeof(main)": "'main' is not a data label or table",
+		"LDI R0, sizeof(1)":    "sizeof takes a data label",
+		"LDI R0, 0x10000":      "does not fit in 16 bits",
+		".equ A 1":             "duplicate constant 'A'",
+		".equ main 1":          "constant 'main' has the same name as a label",
+	}
+
+	for line, want := range tests {
+		src := ".equ A B\n.equ B A + 1\n.text\nmain:\n\t" + line + "\n"
+		_, err := AssembleString("", src, Options{})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("%s: expected an error containing %q, got %v", line, want, err)
+		}
+	}
+}
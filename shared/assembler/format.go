@@ -0,0 +1,337 @@
+package assembler
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Format lays out assembly source the one way asmfmt writes it:
+//
+//   - labels, sections and the directives that are not data start at the left
+//     edge, everything else is indented one tab under them
+//   - a label with an instruction after it on the same line is split in two
+//   - operands of neighbouring instructions and data directives line up, as do
+//     the `#` comments of neighbouring lines
+//   - a comma is followed by one space, binary operators have a space on both
+//     sides, X-type operands are separated by a space
+//   - hex literals are written `0x0F`, binary literals `0b101`
+//   - runs of blank lines become one, comment lines keep their text and take
+//     the indentation of the code below them
+//
+// Only the layout changes, the tokens are the same afterwards. name is used
+// in error positions.
+func Format(name string, src []byte) ([]byte, error) {
+	lines, err := lexTrivia(bytes.NewReader(src), name)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		for _, t := range line {
+			if t.typ == Unknown {
+				return nil, fmt.Errorf("%s: unexpected '%s'", t.pos(), t.val)
+			}
+		}
+	}
+
+	var stmts []statement
+	for _, line := range lines {
+		stmts = append(stmts, splitStatements(line)...)
+	}
+	stmts = dropBlanks(stmts)
+	indentComments(stmts)
+	alignOperands(stmts)
+
+	var b strings.Builder
+	alignComments(stmts, &b)
+
+	out := []byte(b.String())
+	if err := sameTokens(name, src, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// a line of formatted output
+type statement struct {
+	indent  bool
+	head    string // mnemonic, directive, label or name
+	rest    string // operands
+	comment string
+	blank   bool
+	opCol   int // operands start this many characters after the indent
+}
+
+// code is the statement without its comment
+func (s statement) code() string {
+	var b strings.Builder
+	if s.indent {
+		b.WriteByte('\t')
+	}
+	b.WriteString(s.head)
+	if s.rest != "" {
+		b.WriteString(strings.Repeat(" ", max(s.opCol-len(s.head), 1)))
+		b.WriteString(s.rest)
+	}
+	return b.String()
+}
+
+// the directives that are not data start at the left edge
+func isTopLevel(dir string) bool {
+	switch dir {
+	case ".byte", ".string", ".pstring", ".fill", ".zero":
+		return false
+	}
+	return true
+}
+
+// splitStatements turns a lexed line into statements, a label line with more
+// after it gives two
+func splitStatements(line []token) []statement {
+	var comment string
+	if n := len(line); n > 0 && line[n-1].typ == Comment {
+		comment = line[n-1].val
+		line = line[:n-1]
+	}
+	if len(line) == 0 {
+		if comment == "" {
+			return []statement{{blank: true}}
+		}
+		return []statement{{comment: comment}}
+	}
+
+	first := line[0]
+	switch {
+	case isLabel(line) || (len(line) >= 2 && first.typ == Immediate && line[1].typ == Colon):
+		label := statement{head: first.val + ":"}
+		if len(line) == 2 {
+			label.comment = comment
+			return []statement{label}
+		}
+		rest := splitStatements(append(line[2:len(line):len(line)], commentToken(comment)...))
+		return append([]statement{label}, rest...)
+
+	case len(line) >= 2 && first.typ == Identifier && line[1].typ == Equals:
+		// a data word, `name = expr`
+		return []statement{{head: first.val, rest: "= " + joinTokens(line[2:]), comment: comment}}
+
+	case first.typ == Section:
+		return []statement{{indent: !isTopLevel(first.val), head: first.val, rest: joinTokens(line[1:]), comment: comment}}
+	}
+	return []statement{{indent: true, head: first.val, rest: joinTokens(line[1:]), comment: comment}}
+}
+
+func commentToken(comment string) []token {
+	if comment == "" {
+		return nil
+	}
+	return []token{{val: comment, typ: Comment}}
+}
+
+// joinTokens writes operands with the spacing described on Format
+func joinTokens(toks []token) string {
+	var b strings.Builder
+	for i, t := range toks {
+		if i > 0 && spaced(toks[i-1], t, i >= 2 && operandEnd(toks[i-2])) {
+			b.WriteByte(' ')
+		}
+		b.WriteString(literal(t))
+	}
+	return b.String()
+}
+
+// operandEnd reports whether t can end an operand, so an operator after it
+// is binary
+func operandEnd(t token) bool {
+	switch t.typ {
+	case Immediate, Identifier, Register, Mask, String:
+		return true
+	}
+	return t.val == ")"
+}
+
+// spaced reports whether a space goes between prev and next, beforePrev tells
+// whether prev is a binary operator when it is one
+func spaced(prev token, next token, beforePrev bool) bool {
+	switch {
+	case next.typ == Comma, next.val == ")", prev.val == "(":
+		return false
+	case prev.typ == Operator && !beforePrev:
+		// unary minus
+		return false
+	case next.val == "(":
+		// a call, `lo(x)`
+		return prev.typ != Identifier
+	}
+	return true
+}
+
+// literal is how a token is written, numbers get a standard form
+func literal(t token) string {
+	if t.typ != Immediate {
+		return t.val
+	}
+	lower := strings.ToLower(t.val)
+	switch {
+	case strings.HasPrefix(lower, "0x"):
+		digits := strings.ToUpper(t.val[2:])
+		if len(digits)%2 != 0 {
+			digits = "0" + digits
+		}
+		return "0x" + digits
+	case strings.HasPrefix(lower, "0b"):
+		return "0b" + t.val[2:]
+	}
+	return t.val
+}
+
+// dropBlanks removes blank lines at the ends and runs of them in between
+func dropBlanks(stmts []statement) []statement {
+	var out []statement
+	for _, s := range stmts {
+		if s.blank && (len(out) == 0 || out[len(out)-1].blank) {
+			continue
+		}
+		out = append(out, s)
+	}
+	for len(out) > 0 && out[len(out)-1].blank {
+		out = out[:len(out)-1]
+	}
+	return out
+}
+
+// isCommentLine is a statement that is only a comment
+func (s statement) isCommentLine() bool {
+	return !s.blank && s.head == ""
+}
+
+// indentComments indents comment lines like the code after them
+func indentComments(stmts []statement) {
+	indent := false
+	for i := len(stmts) - 1; i >= 0; i-- {
+		switch {
+		case stmts[i].blank:
+		case stmts[i].isCommentLine():
+			stmts[i].indent = indent
+		default:
+			indent = stmts[i].indent
+		}
+	}
+}
+
+// isCall is a pseudo-instruction or macro, they do not widen the operand
+// column of the instructions around them
+func isCall(head string) bool {
+	if _, ok := pseudos[head]; ok {
+		return true
+	}
+	return !strings.HasPrefix(head, ".") && len(head) != 3
+}
+
+// alignOperands lines up the operands of neighbouring indented statements,
+// comment lines do not break a run
+func alignOperands(stmts []statement) {
+	start := 0
+	flush := func(end int) {
+		width := 0
+		for _, s := range stmts[start:end] {
+			if s.indent && !s.isCommentLine() && !isCall(s.head) {
+				width = max(width, len(s.head)+1)
+			}
+		}
+		for i := start; i < end; i++ {
+			stmts[i].opCol = width
+		}
+		start = end
+	}
+	for i, s := range stmts {
+		if s.blank || (!s.indent && !s.isCommentLine()) {
+			flush(i)
+			start = i + 1
+		}
+	}
+	flush(len(stmts))
+}
+
+// width of text with tabs at every 8 columns
+func columns(text string) int {
+	n := 0
+	for _, c := range text {
+		if c == '\t' {
+			n += 8 - n%8
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+// alignComments writes stmts, the trailing comments of neighbouring lines
+// start in the same column
+func alignComments(stmts []statement, b *strings.Builder) {
+	for i := 0; i < len(stmts); {
+		s := stmts[i]
+		if s.blank || s.isCommentLine() || s.comment == "" {
+			switch {
+			case s.blank:
+			case s.isCommentLine():
+				if s.indent {
+					b.WriteByte('\t')
+				}
+				b.WriteString(s.comment)
+			default:
+				b.WriteString(s.code())
+			}
+			b.WriteByte('\n')
+			i++
+			continue
+		}
+
+		// a run of code lines with comments
+		end := i
+		col := 0
+		for end < len(stmts) && !stmts[end].blank && !stmts[end].isCommentLine() && stmts[end].comment != "" {
+			col = max(col, columns(stmts[end].code()))
+			end++
+		}
+		for _, s := range stmts[i:end] {
+			code := s.code()
+			b.WriteString(code)
+			b.WriteString(strings.Repeat(" ", col-columns(code)+1))
+			b.WriteString(s.comment)
+			b.WriteByte('\n')
+		}
+		i = end
+	}
+}
+
+// sameTokens checks formatting kept every token, only the layout may change
+func sameTokens(name string, before []byte, after []byte) error {
+	a, err := lexTrivia(bytes.NewReader(before), name)
+	if err != nil {
+		return err
+	}
+	b, err := lexTrivia(bytes.NewReader(after), name)
+	if err != nil {
+		return err
+	}
+	flat := func(lines [][]token) []string {
+		var out []string
+		for _, line := range lines {
+			for _, t := range line {
+				out = append(out, literal(t))
+			}
+		}
+		return out
+	}
+	x, y := flat(a), flat(b)
+	for i := range min(len(x), len(y)) {
+		if x[i] != y[i] {
+			return fmt.Errorf("%s: formatting changed '%s' to '%s'", name, x[i], y[i])
+		}
+	}
+	if len(x) != len(y) {
+		return fmt.Errorf("%s: formatting changed the number of tokens from %d to %d", name, len(x), len(y))
+	}
+	return nil
+}
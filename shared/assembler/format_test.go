@@ -0,0 +1,102 @@
+package assembler
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+func Test_Format(t *testing.T) {
+	src := `
+
+# constants
+.equ   N   0x5
+.macro  push_two a,b
+  LDI R0,a
+	PSH   R0
+1:   LDI R0 , b   # second
+.endm
+.data
+i=0x0a     # counter
+buf:
+ .zero 3
+   .byte 1,2 ,-3
+.text
+main: LDI R0, -(2*N)   # load
+	CMP R0    R1 # compare
+
+
+
+      # out
+	JMPA  end
+	push_two 1, lo(0X1ff)
+	JMP 010,1b
+end:
+	EXIT 0B11
+`
+	want := `# constants
+.equ N 0x05
+.macro push_two a, b
+	LDI R0, a
+	PSH R0
+1:
+	LDI R0, b # second
+.endm
+.data
+i = 0x0A # counter
+buf:
+	.zero 3
+	.byte 1, 2, -3
+.text
+main:
+	LDI R0, -(2 * N) # load
+	CMP R0 R1        # compare
+
+	# out
+	JMPA end
+	push_two 1, lo(0x01FF)
+	JMP 010, 1b
+end:
+	EXIT 0b11
+`
+	got, err := Format("messy.asm", []byte(src))
+	if err != nil {
+		t.Fatalf("Format() failed: %v", err)
+	}
+	if string(got) != want {
+		t.Fatalf("Format() =\n%s\nwant\n%s", got, want)
+	}
+	again, err := Format("messy.asm", got)
+	if err != nil || string(again) != string(got) {
+		t.Fatalf("formatting twice changed the output: %v\n%s", err, again)
+	}
+
+	if _, err := Format("bad.asm", []byte(".text\nmain:\n\tLDI R0, $1\n")); err == nil ||
+		!strings.Contains(err.Error(), "bad.asm:3:10: unexpected '$'") {
+		t.Fatalf("expected an error for '$', got %v", err)
+	}
+}
+
+func Test_FormatStdlib(t *testing.T) {
+	// the standard library is kept formatted
+	err := fs.WalkDir(stdlibFS, "std", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		src, err := stdlibFS.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		got, err := Format(path, src)
+		if err != nil {
+			return err
+		}
+		if string(got) != string(src) {
+			t.Errorf("%s is not formatted:\n%s", path, got)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
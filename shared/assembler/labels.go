@@ -0,0 +1,157 @@
+package assembler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Local labels are renamed before parsing so the rest of the assembler only
+// sees plain labels:
+//
+//	adder:
+//	.loop:          # adder.loop
+//		JMP 010, .loop
+//	1:              # 1@1, the first `1:` in the program
+//		JMP 100, 1b
+//
+// `.name` belongs to the closest global label above it and is only visible
+// under that label. Numeric labels may be defined any number of times, `1b`
+// is the closest `1:` at or above the line and `1f` the closest one below.
+
+func isLocalName(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+func isDecimal(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// numericRef splits `1f` into the label and the direction
+func numericRef(name string) (string, byte, bool) {
+	n := len(name)
+	if n < 2 || (name[n-1] != 'f' && name[n-1] != 'b') || !isDecimal(name[:n-1]) {
+		return "", 0, false
+	}
+	return name[:n-1], name[n-1], true
+}
+
+// scopeLabels renames every local label definition and reference in lines
+func scopeLabels(lines [][]token) error {
+	var ds Diagnostics
+	report := func(d Diagnostic) {
+		if len(ds) < maxDiagnostics {
+			ds = append(ds, d)
+		}
+	}
+
+	type numericDef struct {
+		line int
+		name string
+	}
+	scopes := make([]string, len(lines)) // global label each line is under
+	locals := map[string]token{}         // definition of every renamed local
+	under := map[string][]string{}       // global labels each local is defined under
+	numeric := map[string][]numericDef{} // definitions of each numeric label in order
+
+	scope := ""
+	for i, line := range lines {
+		if isDirective(line, ".data") || isDirective(line, ".text") {
+			scope = ""
+		}
+
+		switch {
+		case isLabel(line) && isLocalName(line[0].val):
+			name := line[0].val
+			if scope == "" {
+				report(diagnostic(line[0], "local label '%s' has no global label above it to belong to", name))
+				break
+			}
+			full := scope + name
+			if prev, dup := locals[full]; dup {
+				report(diagnostic(line[0], "duplicate local label '%s' under '%s', first defined at %s", name, scope, prev.pos()))
+				break
+			}
+			locals[full] = line[0]
+			under[name] = append(under[name], scope)
+			line[0].val = full
+
+		case isLabel(line) && line[0].exp == nil && !strings.Contains(line[0].val, "@"):
+			// a label a macro defines is renamed `name@macro.N` and does not
+			// start a scope, local labels around the call stay visible
+			scope = line[0].val
+
+		case len(line) >= 2 && line[0].typ == Immediate && isDecimal(line[0].val) && line[1].typ == Colon:
+			n := line[0].val
+			full := fmt.Sprintf("%s@%d", n, len(numeric[n])+1)
+			numeric[n] = append(numeric[n], numericDef{line: i, name: full})
+			line[0].typ = Identifier
+			line[0].val = full
+		}
+		scopes[i] = scope
+	}
+
+	for i, line := range lines {
+		for j := range line {
+			tok := &line[j]
+			// a definition that could not be renamed is already reported
+			if tok.typ != Identifier || (j == 0 && isLabel(line)) {
+				continue
+			}
+
+			if isLocalName(tok.val) {
+				if scopes[i] == "" {
+					report(diagnostic(*tok, "local label '%s' is used outside of any global label", tok.val))
+					continue
+				}
+				full := scopes[i] + tok.val
+				if _, ok := locals[full]; !ok {
+					d := diagnostic(*tok, "local label '%s' is not defined under '%s'", tok.val, scopes[i])
+					if others := under[tok.val]; len(others) > 0 {
+						d.Message += fmt.Sprintf(", only under '%s'", strings.Join(others, "', '"))
+					}
+					report(d)
+					continue
+				}
+				tok.val = full
+				continue
+			}
+
+			n, dir, ok := numericRef(tok.val)
+			if !ok {
+				continue
+			}
+			target := ""
+			for _, def := range numeric[n] {
+				if dir == 'b' && def.line <= i {
+					target = def.name
+				}
+				if dir == 'f' && def.line > i {
+					target = def.name
+					break
+				}
+			}
+			if target == "" {
+				where := "above"
+				if dir == 'f' {
+					where = "below"
+				}
+				report(diagnostic(*tok, "'%s' has no '%s:' %s it", tok.val, n, where))
+				continue
+			}
+			tok.val = target
+		}
+	}
+
+	if len(ds) > 0 {
+		return ds
+	}
+	return nil
+}
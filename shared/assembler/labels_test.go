@@ -0,0 +1,132 @@
+package assembler
+
+import (
+	"errors"
+	"tcp-vm/shared/vm"
+	"testing"
+)
+
+func Test_localLabels(t *testing.T) {
+	src := `.macro spin n
+	LDI R1, n
+1:
+	DEC R1
+	CLR R0
+	CMP R1 R0
+	JMP 001, 1b
+.endm
+.text
+main:
+	LDI R1, 0x03
+.loop:
+	LDI R0, 0x01
+	SUB R1 R0
+	CLR R0
+	CMP R1 R0
+	JMP 001, .loop
+	JMPA 1f
+	EXIT 0xFF
+1:
+	spin 2
+	spin 3
+	JMPA other
+other:
+	LDI R1, 0x04
+.loop:
+	LDI R0, 0x02
+	SUB R1 R0
+	CLR R0
+	CMP R1 R0
+	JMP 001, .loop
+	EXIT 0x02
+`
+	prog, err := AssembleString("locals.asm", src, Options{})
+	if err != nil {
+		t.Fatalf("AssembleString() failed: %s", Explain(err))
+	}
+	for _, name := range []string{"main.loop", "other.loop", "1@1", "1@2", "1@3"} {
+		if _, ok := prog.Symbols[name]; !ok {
+			t.Fatalf("missing symbol %s in %v", name, prog.Symbols)
+		}
+	}
+
+	machine := new(vm.VirtualMachine)
+	machine.ResetFromStateless(prog.Data, prog.Text, prog.Entry)
+	if err := machine.RunUntilStop(); err != nil {
+		t.Fatalf("RunUntilStop() failed: %v", err)
+	}
+	// a wrong 1f lands on EXIT 0xFF, a wrong .loop never ends
+	if machine.R0 != 0x02 {
+		t.Fatalf("exit code = %d, want 2", machine.R0)
+	}
+}
+
+func Test_localLabelErrors(t *testing.T) {
+	src := `.text
+.early:
+main:
+	JMP 010, .missing
+.done:
+	JMP 010, 2b
+	JMP 010, 3f
+.done:
+helper:
+	JMP 010, .done
+`
+	_, err := AssembleString("bad.asm", src, Options{})
+	var ds Diagnostics
+	if !errors.As(err, &ds) {
+		t.Fatalf("expected Diagnostics, got %v", err)
+	}
+	want := []string{
+		"bad.asm:2:1: local label '.early' has no global label above it to belong to",
+		"bad.asm:8:1: duplicate local label '.done' under 'main', first defined at bad.asm:5:1",
+		"bad.asm:4:11: local label '.missing' is not defined under 'main'",
+		"bad.asm:6:11: '2b' has no '2:' above it",
+		"bad.asm:7:11: '3f' has no '3:' below it",
+		"bad.asm:10:11: local label '.done' is not defined under 'helper', only under 'main'",
+	}
+	if len(ds) != len(want) {
+		t.Fatalf("expected %d diagnostics, got %d:\n%v", len(want), len(ds), ds)
+	}
+	for i, d := range ds {
+		if d.Error() != want[i] {
+			t.Fatalf("diagnostic %d = %q, want %q", i, d.Error(), want[i])
+		}
+	}
+}
+
+func Test_localLabelsAroundMacro(t *testing.T) {
+	// the label the macro defines does not take .loop out of scope
+	src := `.macro SKIPNZ r
+	JMPA done
+done:
+.endm
+.text
+main:
+	LDI R0, 0x03
+.loop:
+	DEC R0
+	SKIPNZ R0
+	CLR R1
+	CMP R0 R1
+	JMP 001, .loop
+	EXIT 0x02
+`
+	prog, err := AssembleString("around.asm", src, Options{})
+	if err != nil {
+		t.Fatalf("AssembleString() failed: %s", Explain(err))
+	}
+	if _, ok := prog.Symbols["main.loop"]; !ok {
+		t.Fatalf("missing symbol main.loop in %v", prog.Symbols)
+	}
+
+	machine := new(vm.VirtualMachine)
+	machine.ResetFromStateless(prog.Data, prog.Text, prog.Entry)
+	if err := machine.RunUntilStop(); err != nil {
+		t.Fatalf("RunUntilStop() failed: %v", err)
+	}
+	if machine.R0 != 0x02 {
+		t.Fatalf("exit code = %d, want 2", machine.R0)
+	}
+}
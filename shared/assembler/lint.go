@@ -0,0 +1,329 @@
+package assembler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"tcp-vm/shared/vm"
+)
+
+// Lint rules, every lint warning carries one in Warning.Rule. A warning is
+// silenced by a comment naming its rule on its line or on a comment line just
+// above it, `# lint:ignore unused-label`. Without names every warning on the
+// line is silenced.
+const (
+	RuleUnusedLabel    = "unused-label"
+	RuleUnusedData     = "unused-data"
+	RuleUnreachable    = "unreachable"
+	RuleNeverBranches  = "never-branches"
+	RuleRegisterWrite  = "sp-pc-write"
+	RuleUninitialized  = "uninitialized-read"
+	RuleUnknownSyscall = "unknown-syscall"
+	// the clobber warnings of pseudo-instructions, given whether or not the
+	// program is linted
+	RuleClobbered = "clobbered"
+)
+
+const ignoreDirective = "lint:ignore"
+
+// lintProgram looks for code that assembles but is probably wrong, syms is the
+// table the program was compiled with
+func lintProgram(st *syntaxTree, syms *symbolTable) []Warning {
+	var dataItems, instrs []*syntaxTree
+	for _, sec := range st.Children {
+		switch sec.Symbol.Value {
+		case "data":
+			dataItems = sectionItems(sec, "dataList")
+		case "text":
+			instrs = sectionItems(sec, "textList")
+		}
+	}
+
+	var warnings []Warning
+	warn := func(at *syntaxTree, rule string, format string, args ...any) {
+		warnings = append(warnings, warningAt(at, rule, fmt.Sprintf(format, args...)))
+	}
+
+	uses := labelUses(st, dataItems, instrs)
+	for _, node := range instrs {
+		name := node.Data
+		if node.Symbol.Value == "identifier" && len(uses[name]) == 0 && name != "main" {
+			warn(node, RuleUnusedLabel, "label '%s' is never used", displayName(name))
+		}
+	}
+	for _, node := range dataItems {
+		label := node
+		if node.Symbol.Value == "dataItem" {
+			label = node.Children[0]
+		} else if node.Symbol.Value != "identifier" {
+			continue
+		}
+		if len(uses[label.Data]) == 0 {
+			warn(node, RuleUnusedData, "data '%s' is never used", label.Data)
+		}
+	}
+
+	for _, name := range uninitialized(dataItems) {
+		reads, other := 0, 0
+		var first *syntaxTree
+		for _, use := range uses[name] {
+			if isOp(use, "LDA") {
+				if first == nil {
+					first = use
+				}
+				reads++
+			} else {
+				other++
+			}
+		}
+		// anything else, STA or taking the address, may write it
+		if reads > 0 && other == 0 {
+			warn(first, RuleUninitialized, "'%s' is read but never written, it only holds the zeros it was reserved with", name)
+		}
+	}
+
+	lintFlow(instrs, syms, warn)
+	sort.SliceStable(warnings, func(i, j int) bool {
+		a, b := warnings[i].Pos, warnings[j].Pos
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Col < b.Col
+	})
+	return warnings
+}
+
+// warningAt is a warning about node. Code a macro expanded to is reported at
+// the outermost call, where a lint:ignore silences that call only, and the
+// message says where in the body it is like errors do. A lint:ignore in the
+// body silences every call.
+func warningAt(node *syntaxTree, rule string, msg string) Warning {
+	w := Warning{Pos: node.position(), Rule: rule, Message: msg}
+	t, ok := node.first()
+	if !ok {
+		return w
+	}
+	if t.exp != nil {
+		w.Pos = outermostCall(t).position()
+	}
+	// pseudo-instructions already sit on their call
+	e := t.exp
+	for e != nil && e.pseudo {
+		e = e.call.exp
+	}
+	if e != nil {
+		w.body = t.position()
+		w.Message = fmt.Sprintf("%s (at %s %v)", msg, t.position(), e)
+	}
+	return w
+}
+
+// displayName is how a label is written in the source, `1@2` is a `1:`
+func displayName(name string) string {
+	if n, _, numeric := strings.Cut(name, "@"); numeric {
+		return n
+	}
+	return name
+}
+
+// labelUses finds where each name is used: the instruction, data item or
+// definition whose expression names it
+func labelUses(st *syntaxTree, dataItems []*syntaxTree, instrs []*syntaxTree) map[string][]*syntaxTree {
+	defs := map[*syntaxTree]bool{}
+	for _, node := range append(append([]*syntaxTree{}, dataItems...), instrs...) {
+		switch node.Symbol.Value {
+		case "identifier":
+			defs[node] = true
+		case "dataItem":
+			defs[node.Children[0]] = true
+		}
+	}
+
+	uses := map[string][]*syntaxTree{}
+	var visit func(node *syntaxTree, user *syntaxTree)
+	visit = func(node *syntaxTree, user *syntaxTree) {
+		switch node.Symbol.Value {
+		case "xInstruction", "yInstruction", "zInstruction", "dataItem", "directive", "equ", "entry", "linkage":
+			user = node
+		}
+		if node.Symbol.Value == "identifier" && !defs[node] && user != nil {
+			uses[node.Data] = append(uses[node.Data], user)
+		}
+		for _, c := range node.Children {
+			visit(c, user)
+		}
+	}
+	visit(st, nil)
+
+	// an equ naming a label uses it wherever the constant is used, an equ
+	// defining the name itself is not a use
+	for name, users := range uses {
+		var kept []*syntaxTree
+		for _, u := range users {
+			if u.Symbol.Value == "equ" && u.Children[1].Data == name {
+				continue
+			}
+			kept = append(kept, u)
+		}
+		uses[name] = kept
+	}
+	return uses
+}
+
+// uninitialized are the data labels whose words are only reserved with .zero
+func uninitialized(dataItems []*syntaxTree) []string {
+	var names []string
+	label, zeroed := "", false
+	done := func() {
+		if label != "" && zeroed {
+			names = append(names, label)
+		}
+		label, zeroed = "", false
+	}
+	for _, node := range dataItems {
+		switch node.Symbol.Value {
+		case "identifier":
+			done()
+			label = node.Data
+		case "dataItem":
+			done()
+		case "directive":
+			if node.Children[0].Data == ".zero" {
+				zeroed = label != ""
+			} else {
+				label = ""
+			}
+		}
+	}
+	done()
+	return names
+}
+
+// lintFlow follows straight line code for jumps that never go anywhere, code
+// nothing reaches and system calls the VM does not have
+func lintFlow(instrs []*syntaxTree, syms *symbolTable, warn func(*syntaxTree, string, string, ...any)) {
+	regs := map[string]int{} // general registers holding a known value
+	var p peephole
+	p.reset()
+	var stop *syntaxTree // what execution cannot get past
+	reported := false
+	reset := func() {
+		clear(regs)
+		p.reset()
+		stop, reported = nil, false
+	}
+
+	for _, node := range instrs {
+		switch {
+		case node.Symbol.Value == "identifier", node.Symbol.Value == "directive":
+			reset()
+			continue
+		case !isInstruction(node):
+			continue
+		}
+
+		if stop != nil && !reported {
+			warn(node, RuleUnreachable, "unreachable, nothing jumps here after the %s", written(stop))
+			reported = true
+		}
+
+		op := node.Children[0].Data
+		ra := ""
+		if len(node.Children) > 1 {
+			ra = node.Children[1].Data
+		}
+		switch {
+		case op == "JMP" && ra == "000":
+			warn(node, RuleNeverBranches, "JMP with mask 000 never jumps")
+		case op == "JMP" && ((ra[1] == '1' && p.flagEQ) || (ra == "111" && p.flagSet)):
+			stop = node
+		case node.Symbol.Value == "xInstruction" && op != "CMP" && (ra == "SP" || ra == "PC"):
+			warn(node, RuleRegisterWrite, "%s writes %s, use PSH/POP for the stack and JMP for jumps", op, ra)
+		case op == "SYS":
+			num, known := regs[ra]
+			if !known {
+				break
+			}
+			if _, ok := vm.Syscalls[byte(num)]; !ok {
+				warn(node, RuleUnknownSyscall, "SYS with %d in %s, the VM has no such system call and stops with 255", num, ra)
+				stop = node
+			} else if num == vm.SysExit {
+				stop = node
+			}
+		}
+		if ra == "PC" && op != "PSH" && op != "STA" && op != "SYS" && op != "CMP" {
+			stop = node
+		}
+
+		_, writes := effects(node)
+		for _, w := range writes {
+			delete(regs, w)
+		}
+		if op == "LDI" && isGeneral(ra) {
+			if v, err := syms.eval(node.Children[2]); err == nil {
+				regs[ra] = v & 0xFF
+			}
+		}
+		if op == "SYS" {
+			clear(regs)
+		}
+		p.step(node)
+	}
+}
+
+// written names an instruction as it is in the source, the pseudo-instruction
+// it came from if any
+func written(node *syntaxTree) string {
+	t, _ := node.first()
+	if t.exp != nil && t.exp.pseudo {
+		return fmt.Sprintf("%s at %s", t.exp.macro, t.exp.call.pos())
+	}
+	return fmt.Sprintf("%s at %s", t.val, t.pos())
+}
+
+// ignored reports whether a comment on the line of w, or a comment line just
+// above it, silences w. For code from a macro the line in the body counts too.
+func ignored(w Warning, sources map[string][]string) bool {
+	var check []string
+	for _, at := range []Position{w.Pos, w.body} {
+		lines := sources[at.File]
+		if at.Line < 1 || at.Line > len(lines) {
+			continue
+		}
+		check = append(check, lines[at.Line-1])
+		if above := at.Line - 2; above >= 0 && strings.TrimSpace(stripComment(lines[above])) == "" {
+			check = append(check, lines[above])
+		}
+	}
+	for _, line := range check {
+		comment := line[len(stripComment(line)):]
+		_, rules, found := strings.Cut(comment, ignoreDirective)
+		if !found {
+			continue
+		}
+		names := strings.FieldsFunc(rules, func(c rune) bool { return c == ',' || c == ' ' || c == '\t' })
+		if len(names) == 0 {
+			return true
+		}
+		for _, name := range names {
+			if name == w.Rule {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unsilenced drops the warnings a lint:ignore comment silences
+func unsilenced(warnings []Warning, sources map[string][]string) []Warning {
+	var out []Warning
+	for _, w := range warnings {
+		if !ignored(w, sources) {
+			out = append(out, w)
+		}
+	}
+	return out
+}
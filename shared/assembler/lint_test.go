@@ -0,0 +1,94 @@
+package assembler
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_lint(t *testing.T) {
+	src := `.data
+count = 3
+unused = 1
+buf:
+	.zero 2
+scratch:
+	.zero 1
+.text
+main:
+	LDA R0, count
+	LDA R1, buf
+	STA R0, scratch
+	LDA R0, scratch
+	JMP 000, main
+	MOV PC R0
+	JMPA done
+	LDI R0, 0x01
+orphan:
+	LDI R0, 0x07
+	PSH R0
+	LDI R0, 0x09
+	SYS R0
+done:
+	EXIT 0x00
+	LDI R0, 0x02
+	# lint:ignore unused-label
+quiet:
+	LDI R1, 0x01 # lint:ignore
+	JMP 000, quiet
+`
+	prog, err := AssembleString("lint.asm", src, Options{Lint: true})
+	if err != nil {
+		t.Fatalf("AssembleString() failed: %s", Explain(err))
+	}
+	want := []string{
+		"lint.asm:3:1: data 'unused' is never used [unused-data]",
+		"lint.asm:11:2: 'buf' is read but never written, it only holds the zeros it was reserved with [uninitialized-read]",
+		"lint.asm:14:2: JMP with mask 000 never jumps [never-branches]",
+		"lint.asm:15:2: MOV writes PC, use PSH/POP for the stack and JMP for jumps [sp-pc-write]",
+		"lint.asm:16:2: unreachable, nothing jumps here after the MOV at lint.asm:15:2 [unreachable]",
+		"lint.asm:18:1: label 'orphan' is never used [unused-label]",
+		"lint.asm:22:2: SYS with 9 in R0, the VM has no such system call and stops with 255 [unknown-syscall]",
+		"lint.asm:25:2: unreachable, nothing jumps here after the EXIT at lint.asm:24:2 [unreachable]",
+		"lint.asm:29:2: JMP with mask 000 never jumps [never-branches]",
+	}
+	if len(prog.Warnings) != len(want) {
+		t.Fatalf("expected %d warnings, got %d:\n%v", len(want), len(prog.Warnings), prog.Warnings)
+	}
+	for i, w := range prog.Warnings {
+		if w.String() != want[i] {
+			t.Fatalf("warning %d = %q, want %q", i, w.String(), want[i])
+		}
+	}
+}
+
+func Test_lintMacro(t *testing.T) {
+	// a warning in a macro body is reported at each call, which can silence it
+	src := `.macro BAD
+	MOV SP R0
+.endm
+.text
+main:
+	BAD
+	BAD # lint:ignore sp-pc-write
+	EXIT 0x00
+`
+	prog, err := AssembleString("l2.asm", src, Options{Lint: true})
+	if err != nil {
+		t.Fatalf("AssembleString() failed: %s", Explain(err))
+	}
+	want := "l2.asm:6:2: MOV writes SP, use PSH/POP for the stack and JMP for jumps " +
+		"(at l2.asm:2:2 in macro 'BAD' defined at l2.asm:1:1, expanded at l2.asm:6:2) [sp-pc-write]"
+	if len(prog.Warnings) != 1 || prog.Warnings[0].String() != want {
+		t.Fatalf("warnings = %v, want %s", prog.Warnings, want)
+	}
+
+	// a lint:ignore in the body silences every call
+	src = strings.Replace(src, "\tMOV SP R0", "\tMOV SP R0 # lint:ignore", 1)
+	prog, err = AssembleString("l2.asm", src, Options{Lint: true})
+	if err != nil {
+		t.Fatalf("AssembleString() failed: %s", Explain(err))
+	}
+	if len(prog.Warnings) != 0 {
+		t.Fatalf("expected no warnings, got %v", prog.Warnings)
+	}
+}
@@ -0,0 +1,187 @@
+package assembler
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	g "tcp-vm/shared/globals"
+	"tcp-vm/shared/vm"
+)
+
+// bytes shown on one row of a listing, longer runs carry on below
+const listingWidth = 4
+
+// listed is one label, data word run or instruction in a listing
+type listed struct {
+	addr  uint8
+	words []byte
+	pos   Position
+	// the instruction written out, set when it came from a pseudo-instruction
+	// so the listing can show what it expanded to
+	expanded string
+	// where the user wrote it, the outermost macro or pseudo-instruction call
+	// for expanded code
+	site Position
+	// an instruction written out, for editor hovers
+	text string
+}
+
+// listRow records node at addr and returns its index, words are filled in
+// once they are known
+func (prog *Program) listRow(node *syntaxTree, addr uint8) int {
+	row := listed{addr: addr, pos: node.position(), site: node.position()}
+	if t, ok := node.first(); ok {
+		if t.exp != nil && t.exp.pseudo {
+			row.expanded = instructionText(node)
+		}
+		for e := t.exp; e != nil; e = e.call.exp {
+			row.site = e.call.position()
+		}
+	}
+	if isInstruction(node) {
+		row.text = instructionText(node)
+	}
+	prog.listing = append(prog.listing, row)
+	return len(prog.listing) - 1
+}
+
+// instructionText writes an instruction node back out as source
+func instructionText(node *syntaxTree) string {
+	var operands []string
+	for _, c := range node.Children[1:] {
+		var words []string
+		for _, t := range terminalTokens(c) {
+			words = append(words, t.val)
+		}
+		operands = append(operands, strings.Join(words, " "))
+	}
+	if node.Symbol.Value == "zInstruction" {
+		return node.Children[0].Data + " " + strings.Join(operands, ", ")
+	}
+	return strings.Join(append([]string{node.Children[0].Data}, operands...), " ")
+}
+
+// sourceLine returns the text of the line at pos, without its indentation
+func (prog *Program) sourceLine(pos Position) string {
+	lines := prog.sources[pos.File]
+	if pos.Line < 1 || pos.Line > len(lines) {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(lines[pos.Line-1], "\r"))
+}
+
+// WriteListing writes every label, data word and instruction with its address,
+// encoding and the source line it came from, then the symbol table.
+// Instructions a pseudo-instruction expanded to are shown under it with a +.
+func (prog *Program) WriteListing(w io.Writer) error {
+	var b strings.Builder
+
+	dataUsed, textUsed := 0, 0
+	for _, row := range prog.listing {
+		if row.addr >= vm.TextStart {
+			textUsed += len(row.words)
+		} else {
+			dataUsed += len(row.words)
+		}
+	}
+	fmt.Fprintf(&b, "# data: %d of %d words, %d free\n", dataUsed, g.DataSectionLength, g.DataSectionLength-dataUsed)
+	fmt.Fprintf(&b, "# text: %d of %d bytes, %d free\n", textUsed, g.TextSectionLength, g.TextSectionLength-textUsed)
+	fmt.Fprintf(&b, "# entry: 0x%02X\n", prog.Entry)
+	if len(prog.Optimizations) > 0 {
+		fmt.Fprintf(&b, "# optimized: %d bytes saved by %d rewrites\n", prog.Saved(), len(prog.Optimizations))
+	}
+
+	section := ""
+	var last Position
+	for i, row := range prog.listing {
+		if s := sectionOf(row.addr); s != section {
+			section = s
+			fmt.Fprintf(&b, "\n%s\n", section)
+		}
+
+		sameLine := row.pos.File == last.File && row.pos.Line == last.Line
+		last = row.pos
+		at := fmt.Sprintf("%s:%d", row.pos.File, row.pos.Line)
+		if row.pos.File == "" {
+			at = fmt.Sprintf("line %d", row.pos.Line)
+		}
+
+		// a label sharing its line with what follows is shown on that row
+		if len(row.words) == 0 && row.expanded == "" && i+1 < len(prog.listing) {
+			next := prog.listing[i+1].pos
+			if next.File == row.pos.File && next.Line == row.pos.Line {
+				last = Position{}
+				continue
+			}
+		}
+
+		text := prog.sourceLine(row.pos)
+		if row.expanded != "" {
+			if !sameLine {
+				writeRow(&b, row.addr, nil, at, text)
+			}
+			at, text = "", "  + "+row.expanded
+		} else if sameLine {
+			at, text = "", ""
+		}
+		writeRow(&b, row.addr, row.words, at, text)
+	}
+
+	b.WriteString("\nsymbols\n")
+	names := make([]string, 0, len(prog.Symbols))
+	for name := range prog.Symbols {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := prog.Symbols[names[i]], prog.Symbols[names[j]]
+		if a != b {
+			return a < b
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		addr := prog.Symbols[name]
+		fmt.Fprintf(&b, "0x%02X  %-5s %s\n", addr, strings.TrimPrefix(sectionOf(addr), "."), name)
+	}
+
+	names = names[:0]
+	for name := range prog.Constants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "%-5d %-5s %s\n", prog.Constants[name], ".equ", name)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func sectionOf(addr uint8) string {
+	if addr >= vm.TextStart {
+		return ".text"
+	}
+	return ".data"
+}
+
+// writeRow writes one listing row, words past listingWidth go on rows of their
+// own below it
+func writeRow(b *strings.Builder, addr uint8, words []byte, at string, text string) {
+	for {
+		n := min(len(words), listingWidth)
+		var hex []string
+		for _, w := range words[:n] {
+			hex = append(hex, fmt.Sprintf("%02X", w))
+		}
+		line := fmt.Sprintf("0x%02X  %-*s  %-16s %s", addr, listingWidth*3-1, strings.Join(hex, " "), at, text)
+		b.WriteString(strings.TrimRight(line, " ") + "\n")
+
+		words = words[n:]
+		if len(words) == 0 {
+			return
+		}
+		addr += uint8(n)
+		at, text = "", ""
+	}
+}
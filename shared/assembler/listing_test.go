@@ -0,0 +1,58 @@
+package assembler
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_writeListing(t *testing.T) {
+	src := `.equ COUNT 4
+.data
+	count = COUNT
+table:
+	.byte 1, 2, 3, 4, 5, 6
+.text
+main:
+	LDA R0, count
+loop:	ADD R0 R1
+	INC R1
+	JMP 010, loop
+`
+	prog, err := AssembleString("list.asm", src, Options{})
+	if err != nil {
+		t.Fatalf("AssembleString() failed: %v", err)
+	}
+
+	var b strings.Builder
+	if err := prog.WriteListing(&b); err != nil {
+		t.Fatalf("WriteListing() failed: %v", err)
+	}
+	listing := b.String()
+
+	want := []string{
+		"# data: 7 of 16 words, 9 free",
+		"# text: 8 of 175 bytes, 167 free",
+		"0x00  04           list.asm:3       count = COUNT",
+		"0x01               list.asm:4       table:",
+		"0x01  01 02 03 04  list.asm:5       .byte 1, 2, 3, 4, 5, 6",
+		"0x05  05 06\n",
+		"0x51               list.asm:7       main:",
+		"0x51  E0 00        list.asm:8       LDA R0, count",
+		// the label shares a row with the instruction on its line
+		"0x53  41           list.asm:9       loop:\tADD R0 R1",
+		"0x54               list.asm:10      INC R1",
+		"0x54  D0 01                           + LDI R0, 0x01",
+		"0x56  44                              + ADD R1 R0",
+		"0x57  C2 53        list.asm:11      JMP 010, loop",
+		"0x53  text  loop",
+		"4     .equ  COUNT",
+	}
+	for _, line := range want {
+		if !strings.Contains(listing, line) {
+			t.Fatalf("listing is missing %q:\n%s", line, listing)
+		}
+	}
+	if strings.Count(listing, "list.asm:9 ") != 1 {
+		t.Fatalf("line 9 is listed more than once:\n%s", listing)
+	}
+}
@@ -0,0 +1,663 @@
+package assembler
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"tcp-vm/shared/vm"
+)
+
+// object file layout (big endian):
+//
+//	4 Bytes # magic "TVMO"
+//	1 Byte  # version
+//	1 Byte  # string count, then each string as 1 Byte length + bytes
+//	1 Byte  # .data length, then the words
+//	1 Byte  # .text length, then the words
+//	1 Byte  # entry label string, the empty string for a library
+//	1 Byte  # symbol count, then per symbol:
+//	          1 Byte name string, 1 Byte section, 1 Byte offset, 1 Byte flags (1 global)
+//	1 Byte  # import count, then per import: 1 Byte name string
+//	1 Byte  # relocation count, then per relocation:
+//	          1 Byte section, 1 Byte offset, 1 Byte symbol string, 2 Bytes addend
+//	1 Byte  # line count, then per line:
+//	          1 Byte section, 1 Byte offset, 1 Byte file string, 2 Bytes line, 1 Byte column
+//
+// Names share the string table like the debug info does, see shared/debuginfo.
+const (
+	ObjectMagic   = "TVMO"
+	ObjectVersion = 1
+)
+
+// counts and string table indexes are single bytes
+const maxObjectEntries = 255
+
+// SectionID is the part of memory a symbol or relocation is in, offsets are
+// from its start
+type SectionID byte
+
+const (
+	DataSection SectionID = iota
+	TextSection
+)
+
+func (s SectionID) String() string {
+	if s == TextSection {
+		return ".text"
+	}
+	return ".data"
+}
+
+// Start is the address the section begins at in a loaded program
+func (s SectionID) Start() uint8 {
+	if s == TextSection {
+		return vm.TextStart
+	}
+	return vm.DataStart
+}
+
+func sectionAt(addr uint8) SectionID {
+	if addr >= vm.TextStart {
+		return TextSection
+	}
+	return DataSection
+}
+
+// ObjectSymbol is a label of an object, only global ones are seen by other
+// objects
+type ObjectSymbol struct {
+	Name    string
+	Section SectionID
+	Offset  uint8
+	Global  bool
+}
+
+// Relocation is a word whose value is the address of Symbol plus Addend, known
+// once the objects are laid out. Symbol is an import or the name of one of the
+// object's own sections, `.data` or `.text`, for its local labels.
+type Relocation struct {
+	Section SectionID
+	Offset  uint8
+	Symbol  string
+	Addend  int
+}
+
+// SourceLine is where the word at Offset of Section was written
+type SourceLine struct {
+	Section SectionID
+	Offset  uint8
+	Pos     Position
+}
+
+// Object is a relocatable piece of a program, shared/linker puts objects
+// together into a Program
+type Object struct {
+	// where the object came from, for messages, it is not encoded
+	Name string
+
+	Data []byte
+	Text []byte
+	// text label execution starts at, empty for a library
+	Entry string
+
+	Symbols     []ObjectSymbol
+	Imports     []string
+	Relocations []Relocation
+	Lines       []SourceLine
+
+	// problems that did not stop the object from assembling, not encoded
+	Warnings []Warning
+}
+
+// Symbol returns the symbol called name
+func (obj *Object) Symbol(name string) (ObjectSymbol, bool) {
+	for _, s := range obj.Symbols {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return ObjectSymbol{}, false
+}
+
+// linkage records `.global NAME` and `.extern NAME`, they are checked once
+// every label is known
+func (syms *symbolTable) linkage(node *syntaxTree) error {
+	kind, name := node.Children[0].Data, node.Children[1].Data
+	seen := syms.globals
+	if kind == ".extern" {
+		seen = syms.externs
+	}
+	if prev, dup := seen[name]; dup {
+		return errorfAt(node, "duplicate %s '%s', first given at %s", kind, name, prev.pos())
+	}
+	seen[name] = node
+	return nil
+}
+
+// checkLinkage makes sure every global is a label and every extern is not
+// defined here. A plain program has nothing to import from.
+func (syms *symbolTable) checkLinkage() error {
+	for _, name := range sortedNames(syms.globals) {
+		if _, ok := syms.labels[name]; !ok {
+			return errorfAt(syms.globals[name], ".global '%s' is not a label of this file", name)
+		}
+	}
+	for _, name := range sortedNames(syms.externs) {
+		node := syms.externs[name]
+		if _, ok := syms.labels[name]; ok {
+			return errorfAt(node, "'%s' is declared .extern but is a label of this file", name)
+		}
+		if _, ok := syms.equs[name]; ok {
+			return errorfAt(node, "'%s' is declared .extern but is a constant of this file", name)
+		}
+		if syms.object == nil {
+			return errorfAt(node, ".extern '%s' has to be resolved by the linker, link with the file that exports it", name)
+		}
+	}
+	return nil
+}
+
+func sortedNames(m map[string]*syntaxTree) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// relocate records a relocation for the word at offset of section when the
+// value of node depends on where the object is placed, see relocTarget. So
+// `end - start` is constant and `table + 2` is relocated against .data.
+func (syms *symbolTable) relocate(section SectionID, offset int, node *syntaxTree) error {
+	if syms.object == nil {
+		return nil
+	}
+	base, err := syms.eval(node)
+	if err != nil {
+		return err
+	}
+	target, err := syms.relocTarget(node)
+	if err != nil || target == "" {
+		return err
+	}
+
+	addend := base
+	switch target {
+	case ".data":
+		addend -= int(vm.DataStart)
+	case ".text":
+		addend -= int(vm.TextStart)
+	}
+	syms.object.Relocations = append(syms.object.Relocations, Relocation{
+		Section: section,
+		Offset:  uint8(offset),
+		Symbol:  target,
+		Addend:  addend,
+	})
+	return nil
+}
+
+// relocTarget works out what the value of node moves with when the object is
+// placed: .data or .text for a local label, the name of an extern, or "" for
+// a constant. Only an address plus or minus a constant can be relocated, lo()
+// of one is the address itself since addresses are a word.
+func (syms *symbolTable) relocTarget(node *syntaxTree) (string, error) {
+	switch node.Symbol.Value {
+	case "identifier":
+		if addr, ok := syms.labels[node.Data]; ok {
+			return sectionOf(addr), nil
+		}
+		if _, ok := syms.externs[node.Data]; ok {
+			return node.Data, nil
+		}
+		if equ, ok := syms.equs[node.Data]; ok {
+			return syms.relocTarget(equ.Children[2])
+		}
+
+	case "expr":
+		operands, operators := exprParts(node)
+		targets := make([]string, len(operands))
+		for i, operand := range operands {
+			t, err := syms.relocTarget(operand)
+			if err != nil {
+				return "", err
+			}
+			targets[i] = t
+		}
+		return climb(targets, operators, 0, combineTargets)
+
+	case "exprAtom":
+		first := node.Children[0]
+		switch first.Symbol.Value {
+		case "(":
+			return syms.relocTarget(node.Children[1])
+		case "-":
+			t, err := syms.relocTarget(node.Children[1])
+			if err != nil || t == "" {
+				return "", err
+			}
+			return "", notRelocatable(t)
+		case "identifier":
+			if first.Data != "lo" && first.Data != "hi" {
+				return "", nil
+			}
+			t, err := syms.relocTarget(node.Children[1].Children[1])
+			if err != nil || t == "" || first.Data == "lo" {
+				return t, err
+			}
+			return "", notRelocatable(t)
+		}
+	}
+	return "", nil
+}
+
+// combineTargets is what `a op b` moves with, given what a and b move with
+func combineTargets(op string, a string, b string) (string, error) {
+	switch {
+	case a == "" && b == "":
+		return "", nil
+	case op == "+" && (a == "" || b == ""):
+		return a + b, nil
+	case op == "-" && b == "":
+		return a, nil
+	case op == "-" && a == b:
+		// the distance between two addresses that move together
+		return "", nil
+	case (op == "+" || op == "-") && a != "" && b != "" && a != b:
+		return "", fmt.Errorf("value depends on both %s and %s, only one can be relocated", describeTarget(a), describeTarget(b))
+	}
+	if a == "" {
+		return "", notRelocatable(b)
+	}
+	return "", notRelocatable(a)
+}
+
+func notRelocatable(target string) error {
+	return fmt.Errorf("value depends on %s in a way that cannot be relocated, only an address plus or minus a constant can", describeTarget(target))
+}
+
+func describeTarget(target string) string {
+	switch target {
+	case ".data":
+		return "a data label"
+	case ".text":
+		return "a text label"
+	}
+	return fmt.Sprintf("'%s'", target)
+}
+
+// fillObject copies what compile worked out into syms.object
+func (syms *symbolTable) fillObject(prog *Program, dataSize int, textSize int) {
+	obj := syms.object
+	obj.Data = append([]byte(nil), prog.Data[:dataSize]...)
+	obj.Text = append([]byte(nil), prog.Text[:textSize]...)
+	obj.Warnings = prog.Warnings
+
+	for name, addr := range syms.labels {
+		s := sectionAt(addr)
+		_, global := syms.globals[name]
+		obj.Symbols = append(obj.Symbols, ObjectSymbol{
+			Name:    name,
+			Section: s,
+			Offset:  addr - s.Start(),
+			Global:  global,
+		})
+	}
+	sort.Slice(obj.Symbols, func(i, j int) bool { return obj.Symbols[i].Name < obj.Symbols[j].Name })
+
+	obj.Imports = sortedNames(syms.externs)
+
+	for addr, pos := range prog.SourceMap {
+		s := sectionAt(addr)
+		obj.Lines = append(obj.Lines, SourceLine{Section: s, Offset: addr - s.Start(), Pos: pos})
+	}
+	sort.Slice(obj.Lines, func(i, j int) bool {
+		a, b := obj.Lines[i], obj.Lines[j]
+		if a.Section != b.Section {
+			return a.Section < b.Section
+		}
+		return a.Offset < b.Offset
+	})
+}
+
+// assembleObject parses and compiles tokens into a relocatable object
+func assembleObject(name string, tokens []token, opts Options) (*Object, error) {
+	simp, err := parseTokens(tokens)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Optimize {
+		optimize(simp)
+	}
+	syms := newSymbolTable()
+	syms.object = &Object{Name: name}
+	if _, err := simp.compileWith(syms); err != nil {
+		return nil, fmt.Errorf("compile() filed: %w", err)
+	}
+	return syms.object, nil
+}
+
+// AssembleObjectFile assembles the file at path, with every file it includes,
+// into a relocatable object
+func AssembleObjectFile(path string, opts Options) (*Object, error) {
+	pp := newPreprocessor(opts)
+	lines, err := pp.include(path, nil)
+	if err != nil {
+		return nil, withSource(err, pp.sources)
+	}
+	tokens, err := pp.finish(lines)
+	if err != nil {
+		return nil, withSource(err, pp.sources)
+	}
+	obj, err := assembleObject(path, tokens, opts)
+	if err != nil {
+		return nil, withSource(err, pp.sources)
+	}
+	return obj, nil
+}
+
+// AssembleObjectString assembles src into a relocatable object, name is used
+// in positions and messages
+func AssembleObjectString(name string, src string, opts Options) (*Object, error) {
+	pp := newPreprocessor(opts)
+	lines, err := pp.read(strings.NewReader(src), name, nil)
+	if err != nil {
+		return nil, withSource(err, pp.sources)
+	}
+	tokens, err := pp.finish(lines)
+	if err != nil {
+		return nil, withSource(err, pp.sources)
+	}
+	obj, err := assembleObject(name, tokens, opts)
+	if err != nil {
+		return nil, withSource(err, pp.sources)
+	}
+	return obj, nil
+}
+
+// ReadObjectFile reads an object written with Marshal
+func ReadObjectFile(path string) (*Object, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := ParseObject(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	obj.Name = path
+	return obj, nil
+}
+
+func (obj *Object) Marshal() ([]byte, error) {
+	var strs []string
+	index := map[string]int{}
+	intern := func(s string) (byte, error) {
+		if len(s) > 255 {
+			return 0, fmt.Errorf("name '%s...' is longer than 255 bytes", s[:16])
+		}
+		if i, ok := index[s]; ok {
+			return byte(i), nil
+		}
+		if len(strs) == maxObjectEntries {
+			return 0, fmt.Errorf("more than %d file and symbol names", maxObjectEntries)
+		}
+		index[s] = len(strs)
+		strs = append(strs, s)
+		return byte(len(strs) - 1), nil
+	}
+	count := func(what string, n int) (byte, error) {
+		if n > maxObjectEntries {
+			return 0, fmt.Errorf("%d %s, at most %d fit", n, what, maxObjectEntries)
+		}
+		return byte(n), nil
+	}
+
+	var body []byte
+	for _, section := range [][]byte{obj.Data, obj.Text} {
+		n, err := count("words in a section", len(section))
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, n)
+		body = append(body, section...)
+	}
+
+	entry, err := intern(obj.Entry)
+	if err != nil {
+		return nil, err
+	}
+	body = append(body, entry)
+
+	n, err := count("symbols", len(obj.Symbols))
+	if err != nil {
+		return nil, err
+	}
+	body = append(body, n)
+	for _, s := range obj.Symbols {
+		name, err := intern(s.Name)
+		if err != nil {
+			return nil, err
+		}
+		var flags byte
+		if s.Global {
+			flags = 1
+		}
+		body = append(body, name, byte(s.Section), s.Offset, flags)
+	}
+
+	if n, err = count("imports", len(obj.Imports)); err != nil {
+		return nil, err
+	}
+	body = append(body, n)
+	for _, imp := range obj.Imports {
+		name, err := intern(imp)
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, name)
+	}
+
+	if n, err = count("relocations", len(obj.Relocations)); err != nil {
+		return nil, err
+	}
+	body = append(body, n)
+	for _, r := range obj.Relocations {
+		sym, err := intern(r.Symbol)
+		if err != nil {
+			return nil, err
+		}
+		if r.Addend < -0x8000 || r.Addend > 0x7FFF {
+			return nil, fmt.Errorf("addend %d of '%s' does not fit in 16 bits", r.Addend, r.Symbol)
+		}
+		body = append(body, byte(r.Section), r.Offset, sym)
+		body = binary.BigEndian.AppendUint16(body, uint16(int16(r.Addend)))
+	}
+
+	if n, err = count("lines", len(obj.Lines)); err != nil {
+		return nil, err
+	}
+	body = append(body, n)
+	for _, l := range obj.Lines {
+		file, err := intern(l.Pos.File)
+		if err != nil {
+			return nil, err
+		}
+		if l.Pos.Line < 0 || l.Pos.Line > 0xFFFF || l.Pos.Col < 0 || l.Pos.Col > 0xFF {
+			return nil, fmt.Errorf("position %d:%d does not fit", l.Pos.Line, l.Pos.Col)
+		}
+		body = append(body, byte(l.Section), l.Offset, file)
+		body = binary.BigEndian.AppendUint16(body, uint16(l.Pos.Line))
+		body = append(body, byte(l.Pos.Col))
+	}
+
+	buf := append([]byte(ObjectMagic), ObjectVersion, byte(len(strs)))
+	for _, s := range strs {
+		buf = append(buf, byte(len(s)))
+		buf = append(buf, s...)
+	}
+	return append(buf, body...), nil
+}
+
+var errShortObject = errors.New("object: truncated")
+
+func ParseObject(raw []byte) (*Object, error) {
+	if len(raw) < len(ObjectMagic)+2 || string(raw[:len(ObjectMagic)]) != ObjectMagic {
+		return nil, fmt.Errorf("object: bad magic")
+	}
+	if raw[len(ObjectMagic)] != ObjectVersion {
+		return nil, fmt.Errorf("object: unsupported version %d", raw[len(ObjectMagic)])
+	}
+
+	r := raw[len(ObjectMagic)+1:]
+	take := func(n int) ([]byte, error) {
+		if len(r) < n {
+			return nil, errShortObject
+		}
+		out := r[:n]
+		r = r[n:]
+		return out, nil
+	}
+
+	n, err := take(1)
+	if err != nil {
+		return nil, err
+	}
+	strs := make([]string, n[0])
+	for i := range strs {
+		size, err := take(1)
+		if err != nil {
+			return nil, err
+		}
+		s, err := take(int(size[0]))
+		if err != nil {
+			return nil, err
+		}
+		strs[i] = string(s)
+	}
+	str := func(i byte) (string, error) {
+		if int(i) >= len(strs) {
+			return "", fmt.Errorf("object: string %d out of range", i)
+		}
+		return strs[i], nil
+	}
+	section := func(b byte) (SectionID, error) {
+		if SectionID(b) != DataSection && SectionID(b) != TextSection {
+			return 0, fmt.Errorf("object: unknown section %d", b)
+		}
+		return SectionID(b), nil
+	}
+
+	obj := &Object{}
+	for _, dst := range []*[]byte{&obj.Data, &obj.Text} {
+		if n, err = take(1); err != nil {
+			return nil, err
+		}
+		words, err := take(int(n[0]))
+		if err != nil {
+			return nil, err
+		}
+		*dst = append([]byte(nil), words...)
+	}
+
+	if n, err = take(1); err != nil {
+		return nil, err
+	}
+	if obj.Entry, err = str(n[0]); err != nil {
+		return nil, err
+	}
+
+	if n, err = take(1); err != nil {
+		return nil, err
+	}
+	for range n[0] {
+		e, err := take(4)
+		if err != nil {
+			return nil, err
+		}
+		name, err := str(e[0])
+		if err != nil {
+			return nil, err
+		}
+		s, err := section(e[1])
+		if err != nil {
+			return nil, err
+		}
+		obj.Symbols = append(obj.Symbols, ObjectSymbol{Name: name, Section: s, Offset: e[2], Global: e[3]&1 != 0})
+	}
+
+	if n, err = take(1); err != nil {
+		return nil, err
+	}
+	for range n[0] {
+		e, err := take(1)
+		if err != nil {
+			return nil, err
+		}
+		name, err := str(e[0])
+		if err != nil {
+			return nil, err
+		}
+		obj.Imports = append(obj.Imports, name)
+	}
+
+	if n, err = take(1); err != nil {
+		return nil, err
+	}
+	for range n[0] {
+		e, err := take(5)
+		if err != nil {
+			return nil, err
+		}
+		s, err := section(e[0])
+		if err != nil {
+			return nil, err
+		}
+		sym, err := str(e[2])
+		if err != nil {
+			return nil, err
+		}
+		obj.Relocations = append(obj.Relocations, Relocation{
+			Section: s,
+			Offset:  e[1],
+			Symbol:  sym,
+			Addend:  int(int16(binary.BigEndian.Uint16(e[3:5]))),
+		})
+	}
+
+	if n, err = take(1); err != nil {
+		return nil, err
+	}
+	for range n[0] {
+		e, err := take(6)
+		if err != nil {
+			return nil, err
+		}
+		s, err := section(e[0])
+		if err != nil {
+			return nil, err
+		}
+		file, err := str(e[2])
+		if err != nil {
+			return nil, err
+		}
+		obj.Lines = append(obj.Lines, SourceLine{
+			Section: s,
+			Offset:  e[1],
+			Pos: Position{
+				File: file,
+				Line: int(binary.BigEndian.Uint16(e[3:5])),
+				Col:  int(e[5]),
+			},
+		})
+	}
+
+	if len(r) != 0 {
+		return nil, fmt.Errorf("object: %d trailing bytes", len(r))
+	}
+	return obj, nil
+}
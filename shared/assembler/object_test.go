@@ -0,0 +1,127 @@
+package assembler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_objectRelocations(t *testing.T) {
+	src := `.extern print
+.global main
+.data
+table:
+	.byte 1, 2, 3
+end:
+	ptr = table + 2
+.text
+main:
+	LDI R0, end - table
+	LDA R1, ptr
+	JMP 010, loop
+loop:
+	LDI R0, print - 1
+	.byte main
+`
+	obj, err := AssembleObjectString("obj.asm", src, Options{})
+	if err != nil {
+		t.Fatalf("AssembleObjectString() failed: %v", err)
+	}
+
+	want := []Relocation{
+		{Section: DataSection, Offset: 3, Symbol: ".data", Addend: 2},
+		{Section: TextSection, Offset: 3, Symbol: ".data", Addend: 3},
+		{Section: TextSection, Offset: 5, Symbol: ".text", Addend: 6},
+		{Section: TextSection, Offset: 7, Symbol: "print", Addend: -1},
+		{Section: TextSection, Offset: 8, Symbol: ".text", Addend: 0},
+	}
+	if !reflect.DeepEqual(obj.Relocations, want) {
+		t.Fatalf("relocations:\n got %+v\nwant %+v", obj.Relocations, want)
+	}
+	if obj.Entry != "main" || !reflect.DeepEqual(obj.Imports, []string{"print"}) {
+		t.Fatalf("entry %q and imports %v, want main and [print]", obj.Entry, obj.Imports)
+	}
+	if sym, ok := obj.Symbol("main"); !ok || !sym.Global || sym.Section != TextSection {
+		t.Fatalf("main = %+v, want a global text symbol", sym)
+	}
+	if sym, ok := obj.Symbol("loop"); !ok || sym.Global || sym.Offset != 6 {
+		t.Fatalf("loop = %+v, want a local at offset 6", sym)
+	}
+
+	raw, err := obj.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	got, err := ParseObject(raw)
+	if err != nil {
+		t.Fatalf("ParseObject() failed: %v", err)
+	}
+	got.Name, got.Warnings = obj.Name, obj.Warnings
+	if !reflect.DeepEqual(got, obj) {
+		t.Fatalf("round trip changed the object:\n got %+v\nwant %+v", got, obj)
+	}
+	if _, err := ParseObject(raw[:len(raw)-1]); err == nil {
+		t.Fatalf("expected a truncated object to fail")
+	}
+}
+
+func Test_objectRelocatedLo(t *testing.T) {
+	// lo() of an address is the address, however far into .text it is
+	src := ".text\nmain:\n\tLDI R1, lo(far) + 1\n\t.zero 120\nfar:\n\tEXIT 0x00\n"
+	obj, err := AssembleObjectString("lo.asm", src, Options{})
+	if err != nil {
+		t.Fatalf("AssembleObjectString() failed: %v", err)
+	}
+	want := []Relocation{{Section: TextSection, Offset: 1, Symbol: ".text", Addend: 123}}
+	if !reflect.DeepEqual(obj.Relocations, want) {
+		t.Fatalf("relocations:\n got %+v\nwant %+v", obj.Relocations, want)
+	}
+}
+
+func Test_objectErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{
+			src:  ".extern print\n.text\nmain:\n\tLDI R0, print * 2\n",
+			want: "value depends on 'print' in a way that cannot be relocated",
+		},
+		{
+			src:  ".extern a\n.extern b\n.text\nmain:\n\tLDI R0, a + b\n",
+			want: "value depends on both 'a' and 'b'",
+		},
+		{
+			src:  ".text\nmain:\n\tLDI R0, main & 0xF0\n",
+			want: "value depends on a text label in a way that cannot be relocated",
+		},
+		{
+			src:  ".text\nmain:\n\tLDI R0, hi(main)\n",
+			want: "value depends on a text label in a way that cannot be relocated",
+		},
+		{
+			src:  ".data\nx:\n\t.byte 1\n.text\nmain:\n\tLDI R0, main - x\n",
+			want: "value depends on both a text label and a data label",
+		},
+		{
+			src:  ".global missing\n.text\nmain:\n\tEXIT 0x00\n",
+			want: ".global 'missing' is not a label of this file",
+		},
+		{
+			src:  ".extern main\n.text\nmain:\n\tEXIT 0x00\n",
+			want: "'main' is declared .extern but is a label of this file",
+		},
+	}
+	for _, tt := range tests {
+		_, err := AssembleObjectString("bad.asm", tt.src, Options{})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Fatalf("expected an error containing %q, got %v", tt.want, err)
+		}
+	}
+
+	// a plain program has no linker to resolve an extern
+	_, err := AssembleString("plain.asm", ".extern print\n.text\nmain:\n\tEXIT 0x00\n", Options{})
+	if err == nil || !strings.Contains(err.Error(), "has to be resolved by the linker") {
+		t.Fatalf("expected .extern to fail outside an object, got %v", err)
+	}
+}
@@ -0,0 +1,356 @@
+package assembler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The peephole optimizer rewrites short runs of instructions in the text
+// section before labels are laid out, so everything after a rewrite moves up
+// with it:
+//
+//	PSH R0 / POP R0              removed
+//	PSH R0 / POP R1              MOV R1 R0
+//	LDI R0, x ... LDI R0, x      the second one is removed while R0 still holds x
+//	CMP R0 R0                    removed when the flag already holds EQ
+//	CMP R0 R0 / JMP 010, x       JMP 111, x when the flag is already set and
+//	                             nothing at x reads it before setting it again
+//	JMP m, x / x:                removed, with a CMP in front of it when x does
+//	                             not read the flag
+//
+// What a register or the flag holds is only followed through straight line
+// code, a label or directive starts afresh. The code under a label that is used
+// in arithmetic, `loop + 1`, is left as written since the offset points into it.
+
+// Optimization is one rewrite made by the peephole optimizer
+type Optimization struct {
+	Pos     Position
+	Message string
+	Saved   int // bytes
+}
+
+func (o Optimization) String() string {
+	return fmt.Sprintf("%v: %s, %d bytes saved", o.Pos, o.Message, o.Saved)
+}
+
+// Saved is the number of text bytes the optimizer saved
+func (prog *Program) Saved() int {
+	saved := 0
+	for _, o := range prog.Optimizations {
+		saved += o.Saved
+	}
+	return saved
+}
+
+// what is known about the machine at a point in straight line code
+type peephole struct {
+	regs    map[string]string // general register to the expression it was loaded with
+	flagSet bool              // a CMP has run, so exactly one flag bit is set
+	flagEQ  bool              // the flag holds EQ
+}
+
+func (p *peephole) reset() {
+	p.regs = map[string]string{}
+	p.flagSet = false
+	p.flagEQ = false
+}
+
+// step follows what node does to the registers and the flag
+func (p *peephole) step(node *syntaxTree) {
+	op := node.Children[0].Data
+	_, writes := effects(node)
+	for _, w := range writes {
+		delete(p.regs, w)
+	}
+	if len(node.Children) > 1 && node.Children[1].Data == "PC" && op != "PSH" && op != "STA" && op != "SYS" {
+		// a jump anywhere
+		p.reset()
+		return
+	}
+
+	switch op {
+	case "LDI":
+		if ra := node.Children[1].Data; isGeneral(ra) {
+			p.regs[ra] = exprKey(node.Children[2])
+		}
+	case "MOV":
+		if ra, rb := node.Children[1].Data, node.Children[2].Data; isGeneral(ra) {
+			if v, ok := p.regs[rb]; ok {
+				p.regs[ra] = v
+			}
+		}
+	case "CMP":
+		p.flagSet = true
+		p.flagEQ = node.Children[1].Data == node.Children[2].Data
+	case "SYS":
+		// the call may change anything
+		p.reset()
+	case "STA":
+		// it may write the flag word
+		p.flagSet = false
+		p.flagEQ = false
+	}
+}
+
+func isGeneral(reg string) bool {
+	return reg == "R0" || reg == "R1"
+}
+
+func isInstruction(node *syntaxTree) bool {
+	switch node.Symbol.Value {
+	case "xInstruction", "yInstruction", "zInstruction":
+		return true
+	}
+	return false
+}
+
+// takes no room and changes nothing at run time
+func isBookkeeping(node *syntaxTree) bool {
+	switch node.Symbol.Value {
+	case "equ", "linkage", "entry":
+		return true
+	}
+	return false
+}
+
+func isOp(node *syntaxTree, op string) bool {
+	return isInstruction(node) && node.Children[0].Data == op
+}
+
+// isSelfCompare is CMP of a register with itself, which always gives EQ
+func isSelfCompare(node *syntaxTree) bool {
+	return isOp(node, "CMP") && node.Children[1].Data == node.Children[2].Data
+}
+
+// exprKey is an expression written out, equal keys give equal values
+func exprKey(expr *syntaxTree) string {
+	var parts []string
+	for _, t := range terminalTokens(expr) {
+		parts = append(parts, t.val)
+	}
+	return strings.Join(parts, " ")
+}
+
+// jumpTarget is the label a JMP goes to, if it names one on its own
+func jumpTarget(node *syntaxTree) (string, bool) {
+	if !isOp(node, "JMP") || node.Children[2].Symbol.Value != "identifier" {
+		return "", false
+	}
+	return node.Children[2].Data, true
+}
+
+// offsetLabels are the labels used in arithmetic anywhere in the program
+func offsetLabels(st *syntaxTree) map[string]bool {
+	labels := map[string]bool{}
+	var walk func(*syntaxTree)
+	walk = func(node *syntaxTree) {
+		if node.Symbol.Value == "identifier" && node.Parent != nil && strings.HasPrefix(node.Parent.Symbol.Value, "expr") {
+			labels[node.Data] = true
+		}
+		for _, c := range node.Children {
+			walk(c)
+		}
+	}
+	walk(st)
+	return labels
+}
+
+// flagDead reports whether the code at label sets the flag before anything
+// can read it
+func flagDead(items []*syntaxTree, label string) bool {
+	at := -1
+	for i, node := range items {
+		if node.Symbol.Value == "identifier" && node.Data == label {
+			at = i
+			break
+		}
+	}
+	if at < 0 {
+		// not in this file
+		return false
+	}
+
+	for _, node := range items[at+1:] {
+		if node.Symbol.Value == "identifier" || isBookkeeping(node) {
+			continue
+		}
+		if !isInstruction(node) {
+			return false
+		}
+		op := node.Children[0].Data
+		switch {
+		case op == "CMP" || op == "SYS":
+			return true
+		case op == "JMP" && node.Children[1].Data != "000":
+			return false
+		case op == "LDA" && node.Children[2].Symbol.Value != "identifier":
+			// the address could be the flag word
+			return false
+		case op != "PSH" && op != "STA" && node.Children[1].Data == "PC":
+			return false
+		}
+	}
+	return false
+}
+
+// nextIs reports whether only labels and bookkeeping stand between items[i]
+// and the label
+func nextIs(items []*syntaxTree, i int, label string) bool {
+	for _, node := range items[i+1:] {
+		switch {
+		case node.Symbol.Value == "identifier" && node.Data == label:
+			return true
+		case node.Symbol.Value == "identifier" || isBookkeeping(node):
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// optimize rewrites the text section of st in place and returns what it did
+func optimize(st *syntaxTree) []Optimization {
+	var list *syntaxTree
+	for _, sec := range st.Children {
+		if sec.Symbol.Value != "text" {
+			continue
+		}
+		for _, c := range sec.Children {
+			if c.Symbol.Value == "textList" {
+				list = c
+			}
+		}
+	}
+	if list == nil {
+		// a single item, nothing to look at
+		return nil
+	}
+
+	frozen := offsetLabels(st)
+	var done []Optimization
+	// one rewrite can make way for another, PSH/POP around a repeated LDI
+	for {
+		items, found := optimizePass(list.Children, frozen)
+		if len(found) == 0 {
+			break
+		}
+		list.Children = items
+		done = append(done, found...)
+	}
+	return done
+}
+
+func optimizePass(items []*syntaxTree, frozen map[string]bool) ([]*syntaxTree, []Optimization) {
+	var out []*syntaxTree
+	var found []Optimization
+	rewrite := func(at *syntaxTree, saved int, format string, args ...any) {
+		found = append(found, Optimization{
+			Pos:     at.position(),
+			Message: fmt.Sprintf(format, args...),
+			Saved:   saved,
+		})
+	}
+
+	var p peephole
+	p.reset()
+	keep := false // the code under the current label is left as written
+	for i := 0; i < len(items); i++ {
+		node := items[i]
+		switch {
+		case node.Symbol.Value == "identifier":
+			p.reset()
+			keep = frozen[node.Data]
+			out = append(out, node)
+			continue
+		case !isInstruction(node):
+			if !isBookkeeping(node) {
+				p.reset()
+			}
+			out = append(out, node)
+			continue
+		case keep:
+			out = append(out, node)
+			continue
+		}
+
+		var next *syntaxTree
+		if i+1 < len(items) {
+			next = items[i+1]
+		}
+		op := node.Children[0].Data
+
+		switch {
+		case op == "PSH" && next != nil && isOp(next, "POP") &&
+			isGeneral(node.Children[1].Data) && isGeneral(next.Children[1].Data):
+			ra, rb := node.Children[1].Data, next.Children[1].Data
+			i++
+			if ra == rb {
+				rewrite(node, 2, "PSH %s then POP %s removed", ra, rb)
+				continue
+			}
+			mov := moveNode(node, rb, ra)
+			rewrite(node, 1, "PSH %s then POP %s is MOV %s %s", ra, rb, rb, ra)
+			p.step(mov)
+			out = append(out, mov)
+			continue
+
+		case op == "LDI" && isGeneral(node.Children[1].Data) && p.regs[node.Children[1].Data] == exprKey(node.Children[2]):
+			rewrite(node, 2, "%s already holds %s", node.Children[1].Data, exprKey(node.Children[2]))
+			continue
+
+		case isSelfCompare(node) && p.flagEQ:
+			rewrite(node, 1, "the flag already holds EQ, CMP removed")
+			continue
+
+		case op == "JMP":
+			label, ok := jumpTarget(node)
+			if !ok || !nextIs(items, i, label) {
+				break
+			}
+			// a CMP only there for this jump goes too
+			if n := len(out); n > 0 && isSelfCompare(out[n-1]) && flagDead(items, label) {
+				out = out[:n-1]
+				rewrite(node, 3, "jump to the next instruction removed with its CMP")
+			} else {
+				rewrite(node, 2, "jump to the next instruction removed")
+			}
+			continue
+
+		case isSelfCompare(node) && p.flagSet && next != nil && isOp(next, "JMP") && next.Children[1].Data[1] == '1':
+			label, ok := jumpTarget(next)
+			if !ok || !flagDead(items, label) {
+				break
+			}
+			// exactly one flag bit is set, a full mask always jumps
+			next.Children[1].Data = "111"
+			next.Children[1].Token.val = "111"
+			rewrite(node, 1, "the flag is already set, JMP 111 needs no CMP")
+			continue
+		}
+
+		p.step(node)
+		out = append(out, node)
+	}
+	return out, found
+}
+
+// moveNode is `MOV ra rb` at the place of at
+func moveNode(at *syntaxTree, ra string, rb string) *syntaxTree {
+	mov := newSyntaxTree(grammarItem{Value: "xInstruction", Type: NonTerminal}, "xInstruction")
+	mov.Parent = at.Parent
+	tok := at.Children[0].Token
+	parts := []struct {
+		sym, val string
+		typ      ttype
+	}{
+		{"CommandX", "MOV", CommandX},
+		{"register", ra, Register},
+		{"register", rb, Register},
+	}
+	for _, part := range parts {
+		c := mov.addChild(grammarItem{Value: part.sym, Type: Terminal}, part.val)
+		c.Token = tok
+		c.Token.val, c.Token.typ = part.val, part.typ
+	}
+	return mov
+}
@@ -0,0 +1,139 @@
+package assembler
+
+import (
+	"tcp-vm/shared/vm"
+	"testing"
+)
+
+// run assembles src and runs it, the program leaves its result in R0
+func run(t *testing.T, src string, opts Options) (*Program, vm.Register) {
+	t.Helper()
+	prog, err := AssembleString("opt.asm", src, opts)
+	if err != nil {
+		t.Fatalf("AssembleString() failed: %s", Explain(err))
+	}
+	machine := new(vm.VirtualMachine)
+	machine.ResetFromStateless(prog.Data, prog.Text, prog.Entry)
+	if err := machine.RunUntilStop(); err != nil {
+		t.Fatalf("RunUntilStop() failed: %v", err)
+	}
+	return prog, machine.R0
+}
+
+func Test_optimize(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   string
+		saved int
+	}{
+		{"push pop", `.text
+main:
+	LDI R0, 0x07
+	PSH R0
+	POP R0
+	PSH R0
+	POP R1
+	ADD R0 R1
+	PSH R0
+	LDI R0, 0x00
+	SYS R0
+`, 3},
+		{"repeated load", `.equ N 3
+.text
+main:
+	LDI R1, N
+	LDI R0, N + 1
+	ADD R1 R0
+	LDI R0, N + 1
+	ADD R1 R0
+	MOV R0 R1
+	LDI R1, N
+	ADD R0 R1
+	PSH R0
+	LDI R0, 0x00
+	SYS R0
+`, 2},
+		{"jump to next", `.text
+main:
+	LDI R0, 0x05
+	JMPA next
+next:
+	LDI R1, 0x01
+	CMP R0 R1
+	JMP 001, done
+done:
+	PSH R0
+	LDI R0, 0x00
+	SYS R0
+`, 5},
+		{"known flag", `.text
+main:
+	LDI R0, 0x02
+	LDI R1, 0x01
+	CMP R0 R1
+	JMP 100, fail
+	JMPA ok
+fail:
+	EXIT 0xFF
+ok:
+	CMP R0 R0
+	CMP R0 R0
+	JMP 010, out
+	EXIT 0xEE
+out:
+	CMP R0 R1
+	JMP 001, win
+	EXIT 0xDD
+win:
+	EXIT 0x2A
+`, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, want := run(t, tt.src, Options{})
+			prog, got := run(t, tt.src, Options{Optimize: true})
+			if got != want {
+				t.Fatalf("optimized program exits with %d, want %d\n%v", got, want, prog.Optimizations)
+			}
+			if prog.Saved() != tt.saved {
+				t.Fatalf("saved %d bytes, want %d\n%v", prog.Saved(), tt.saved, prog.Optimizations)
+			}
+		})
+	}
+}
+
+func Test_optimizeKeeps(t *testing.T) {
+	// the flag is read at the target, a label splits the pair and the LDI
+	// under a label used with an offset is patched
+	src := `.text
+main:
+	LDI R0, 0x03
+	LDI R1, 0x02
+	CMP R0 R1
+	JMPA check
+	EXIT 0xFE
+check:
+	JMP 010, good
+	EXIT 0xFF
+good:
+	PSH R0
+back:
+	POP R0
+	STA R1, patched + 1
+patched:
+	LDI R1, 0x00
+	LDI R1, 0x00
+	ADD R0 R1
+	PSH R0
+	LDI R0, 0x00
+	SYS R0
+`
+	_, want := run(t, src, Options{})
+	prog, got := run(t, src, Options{Optimize: true})
+	if got != want || got != 3 {
+		t.Fatalf("optimized program exits with %d, want %d", got, want)
+	}
+	if len(prog.Optimizations) != 0 {
+		t.Fatalf("unexpected rewrites %v", prog.Optimizations)
+	}
+}
@@ -13,6 +13,7 @@ type syntaxTree struct {
 	Children []*syntaxTree
 	Data     string
 	Symbol   grammarItem
+	Token    token // set on terminals, where they were lexed from
 }
 
 var marker = grammarItem{
@@ -53,6 +54,33 @@ func (st *syntaxTree) prettyPrint() {
 	helper(st, 0)
 }
 
+// the first token under this node
+func (st *syntaxTree) first() (token, bool) {
+	if st.Symbol.Type == Terminal {
+		return st.Token, true
+	}
+	for _, child := range st.Children {
+		if t, ok := child.first(); ok {
+			return t, true
+		}
+	}
+	return token{}, false
+}
+
+// position of the first token under this node, for error messages
+func (st *syntaxTree) pos() string {
+	if t, ok := st.first(); ok {
+		return t.pos()
+	}
+	return ""
+}
+
+// position of the first token under this node, for the source map
+func (st *syntaxTree) position() Position {
+	t, _ := st.first()
+	return t.position()
+}
+
 func (st *syntaxTree) addChild(sym grammarItem, data string) *syntaxTree {
 	child := newSyntaxTree(sym, data)
 	child.Parent = st
@@ -84,8 +112,14 @@ func (st *syntaxTree) applySDT() *syntaxTree {
 		return nil
 	}
 
+	// an expression without operators or a call is just its atom, a data
+	// label without a value is just the label
+	if isOptionalSymbol(st.Symbol.Value) && len(st.Children) == 0 {
+		return nil
+	}
+
 	// flatten recursive list nodes
-	if st.Symbol.Value == "textList" || st.Symbol.Value == "dataList" {
+	if isListSymbol(st.Symbol.Value) {
 		var flat []*syntaxTree
 		for _, child := range st.Children {
 			if child.Symbol.Value == st.Symbol.Value {
@@ -112,105 +146,209 @@ func (st *syntaxTree) applySDT() *syntaxTree {
 	return st
 }
 
-func (st *syntaxTree) compile() ([g.DataSectionLength]byte, [g.TextSectionLength]byte, error) {
-	dataLabels := map[string]uint8{}
+func (st *syntaxTree) compile() (*Program, error) {
+	return st.compileWith(newSymbolTable())
+}
+
+// compileWith compiles using syms, when syms.object is set the object is
+// filled in as well and the program does not need a main label
+func (st *syntaxTree) compileWith(syms *symbolTable) (*Program, error) {
+	prog := &Program{
+		SourceMap: map[uint8]Position{},
+	}
 	textLabels := map[string]uint8{}
 	var dataSection []uint8
 	var textSection []uint8
 
-	// Data pass: collect dataItem under dataList
+	// .equ before the first section
+	var dataItems, instrs []*syntaxTree
 	for _, sec := range st.Children {
-		if sec.Symbol.Value != "data" {
-			continue
-		}
-		// find dataList under data
-		var items []*syntaxTree
-		for _, c := range sec.Children {
-			if c.Symbol.Value == "dataList" {
-				items = c.Children
-				break
+		switch sec.Symbol.Value {
+		case "defs":
+			for _, def := range sec.Children {
+				if err := syms.declare(def); err != nil {
+					return nil, err
+				}
 			}
+		case "equ", "linkage":
+			if err := syms.declare(sec); err != nil {
+				return nil, err
+			}
+		case "data":
+			dataItems = sectionItems(sec, "dataList")
+		case "text":
+			instrs = sectionItems(sec, "textList")
 		}
-		for _, item := range items {
-			if item.Symbol.Value != "dataItem" {
-				continue
+	}
+
+	// Data pass: lay out the dataItems, their values are worked out once every
+	// label is known
+	var values []*syntaxTree
+	var valueRows []int // listing row of each value
+	dataSize := 0
+	block := ""
+	for _, item := range dataItems {
+		addr := uint8(dataSize) + vm.DataStart
+		switch item.Symbol.Value {
+		case "equ", "linkage":
+			if err := syms.declare(item); err != nil {
+				return nil, err
 			}
-			if len(dataSection) >= g.DataSectionLength {
-				return ErrorData, ErrorText, fmt.Errorf("data section overflow: exceeds %d words", g.DataSectionLength)
+		case "identifier":
+			// a label for the directives that follow
+			if err := syms.label(item.Data, addr, "data"); err != nil {
+				return nil, errorAt(item, err)
 			}
+			prog.listRow(item, addr)
+			syms.blocks[item.Data] = dataBlock{}
+			block = item.Data
+		case "dataItem":
 			label := item.Children[0].Data
-			if prev, dup := dataLabels[label]; dup {
-				return ErrorData, ErrorText, fmt.Errorf("duplicate data label '%s' at address %d", label, prev)
+			if err := syms.label(label, addr, "data"); err != nil {
+				return nil, errorAt(item, err)
 			}
-			dataLabels[label] = uint8(len(dataSection)) + vm.DataStart
-
-			// identifier at [0], immediate at [1]
-			lit := item.Children[1].Data
-			val, err := parseImmediate(lit)
+			syms.blocks[label] = dataBlock{size: 1, count: 1}
+			block = ""
+			prog.SourceMap[addr] = item.position()
+
+			// identifier at [0], expr at [1]
+			values = append(values, item.Children[1])
+			valueRows = append(valueRows, prog.listRow(item, addr))
+			dataSize++
+		case "directive":
+			size, count, err := syms.layout(item)
 			if err != nil {
-				return ErrorData, ErrorText, err
+				return nil, errorAt(item, err)
 			}
-			dataSection = append(dataSection, val)
-		}
-	}
-
-	// Text pass: collect instrs under the single textList
-	var instrs []*syntaxTree
-	for _, sec := range st.Children {
-		if sec.Symbol.Value != "text" {
-			continue
-		}
-		for _, c := range sec.Children {
-			if c.Symbol.Value == "textList" {
-				instrs = c.Children
-				break
+			if block != "" {
+				b := syms.blocks[block]
+				syms.blocks[block] = dataBlock{size: b.size + size, count: b.count + count}
 			}
+			if size > 0 {
+				prog.SourceMap[addr] = item.position()
+			}
+			values = append(values, item)
+			valueRows = append(valueRows, prog.listRow(item, addr))
+			dataSize += size
+		}
+		if dataSize > g.DataSectionLength {
+			return nil, errorfAt(item, "data section overflow: exceeds %d words", g.DataSectionLength)
 		}
 	}
-	// assign label addresses
+
+	// assign label addresses, a text label followed only by directives is a
+	// table that sizeof and len work on
 	var addr uint8
+	var entry *syntaxTree
+	block = ""
 	for _, node := range instrs {
 		switch node.Symbol.Value {
+		case "equ", "linkage":
+			if err := syms.declare(node); err != nil {
+				return nil, err
+			}
+		case "entry":
+			if entry != nil {
+				return nil, errorfAt(node, "duplicate .entry, first given at %s", entry.pos())
+			}
+			entry = node
 		case "identifier":
 			lbl := node.Data
-			if _, dup := textLabels[lbl]; dup {
-				return ErrorData, ErrorText, fmt.Errorf("duplicate text label '%s'", lbl)
+			if err := syms.label(lbl, addr+vm.TextStart, "text"); err != nil {
+				return nil, errorAt(node, err)
 			}
 			textLabels[lbl] = addr + vm.TextStart
+			syms.blocks[lbl] = dataBlock{}
+			block = lbl
 		case "xInstruction", "yInstruction":
 			if addr >= g.TextSectionLength {
-				return ErrorData, ErrorText, fmt.Errorf("text section overflow: exceeds %d words", g.TextSectionLength)
+				return nil, errorfAt(node, "text section overflow: exceeds %d words", g.TextSectionLength)
 			}
 			addr++
+			delete(syms.blocks, block)
 		case "zInstruction":
 			if addr+1 >= g.TextSectionLength {
-				return ErrorData, ErrorText, fmt.Errorf("text section overflow: exceeds %d words", g.TextSectionLength)
+				return nil, errorfAt(node, "text section overflow: exceeds %d words", g.TextSectionLength)
 			}
 			addr += 2
+			delete(syms.blocks, block)
+		case "directive":
+			size, count, err := syms.layout(node)
+			if err != nil {
+				return nil, errorAt(node, err)
+			}
+			if int(addr)+size > g.TextSectionLength {
+				return nil, errorfAt(node, "text section overflow: exceeds %d words", g.TextSectionLength)
+			}
+			addr += uint8(size)
+			if b, ok := syms.blocks[block]; ok {
+				syms.blocks[block] = dataBlock{size: b.size + size, count: b.count + count}
+			}
 		}
 	}
-	// this is technically not needed, I will enforce it for good code practice
-	if _, ok := textLabels["main"]; !ok {
-		return ErrorData, ErrorText, fmt.Errorf("missing 'main' label in text section")
+	if err := syms.checkLinkage(); err != nil {
+		return nil, err
 	}
 
-	// merge dataLabels and textLabels
-	allLabels := make(map[string]uint8, len(textLabels) + len(dataLabels))
-	for name, addr := range textLabels {
-		allLabels[name] = addr
+	// execution starts at main unless .entry names another label, an object
+	// without either is a library
+	if entry != nil {
+		name := entry.Children[1].Data
+		start, ok := textLabels[name]
+		if !ok {
+			return nil, errorfAt(entry, ".entry label '%s' is not a text label", name)
+		}
+		prog.Entry = start
+		if syms.object != nil {
+			syms.object.Entry = name
+		}
+	} else if start, ok := textLabels["main"]; ok {
+		prog.Entry = start
+		if syms.object != nil {
+			syms.object.Entry = "main"
+		}
+	} else if syms.object == nil {
+		return nil, fmt.Errorf("missing 'main' label in text section")
 	}
-	for name, addr := range dataLabels {
-		allLabels[name] = addr
+
+	for i, value := range values {
+		if value.Symbol.Value == "directive" {
+			words, err := syms.encode(value)
+			if err != nil {
+				return nil, errorAt(value, err)
+			}
+			if err := syms.relocateDirective(DataSection, len(dataSection), value); err != nil {
+				return nil, errorAt(value, err)
+			}
+			dataSection = append(dataSection, words...)
+			prog.listing[valueRows[i]].words = words
+			continue
+		}
+		v, err := syms.byteValue(value)
+		if err != nil {
+			return nil, errorAt(value, err)
+		}
+		if err := syms.relocate(DataSection, len(dataSection), value); err != nil {
+			return nil, errorAt(value, err)
+		}
+		dataSection = append(dataSection, v)
+		prog.listing[valueRows[i]].words = []byte{v}
 	}
 
 	// Emit code over instrs
 	for _, node := range instrs {
+		start := len(textSection)
+		switch node.Symbol.Value {
+		case "xInstruction", "yInstruction", "zInstruction":
+			prog.SourceMap[uint8(start)+vm.TextStart] = node.position()
+		}
+
 		switch node.Symbol.Value {
 		case "xInstruction":
 			op := node.Children[0].Data
 			b, err := compileX(op, node.Children[1:])
 			if err != nil {
-				return ErrorData, ErrorText, err
+				return nil, errorAt(node, err)
 			}
 			textSection = append(textSection, b)
 
@@ -218,7 +356,7 @@ func (st *syntaxTree) compile() ([g.DataSectionLength]byte, [g.TextSectionLength
 			op := node.Children[0].Data
 			b, err := compileY(op, node.Children[1:])
 			if err != nil {
-				return ErrorData, ErrorText, err
+				return nil, errorAt(node, err)
 			}
 			textSection = append(textSection, b)
 
@@ -226,55 +364,111 @@ func (st *syntaxTree) compile() ([g.DataSectionLength]byte, [g.TextSectionLength
 			op := node.Children[0].Data
 			args := node.Children[1:]
 			if op == "JMP" {
-				b, imm, err := compileZJ(op, args, textLabels)
+				b, imm, err := compileZJ(op, args, syms)
 				if err != nil {
-					return ErrorData, ErrorText, err
+					return nil, errorAt(node, err)
 				}
 				textSection = append(textSection, b, imm)
 			} else {
-				// b, imm, err := compileZ(op, args, dataLabels)
-				b, imm, err := compileZ(op, args, allLabels)
+				b, imm, err := compileZ(op, args, syms)
 				if err != nil {
-					return ErrorData, ErrorText, err
+					return nil, errorAt(node, err)
 				}
 				textSection = append(textSection, b, imm)
 			}
+			if err := syms.relocate(TextSection, start+1, node.Children[2]); err != nil {
+				return nil, errorAt(node, err)
+			}
+
+		case "directive":
+			words, err := syms.encode(node)
+			if err != nil {
+				return nil, errorAt(node, err)
+			}
+			if len(words) > 0 {
+				prog.SourceMap[uint8(len(textSection))+vm.TextStart] = node.position()
+			}
+			if err := syms.relocateDirective(TextSection, start, node); err != nil {
+				return nil, errorAt(node, err)
+			}
+			textSection = append(textSection, words...)
+
+		case "identifier":
+			// labels are listed at the address of what follows them
+
+		default:
+			continue
 		}
+		row := prog.listRow(node, uint8(start)+vm.TextStart)
+		prog.listing[row].words = textSection[start:]
 	}
 
-	dataOut := [g.DataSectionLength]byte{}
-	for i, v := range dataSection {
-		dataOut[i] = byte(v)
-	}
-	textOut := [g.TextSectionLength]byte{}
-	for i, v := range textSection {
-		textOut[i] = byte(v)
-	}
+	copy(prog.Data[:], dataSection)
+	copy(prog.Text[:], textSection)
+	prog.Symbols = syms.labels
+	prog.Warnings = append(prog.Warnings, clobberWarnings(instrs)...)
 
-	fmt.Println("data labels:")
-	for k, v := range dataLabels {
-		fmt.Printf("%v, %v\n", k, v)
+	// constants nothing used are only shown in listings, a broken one is left
+	// out rather than failing the program
+	prog.Constants = map[string]int{}
+	for name := range syms.equs {
+		if v, err := syms.lookup(name); err == nil {
+			prog.Constants[name] = v
+		}
 	}
-	fmt.Println("text labels:")
-	for k, v := range textLabels {
-		fmt.Printf("%v, %v\n", k, v)
+	if syms.object != nil {
+		syms.fillObject(prog, len(dataSection), len(textSection))
 	}
 
-	return dataOut, textOut, nil
+	return prog, nil
 }
 
+// immediates are 8 bits: decimal (-128 to 255, negatives are stored as two's
+// complement), hex (0x), binary (0b) or a character literal ('A', '\n')
 func parseImmediate(lit string) (uint8, error) {
-	var v uint64
-	var err error
-	if strings.HasPrefix(lit, "0x") {
-		v, err = strconv.ParseUint(lit[2:], 16, 8)
-	}
+	v, err := parseLiteral(lit)
 	if err != nil {
-		return 0, fmt.Errorf("invalid immediate '%s': %v", lit, err)
+		return 0, err
+	}
+	if v < -128 || v > 255 {
+		return 0, fmt.Errorf("immediate '%s' does not fit in 8 bits (-128 to 255)", lit)
 	}
 	return uint8(v), nil
 }
 
+func parseChar(lit string) (byte, error) {
+	if len(lit) < 3 || lit[0] != '\'' || lit[len(lit)-1] != '\'' {
+		return 0, fmt.Errorf("malformed character literal")
+	}
+	body := lit[1 : len(lit)-1]
+	if len(body) == 1 && body != "\\" {
+		return body[0], nil
+	}
+	if len(body) == 2 && body[0] == '\\' {
+		if c, ok := unescape(body[1]); ok {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported character literal")
+}
+
+// the character a backslash escape stands for
+func unescape(c byte) (byte, bool) {
+	switch c {
+	case 'n':
+		return '\n', true
+	case 't':
+		return '\t', true
+	case 'r':
+		return '\r', true
+	case '0':
+		return 0, true
+	case '\\', '\'', '"':
+		return c, true
+	}
+	return 0, false
+}
+
 func parseRegister(reg string) (byte, error) {
 	switch reg {
 	case "R0":
@@ -376,7 +570,7 @@ func compileY(op string, args []*syntaxTree) (byte, error) {
 	return inst, nil
 }
 
-func compileZ(op string, args []*syntaxTree, labels map[string]uint8) (byte, byte, error) {
+func compileZ(op string, args []*syntaxTree, syms *symbolTable) (byte, byte, error) {
 	// o4 o3 o2 o1 0 0 ra1 ra0, immediate
 
 	// prevent future errors if changes to the parser are made
@@ -406,22 +600,15 @@ func compileZ(op string, args []*syntaxTree, labels map[string]uint8) (byte, byt
 		return 0, 0, err
 	}
 	inst |= ra << 2
-	// immediate or label
-	valStr := args[1].Data
-	var imm byte
-	if addr, ok := labels[valStr]; ok {
-		imm = addr
-	} else {
-		tmp, err := parseImmediate(valStr)
-		if err != nil {
-			return 0, 0, err
-		}
-		imm = tmp
+	// constant expression, labels included
+	imm, err := syms.byteValue(args[1])
+	if err != nil {
+		return 0, 0, err
 	}
 	return inst, imm, nil
 }
 
-func compileZJ(op string, args []*syntaxTree, labels map[string]uint8) (byte, byte, error) {
+func compileZJ(op string, args []*syntaxTree, syms *symbolTable) (byte, byte, error) {
 	// o4 o3 o2 o1 0 m2 m1 m0, immediate
 
 	// prevent future errors if changes to the parser are made
@@ -446,11 +633,10 @@ func compileZJ(op string, args []*syntaxTree, labels map[string]uint8) (byte, by
 		return 0, 0, fmt.Errorf("invalid mask '%s': %v", maskStr, err)
 	}
 	inst |= byte(m)
-	// label
-	lbl := args[1].Data
-	addr, ok := labels[lbl]
-	if !ok {
-		return 0, 0, fmt.Errorf("undefined jump label '%s'", lbl)
+	// jump target, usually a label
+	addr, err := syms.byteValue(args[1])
+	if err != nil {
+		return 0, 0, err
 	}
 	return inst, addr, nil
 }
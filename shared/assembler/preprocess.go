@@ -0,0 +1,435 @@
+package assembler
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// nested macro invocations deeper than this are assumed to be recursive
+const maxExpansionDepth = 32
+
+// an expansion records where a token was pasted in from, so errors inside a
+// macro body can name both the definition and the call site
+type expansion struct {
+	macro string
+	def   token
+	call  token // its own exp covers nested expansions
+
+	// pseudo-instructions have no definition, they list what they overwrite
+	pseudo   bool
+	clobbers []string
+}
+
+func (e *expansion) String() string {
+	if e.pseudo {
+		if len(e.clobbers) == 0 {
+			return fmt.Sprintf("in pseudo-instruction %s", e.macro)
+		}
+		return fmt.Sprintf("in pseudo-instruction %s, clobbers %s", e.macro, strings.Join(e.clobbers, ", "))
+	}
+	return fmt.Sprintf(
+		"in macro '%s' defined at %s, expanded at %s",
+		e.macro,
+		e.def.pos(),
+		e.call.pos(),
+	)
+}
+
+// outermostCall is the call in the source that t was expanded from, or t
+// itself when it was written there
+func outermostCall(t token) token {
+	for t.exp != nil {
+		t = t.exp.call
+	}
+	return t
+}
+
+type macro struct {
+	name   string
+	def    token // the .macro token
+	params []string
+	body   [][]token
+}
+
+type preprocessor struct {
+	macros       map[string]*macro
+	expansions   int
+	includePaths []string
+	files        []string            // the chain of files being included, outermost first
+	sources      map[string][]string // text of every file read, for listings
+
+	// for conditional assembly: -D defines, and every constant seen so far
+	// that .if can work out, see conditional.go
+	defines map[string]int
+	values  map[string]int
+	equs    map[string]bool
+	table   *llParseTable
+}
+
+func newPreprocessor(opts Options) *preprocessor {
+	pp := &preprocessor{
+		macros:       make(map[string]*macro),
+		includePaths: opts.IncludePaths,
+		sources:      make(map[string][]string),
+		defines:      make(map[string]int),
+		values:       make(map[string]int),
+		equs:         make(map[string]bool),
+	}
+	for name, v := range opts.Defines {
+		pp.defines[name] = v
+		pp.values[name] = v
+	}
+	return pp
+}
+
+// include lexes and processes path, from is the `.include` token asking for it
+// or nil for the file being assembled
+func (pp *preprocessor) include(path string, from *token) ([][]token, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, includeError(from, "%v", err)
+	}
+	for i, active := range pp.files {
+		if active == abs {
+			chain := append(append([]string{}, pp.files[i:]...), abs)
+			return nil, includeError(from, "include cycle: %s", strings.Join(chain, " -> "))
+		}
+	}
+
+	if from != nil && isStdlib(path) {
+		src, err := stdlibFS.ReadFile(stdlibPath(path))
+		if err != nil {
+			return nil, includeError(from, "opening file: %v", err)
+		}
+		return pp.read(bytes.NewReader(src), path, from)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, includeError(from, "opening file: %v", err)
+	}
+	defer f.Close()
+
+	return pp.read(f, path, from)
+}
+
+// includeError reports a problem with a file at the `.include` asking for it,
+// from is nil for the file being assembled
+func includeError(from *token, format string, args ...any) error {
+	if from == nil {
+		return fmt.Errorf(format, args...)
+	}
+	return errorfToken(*from, format, args...)
+}
+
+// read lexes and processes source named name, an unnamed source cannot be
+// part of an include cycle
+func (pp *preprocessor) read(r io.Reader, name string, from *token) ([][]token, error) {
+	file := ""
+	if name != "" {
+		file = filepath.Clean(name)
+	}
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return nil, includeError(from, "reading file: %v", err)
+	}
+	pp.sources[file] = strings.Split(string(src), "\n")
+	lines, err := lexLines(bytes.NewReader(src), file)
+	if err != nil {
+		return nil, includeError(from, "%v", err)
+	}
+
+	if name != "" {
+		abs, err := filepath.Abs(name)
+		if err != nil {
+			return nil, includeError(from, "%v", err)
+		}
+		pp.files = append(pp.files, abs)
+		defer func() { pp.files = pp.files[:len(pp.files)-1] }()
+	}
+
+	return pp.process(lines, 0)
+}
+
+// resolve finds an included file next to the file including it, then in the
+// include paths in order
+func (pp *preprocessor) resolve(line []token) (string, error) {
+	if len(line) != 2 || line[1].typ != String {
+		return "", errorfToken(line[0], "expected .include \"file.asm\"")
+	}
+	name, err := strconv.Unquote(line[1].val)
+	if err != nil {
+		return "", errorfToken(line[1], "invalid file name %s", line[1].val)
+	}
+	if lib, ok, err := stdlibName(name); ok {
+		if err != nil {
+			return "", errorfToken(line[1], "%v", err)
+		}
+		return lib, nil
+	}
+	if filepath.IsAbs(name) {
+		return name, nil
+	}
+
+	dirs := append([]string{filepath.Dir(line[0].file)}, pp.includePaths...)
+	for _, dir := range dirs {
+		candidate := filepath.Join(dir, name)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	return "", errorfToken(line[1], "cannot find '%s' in %s", name, strings.Join(dirs, ", "))
+}
+
+// IncludeFlag collects every `-I` flag in order for Options.IncludePaths. Use
+// it with flag.Var.
+type IncludeFlag []string
+
+func (p *IncludeFlag) String() string {
+	return strings.Join(*p, ", ")
+}
+
+func (p *IncludeFlag) Set(dir string) error {
+	*p = append(*p, dir)
+	return nil
+}
+
+func isDirective(line []token, name string) bool {
+	return len(line) > 0 && line[0].typ == Section && line[0].val == name
+}
+
+func isLabel(line []token) bool {
+	return len(line) >= 2 && line[0].typ == Identifier && line[1].typ == Colon
+}
+
+// splitArgs splits tokens on top level commas, every part must be non empty
+func splitArgs(toks []token, what token) ([][]token, error) {
+	if len(toks) == 0 {
+		return nil, nil
+	}
+
+	var out [][]token
+	var cur []token
+	for _, t := range toks {
+		if t.typ == Comma {
+			if len(cur) == 0 {
+				return nil, errorfToken(t, "empty argument to '%s'", what.val)
+			}
+			out = append(out, cur)
+			cur = nil
+			continue
+		}
+		cur = append(cur, t)
+	}
+	if len(cur) == 0 {
+		return nil, errorfToken(what, "trailing ',' after '%s' arguments", what.val)
+	}
+	return append(out, cur), nil
+}
+
+// process runs the directives that are handled before parsing and returns
+// plain lines for the grammar
+func (pp *preprocessor) process(lines [][]token, depth int) ([][]token, error) {
+	var out [][]token
+	var conds []conditional
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		if isConditional(line) {
+			var err error
+			if conds, err = pp.conditional(conds, line); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if !assembling(conds) {
+			continue
+		}
+
+		// a label in front of anything else gets its own line so the rest can
+		// be looked at on its own
+		if isLabel(line) && len(line) > 2 {
+			out = append(out, line[:2])
+			line = line[2:]
+		}
+
+		switch {
+		case isDirective(line, ".macro"):
+			m, end, err := pp.define(lines, i)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := pseudos[m.name]; ok {
+				return nil, errorfToken(m.def, "macro '%s' has the name of a pseudo-instruction", m.name)
+			}
+			if prev, dup := pp.macros[m.name]; dup {
+				return nil, errorfToken(m.def, "duplicate macro '%s', first defined at %s", m.name, prev.def.pos())
+			}
+			pp.macros[m.name] = m
+			i = end
+
+		case isDirective(line, ".endm"):
+			return nil, errorfToken(line[0], ".endm without .macro")
+
+		case isDirective(line, ".include"):
+			path, err := pp.resolve(line)
+			if err != nil {
+				return nil, err
+			}
+			included, err := pp.include(path, &line[0])
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, included...)
+
+		case line[0].typ == Identifier && pp.macros[line[0].val] != nil && !isLabel(line):
+			if depth >= maxExpansionDepth {
+				// the chain of calls would repeat the same few lines
+				// maxExpansionDepth times, the outermost call is enough
+				return nil, errorfToken(
+					outermostCall(line[0]),
+					"macro '%s' nested more than %d deep, is it recursive?",
+					line[0].val,
+					maxExpansionDepth,
+				)
+			}
+			body, err := pp.expand(pp.macros[line[0].val], line)
+			if err != nil {
+				return nil, err
+			}
+			expanded, err := pp.process(body, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, expanded...)
+
+		case isPseudo(line):
+			expanded, err := expandPseudo(line)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, expanded...)
+
+		case isDirective(line, ".equ"):
+			pp.record(line)
+			out = append(out, line)
+
+		default:
+			out = append(out, line)
+		}
+	}
+
+	if len(conds) > 0 {
+		open := conds[len(conds)-1].at
+		return nil, errorfToken(open, "%s without .endif", open.val)
+	}
+	return out, nil
+}
+
+// define reads `.macro name a, b` through the matching `.endm` starting at
+// lines[start] and returns the index of the .endm line
+func (pp *preprocessor) define(lines [][]token, start int) (*macro, int, error) {
+	header := lines[start]
+	def := header[0]
+	if len(header) < 2 || header[1].typ != Identifier {
+		return nil, 0, errorfToken(def, ".macro needs a name")
+	}
+
+	m := &macro{name: header[1].val, def: def}
+	params, err := splitArgs(header[2:], header[1])
+	if err != nil {
+		return nil, 0, err
+	}
+	seen := map[string]bool{}
+	for _, p := range params {
+		if len(p) != 1 || p[0].typ != Identifier {
+			return nil, 0, errorfToken(p[0], "macro parameters must be identifiers")
+		}
+		if seen[p[0].val] {
+			return nil, 0, errorfToken(p[0], "duplicate macro parameter '%s'", p[0].val)
+		}
+		seen[p[0].val] = true
+		m.params = append(m.params, p[0].val)
+	}
+
+	for i := start + 1; i < len(lines); i++ {
+		switch {
+		case isDirective(lines[i], ".endm"):
+			if len(lines[i]) > 1 {
+				return nil, 0, errorfToken(lines[i][1], "unexpected '%s' after .endm", lines[i][1].val)
+			}
+			return m, i, nil
+		case isDirective(lines[i], ".macro"):
+			return nil, 0, errorfToken(lines[i][0], ".macro inside the body of macro '%s', definitions cannot be nested", m.name)
+		}
+		m.body = append(m.body, lines[i])
+	}
+
+	return nil, 0, errorfToken(def, "macro '%s' is missing .endm", m.name)
+}
+
+// expand pastes a copy of the macro body for the invocation in call.
+// Arguments replace parameters and labels defined in the body are renamed so
+// every expansion gets its own.
+func (pp *preprocessor) expand(m *macro, call []token) ([][]token, error) {
+	args, err := splitArgs(call[1:], call[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != len(m.params) {
+		return nil, errorfToken(
+			call[0],
+			"macro '%s' takes %d arguments, got %d (defined at %s)",
+			m.name,
+			len(m.params),
+			len(args),
+			m.def.pos(),
+		)
+	}
+
+	params := map[string][]token{}
+	for i, p := range m.params {
+		params[p] = args[i]
+	}
+
+	pp.expansions++
+	locals := map[string]string{}
+	for _, line := range m.body {
+		if isLabel(line) {
+			// `@` cannot be typed in an identifier so these never clash
+			locals[line[0].val] = fmt.Sprintf("%s@%s.%d", line[0].val, m.name, pp.expansions)
+		}
+	}
+
+	exp := &expansion{
+		macro: m.name,
+		def:   m.def,
+		call:  call[0],
+	}
+
+	var out [][]token
+	for _, line := range m.body {
+		var copied []token
+		for _, t := range line {
+			if t.typ == Identifier {
+				if arg, ok := params[t.val]; ok {
+					copied = append(copied, arg...)
+					continue
+				}
+				if local, ok := locals[t.val]; ok {
+					t.val = local
+				}
+			}
+			t.exp = exp
+			copied = append(copied, t)
+		}
+		out = append(out, copied)
+	}
+	return out, nil
+}
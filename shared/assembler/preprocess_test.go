@@ -0,0 +1,229 @@
+package assembler
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeSource(t *testing.T, src string) string {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "*.asm")
+	if err != nil {
+		t.Fatalf("CreateTemp() failed: %v", err)
+	}
+	if _, err := f.WriteString(src); err != nil {
+		t.Fatalf("WriteString() failed: %v", err)
+	}
+	f.Close()
+	return f.Name()
+}
+
+func Test_macroExpansion(t *testing.T) {
+	path := writeSource(t, `
+.macro push_imm reg, value
+	LDI reg, value
+	PSH reg
+.endm
+
+.macro exit code
+	push_imm R0, code
+	LDI R0, 0x00
+	SYS R0
+.endm
+
+.macro spin count
+	LDI R1, count
+loop:
+	CMP R1 R1
+	JMP 100, loop
+.endm
+
+.text
+main:
+	spin 0x01
+	spin 0x02
+	exit 0x07
+`)
+
+	data, text, err := Assemble(path)
+	if err != nil {
+		t.Fatalf("Assemble() failed: %v", err)
+	}
+	_ = data
+
+	want := []byte{
+		0xD4, 0x01, 0x15, 0xC4, 0x53, // spin 0x01
+		0xD4, 0x02, 0x15, 0xC4, 0x58, // spin 0x02, its own loop label
+		0xD0, 0x07, 0x90, 0xD0, 0x00, 0xB0, // exit 0x07
+	}
+	for i, b := range want {
+		if text[i] != b {
+			t.Fatalf("text[%d] = 0x%02X, want 0x%02X (text: % X)", i, text[i], b, text[:len(want)])
+		}
+	}
+}
+
+func Test_macroErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{
+			src:  ".macro bad a\n\tLDI R0, 300\n.endm\n.text\nmain:\n\tbad R1\n",
+			want: []string{"%s:2:", "in macro 'bad' defined at %s:1:1, expanded at %s:6:2"},
+		},
+		{
+			src:  ".macro two a, b\n.endm\n.text\nmain:\n\ttwo R0\n",
+			want: []string{"%s:5:2", "takes 2 arguments, got 1"},
+		},
+		{
+			src:  ".macro again\n\tagain\n.endm\n.text\nmain:\n\tagain\n",
+			want: []string{"is it recursive?"},
+		},
+		{
+			src:  ".macro open\n.text\n",
+			want: []string{"missing .endm"},
+		},
+		{
+			src:  ".text\nmain:\n.endm\n",
+			want: []string{"%s:3:1: .endm without .macro"},
+		},
+	}
+
+	for _, tc := range tests {
+		path := writeSource(t, tc.src)
+		_, _, err := Assemble(path)
+		if err == nil {
+			t.Fatalf("expected an error assembling:\n%s", tc.src)
+		}
+		for _, w := range tc.want {
+			w = strings.ReplaceAll(w, "%s", path)
+			if !strings.Contains(err.Error(), w) {
+				t.Fatalf("error %q does not contain %q", err, w)
+			}
+		}
+	}
+}
+
+func Test_recursiveMacro(t *testing.T) {
+	src := ".macro down n\n\tdown n\n.endm\n.text\nmain:\n\tdown 0x01\n"
+	_, err := AssembleString("rec.asm", src, Options{})
+	var ds Diagnostics
+	if !errors.As(err, &ds) || len(ds) != 1 {
+		t.Fatalf("expected one diagnostic, got %v", err)
+	}
+	// only the call in the source, not the 32 expansions under it
+	want := "rec.asm:6:2: macro 'down' nested more than 32 deep, is it recursive?"
+	if got := err.Error(); got != want {
+		t.Fatalf("error = %q, want %q", got, want)
+	}
+	if ds[0].Source != "\tdown 0x01" {
+		t.Fatalf("source line = %q", ds[0].Source)
+	}
+}
+
+func Test_preprocessorDiagnostics(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{".endm\n", "pre.asm:1:1: .endm without .macro"},
+		{".if 0x\n.endif\n", "pre.asm:1:1: in .if: pre.asm:1:5: invalid immediate '0x'"},
+		{".include \"missing.asm\"\n", "pre.asm:1:10: cannot find 'missing.asm'"},
+		{".text\nmain:\n\tINC 0x01\n", "pre.asm:3:2: INC takes a register"},
+	}
+	for _, tt := range tests {
+		_, err := AssembleString("pre.asm", tt.src, Options{})
+		var ds Diagnostics
+		if !errors.As(err, &ds) {
+			t.Fatalf("%q: expected diagnostics, got %v", tt.src, err)
+		}
+		if !strings.HasPrefix(err.Error(), tt.want) {
+			t.Fatalf("%q: error = %q, want it to start with %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("MkdirAll() failed: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+	}
+	return dir
+}
+
+func Test_include(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.asm": ".include \"lib/exit.asm\"\n.text\nmain:\n\texit 0x07\n",
+		// found next to lib/exit.asm
+		"lib/exit.asm": ".include \"push.asm\"\n.macro exit code\n\tpush_imm R0, code\n\tLDI R0, 0x00\n\tSYS R0\n.endm\n",
+		"lib/push.asm": ".macro push_imm reg, value\n\tLDI reg, value\n\tPSH reg\n.endm\n",
+	})
+
+	_, text, err := Assemble(filepath.Join(dir, "main.asm"))
+	if err != nil {
+		t.Fatalf("Assemble() failed: %v", err)
+	}
+	want := []byte{0xD0, 0x07, 0x90, 0xD0, 0x00, 0xB0}
+	for i, b := range want {
+		if text[i] != b {
+			t.Fatalf("text[%d] = 0x%02X, want 0x%02X", i, text[i], b)
+		}
+	}
+}
+
+func Test_includePaths(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"src/main.asm": ".text\nmain:\n.include \"halt.asm\"\n",
+		"lib/halt.asm": "\tLDI R0, 0x00\n\tPSH R0\n\tSYS R0\n",
+	})
+	main := filepath.Join(dir, "src", "main.asm")
+
+	if _, _, err := Assemble(main); err == nil || !strings.Contains(err.Error(), "cannot find 'halt.asm'") {
+		t.Fatalf("expected a missing include error, got %v", err)
+	}
+
+	var includes IncludeFlag
+	includes.Set(filepath.Join(dir, "lib"))
+	opts := Options{IncludePaths: includes}
+	_, text, err := AssembleWith(main, opts)
+	if err != nil {
+		t.Fatalf("AssembleWith() failed: %v", err)
+	}
+	if text[0] != 0xD0 || text[2] != 0x90 || text[3] != 0xB0 {
+		t.Fatalf("unexpected text: % X", text[:4])
+	}
+}
+
+func Test_includeErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.asm":   ".include \"b.asm\"\n.text\nmain:\n",
+		"b.asm":   "\n.include \"a.asm\"\n",
+		"bad.asm": ".text\nmain:\n.include \"lib.asm\"\n",
+		"lib.asm": "\tLDI R0, 300\n",
+	})
+
+	_, _, err := Assemble(filepath.Join(dir, "a.asm"))
+	if err == nil || !strings.Contains(err.Error(), "include cycle") {
+		t.Fatalf("expected an include cycle error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), filepath.Join(dir, "b.asm")+":2:1") {
+		t.Fatalf("cycle error does not point at the .include line: %v", err)
+	}
+
+	// errors in an included file name that file
+	_, _, err = Assemble(filepath.Join(dir, "bad.asm"))
+	if err == nil || !strings.Contains(err.Error(), filepath.Join(dir, "lib.asm")+":1:") {
+		t.Fatalf("expected an error in lib.asm, got %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package assembler
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"tcp-vm/shared/debuginfo"
+	g "tcp-vm/shared/globals"
+	"tcp-vm/shared/vm"
+)
+
+// Program is an assembled program along with what is known about where each
+// part of it came from. Nothing is printed while building one, so it is safe
+// to use from servers, tests and editor tooling.
+type Program struct {
+	Data [g.DataSectionLength]byte
+	Text [g.TextSectionLength]byte
+
+	// address of every data and text label
+	Symbols map[string]uint8
+	// address execution starts at
+	Entry uint8
+	// where each data word and each instruction was written, keyed by the
+	// address of the word or of the first byte of the instruction
+	SourceMap map[uint8]Position
+	// value of every .equ constant that could be worked out
+	Constants map[string]int
+	// problems that did not stop the program from assembling
+	Warnings []Warning
+	// rewrites made by the peephole optimizer, see Options.Optimize
+	Optimizations []Optimization
+
+	listing []listed            // what was written where, see WriteListing
+	sources map[string][]string // source text by file name
+}
+
+// Position is a place in the source, File is empty for unnamed input
+type Position struct {
+	File string
+	Line int
+	Col  int
+}
+
+func (p Position) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("line %d:%d", p.Line, p.Col)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Col)
+}
+
+type Warning struct {
+	Pos     Position
+	Message string
+	// what kind of problem it is, see the Rule constants. A comment naming it
+	// silences the warning.
+	Rule string
+
+	// where in a macro body the code is, Pos is the call that expanded it
+	body Position
+}
+
+func (w Warning) String() string {
+	if w.Rule == "" {
+		return fmt.Sprintf("%v: %s", w.Pos, w.Message)
+	}
+	return fmt.Sprintf("%v: %s [%s]", w.Pos, w.Message, w.Rule)
+}
+
+// DebugInfo maps the text addresses of the program back to the source and
+// names its labels, it travels with a job so faults can be reported by line
+func (prog *Program) DebugInfo() *debuginfo.Info {
+	d := &debuginfo.Info{}
+	for addr, pos := range prog.SourceMap {
+		if addr < vm.TextStart {
+			continue
+		}
+		d.Lines = append(d.Lines, debuginfo.Line{
+			Addr: addr,
+			File: pos.File,
+			Line: pos.Line,
+			Col:  pos.Col,
+		})
+	}
+	for name, addr := range prog.Symbols {
+		sym := debuginfo.Symbol{Addr: addr, Name: name}
+		if addr < vm.TextStart {
+			d.Data = append(d.Data, sym)
+		} else {
+			d.Labels = append(d.Labels, sym)
+		}
+	}
+	d.Sort()
+	return d
+}
+
+// AssembleFile assembles the file at path together with every file it
+// includes
+func AssembleFile(path string, opts Options) (*Program, error) {
+	pp := newPreprocessor(opts)
+	lines, err := pp.include(path, nil)
+	if err != nil {
+		return nil, withSource(err, pp.sources)
+	}
+	tokens, err := pp.finish(lines)
+	if err != nil {
+		return nil, withSource(err, pp.sources)
+	}
+	prog, err := assemble(tokens, opts)
+	if err != nil {
+		return nil, withSource(err, pp.sources)
+	}
+	prog.sources = pp.sources
+	prog.Warnings = unsilenced(prog.Warnings, pp.sources)
+	return prog, nil
+}
+
+// AssembleReader assembles source read from r. name is used in positions and
+// relative includes are looked up next to it, it may be empty.
+func AssembleReader(name string, r io.Reader, opts Options) (*Program, error) {
+	pp := newPreprocessor(opts)
+	lines, err := pp.read(r, name, nil)
+	if err != nil {
+		return nil, withSource(err, pp.sources)
+	}
+	tokens, err := pp.finish(lines)
+	if err != nil {
+		return nil, withSource(err, pp.sources)
+	}
+	prog, err := assemble(tokens, opts)
+	if err != nil {
+		return nil, withSource(err, pp.sources)
+	}
+	prog.sources = pp.sources
+	prog.Warnings = unsilenced(prog.Warnings, pp.sources)
+	return prog, nil
+}
+
+// AssembleString assembles src, see AssembleReader
+func AssembleString(name string, src string, opts Options) (*Program, error) {
+	return AssembleReader(name, strings.NewReader(src), opts)
+}
+
+func Assemble(sourcePath string) ([g.DataSectionLength]byte, [g.TextSectionLength]byte, error) {
+	return AssembleWith(sourcePath, Options{})
+}
+
+// AssembleWith assembles sourcePath together with every file it includes
+func AssembleWith(sourcePath string, opts Options) ([g.DataSectionLength]byte, [g.TextSectionLength]byte, error) {
+	prog, err := AssembleFile(sourcePath, opts)
+	if err != nil {
+		return ErrorData, ErrorText, err
+	}
+	return prog.Data, prog.Text, nil
+}
+
+// Symbols assembles sourcePath and returns the address of every data and text
+// label, used to put names back into disassembled code
+func Symbols(sourcePath string) (map[string]uint8, error) {
+	prog, err := AssembleFile(sourcePath, Options{})
+	if err != nil {
+		return nil, err
+	}
+	return prog.Symbols, nil
+}
@@ -0,0 +1,192 @@
+package assembler
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tcp-vm/shared/debuginfo"
+	"tcp-vm/shared/vm"
+)
+
+func Test_assembleString(t *testing.T) {
+	src := `
+.data
+	count = 0x03
+	step = 0x01
+
+.text
+main:
+	LDA R0, count
+loop:
+	LDA R1, step
+	SUB R0 R1
+	JMP 001, loop
+`
+
+	// nothing may be printed, capture stdout while assembling
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe() failed: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	prog, err := AssembleString("count.asm", src, Options{})
+	os.Stdout = stdout
+	w.Close()
+	printed, _ := io.ReadAll(r)
+
+	if err != nil {
+		t.Fatalf("AssembleString() failed: %v", err)
+	}
+	if len(printed) != 0 {
+		t.Fatalf("assembling printed to stdout:\n%s", printed)
+	}
+
+	if prog.Data[0] != 0x03 || prog.Data[1] != 0x01 {
+		t.Fatalf("unexpected data: % X", prog.Data[:2])
+	}
+	if prog.Entry != 0x51 {
+		t.Fatalf("Entry = 0x%02X, want 0x51", prog.Entry)
+	}
+
+	wantSyms := map[string]uint8{"count": 0x00, "step": 0x01, "main": 0x51, "loop": 0x53}
+	for name, addr := range wantSyms {
+		if got, ok := prog.Symbols[name]; !ok || got != addr {
+			t.Fatalf("Symbols[%s] = 0x%02X (%v), want 0x%02X", name, got, ok, addr)
+		}
+	}
+
+	wantMap := map[uint8]Position{
+		0x00: {File: "count.asm", Line: 3, Col: 2},
+		0x51: {File: "count.asm", Line: 8, Col: 2},
+		0x53: {File: "count.asm", Line: 10, Col: 2},
+		0x55: {File: "count.asm", Line: 11, Col: 2},
+		0x56: {File: "count.asm", Line: 12, Col: 2},
+	}
+	for addr, pos := range wantMap {
+		if got := prog.SourceMap[addr]; got != pos {
+			t.Fatalf("SourceMap[0x%02X] = %v, want %v", addr, got, pos)
+		}
+	}
+	if _, ok := prog.SourceMap[0x52]; ok {
+		t.Fatalf("SourceMap has an entry for the immediate at 0x52")
+	}
+}
+
+func Test_assembleReader(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"halt.asm": "\tLDI R0, 0x00\n\tPSH R0\n\tSYS R0\n",
+	})
+
+	// relative includes are found next to the name given
+	src := ".text\nmain:\n.include \"halt.asm\"\n"
+	prog, err := AssembleReader(filepath.Join(dir, "main.asm"), strings.NewReader(src), Options{})
+	if err != nil {
+		t.Fatalf("AssembleReader() failed: %v", err)
+	}
+	if got := prog.SourceMap[0x51].File; got != filepath.Join(dir, "halt.asm") {
+		t.Fatalf("SourceMap[0x51].File = %s, want halt.asm", got)
+	}
+
+	// unnamed input reports bare lines
+	_, err = AssembleString("", ".text\nmain:\n\tLDI R0, 300\n", Options{})
+	if err == nil || !strings.Contains(err.Error(), "line 3:2") {
+		t.Fatalf("expected an error at line 3:2, got %v", err)
+	}
+}
+
+func Test_entryPoint(t *testing.T) {
+	tests := []struct {
+		src   string
+		entry uint8
+		err   string
+	}{
+		{
+			// helpers above main are skipped
+			src:   ".text\nhelper:\n\tPOP R0\nmain:\n\tLDI R0, 0x00\n",
+			entry: 0x52,
+		},
+		{
+			src:   ".text\n.entry start\nmain:\n\tPOP R0\nstart:\n\tLDI R0, 0x00\n",
+			entry: 0x52,
+		},
+		{
+			// .entry makes main optional
+			src:   ".text\nstart:\n\tLDI R0, 0x00\n\tPSH R0\n.entry start\n",
+			entry: 0x51,
+		},
+		{
+			src: ".text\n.entry nowhere\nmain:\n\tPOP R0\n",
+			err: ".entry label 'nowhere' is not a text label",
+		},
+		{
+			src: ".text\n.entry main\n.entry main\nmain:\n\tPOP R0\n",
+			err: "line 3:1: duplicate .entry, first given at line 2:1",
+		},
+		{
+			src: ".text\nstart:\n\tPOP R0\n\tPOP R1\n",
+			err: "missing 'main' label",
+		},
+	}
+
+	for _, tc := range tests {
+		prog, err := AssembleString("", tc.src, Options{})
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected an error containing %q, got %v", tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("AssembleString() failed: %v\n%s", err, tc.src)
+		}
+		if prog.Entry != tc.entry {
+			t.Fatalf("Entry = 0x%02X, want 0x%02X\n%s", prog.Entry, tc.entry, tc.src)
+		}
+	}
+}
+
+func Test_debugInfo(t *testing.T) {
+	src := `
+.data
+	count = 0x03
+.text
+main:
+	LDA R0, count
+adderloopstart:
+	POP R1
+	JMP 111, adderloopstart
+`
+	prog, err := AssembleString("adder.asm", src, Options{})
+	if err != nil {
+		t.Fatalf("AssembleString() failed: %v", err)
+	}
+
+	raw, err := prog.DebugInfo().Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	info, err := debuginfo.Parse(raw)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if name, ok := info.DataName(0x00); !ok || name != "count" {
+		t.Fatalf("DataName(0x00) = %q, %v", name, ok)
+	}
+
+	// POP R1 underflows, the fault names its line and label
+	machine := new(vm.VirtualMachine)
+	machine.ResetFromStateless(prog.Data, prog.Text, prog.Entry)
+	var f *vm.Fault
+	if err := machine.RunUntilStop(); !errors.As(err, &f) {
+		t.Fatalf("expected a fault, got %v", err)
+	}
+	want := "adder.asm:8 in adderloopstart"
+	if got := info.Describe(uint8(f.PC)); got != want {
+		t.Fatalf("Describe(0x%02X) = %q, want %q", f.PC, got, want)
+	}
+}
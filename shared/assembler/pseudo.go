@@ -0,0 +1,231 @@
+package assembler
+
+import (
+	"fmt"
+	"tcp-vm/shared/vm"
+)
+
+// pseudo-instructions are built in shorthands the preprocessor expands into
+// real instructions. Each one lists what it overwrites besides its operand so
+// diagnostics can say which registers did not survive.
+type pseudo struct {
+	operand  string   // "a label", "an immediate" or "a register"
+	clobbers []string // registers, or "flag", left changed
+	scratch  bool     // also overwrites the register scratchRegister picks
+	expand   func(arg []token) [][]token
+}
+
+var pseudos = map[string]pseudo{
+	"JMPA": {
+		operand:  "a label",
+		clobbers: []string{"flag"},
+		expand: func(arg []token) [][]token {
+			return [][]token{
+				{cmdX("CMP"), reg("R0"), reg("R0")},
+				append([]token{cmdZJ("JMP"), mask("010"), comma()}, arg...),
+			}
+		},
+	},
+	"EXIT":  {operand: "an immediate", clobbers: []string{"R0"}, expand: syscall(vm.SysExit)},
+	"SLEEP": {operand: "an immediate", clobbers: []string{"R0", "flag"}, expand: syscall(vm.SysSleep)},
+	"INC":   {operand: "a register", scratch: true, expand: step("ADD")},
+	"DEC":   {operand: "a register", scratch: true, expand: step("SUB")},
+	"CLR": {
+		operand: "a register",
+		expand: func(arg []token) [][]token {
+			return [][]token{{cmdX("SUB"), arg[0], arg[0]}}
+		},
+	},
+	"PUSHI": {
+		operand:  "an immediate",
+		clobbers: []string{"R0"},
+		expand: func(arg []token) [][]token {
+			return [][]token{
+				append([]token{cmdZ("LDI"), reg("R0"), comma()}, arg...),
+				{cmdY("PSH"), reg("R0")},
+			}
+		},
+	},
+}
+
+// syscall pushes the argument and makes the call, the same as writing it out
+func syscall(num int) func([]token) [][]token {
+	return func(arg []token) [][]token {
+		return [][]token{
+			append([]token{cmdZ("LDI"), reg("R0"), comma()}, arg...),
+			{cmdY("PSH"), reg("R0")},
+			{cmdZ("LDI"), reg("R0"), comma(), imm(fmt.Sprintf("0x%02X", num))},
+			{cmdY("SYS"), reg("R0")},
+		}
+	}
+}
+
+// step adds or subtracts one, the 1 is loaded into the other general purpose
+// register
+func step(op string) func([]token) [][]token {
+	return func(arg []token) [][]token {
+		scratch := scratchRegister(arg[0].val)
+		return [][]token{
+			{cmdZ("LDI"), reg(scratch), comma(), imm("0x01")},
+			{cmdX(op), arg[0], reg(scratch)},
+		}
+	}
+}
+
+// scratchRegister is the register INC and DEC overwrite for ra
+func scratchRegister(ra string) string {
+	if ra == "R0" {
+		return "R1"
+	}
+	return "R0"
+}
+
+func cmdX(v string) token  { return token{val: v, typ: CommandX} }
+func cmdY(v string) token  { return token{val: v, typ: CommandY} }
+func cmdZ(v string) token  { return token{val: v, typ: CommandZ} }
+func cmdZJ(v string) token { return token{val: v, typ: CommandZJ} }
+func reg(v string) token   { return token{val: v, typ: Register} }
+func mask(v string) token  { return token{val: v, typ: Mask} }
+func imm(v string) token   { return token{val: v, typ: Immediate} }
+func comma() token         { return token{val: ",", typ: Comma} }
+
+// expandPseudo replaces the pseudo-instruction in line. The new tokens take
+// the position of the mnemonic, the operand keeps its own.
+func expandPseudo(line []token) ([][]token, error) {
+	call := line[0]
+	p := pseudos[call.val]
+	arg := line[1:]
+
+	switch p.operand {
+	case "a register":
+		if len(arg) != 1 || arg[0].typ != Register {
+			return nil, errorfToken(call, "%s takes a register", call.val)
+		}
+		if arg[0].val == "PC" {
+			return nil, errorfToken(call, "%s cannot be used on PC", call.val)
+		}
+	default:
+		if len(arg) == 0 {
+			return nil, errorfToken(call, "%s takes %s", call.val, p.operand)
+		}
+		for _, t := range arg {
+			if t.typ == Comma {
+				return nil, errorfToken(t, "%s takes one operand", call.val)
+			}
+		}
+	}
+
+	clobbers := p.clobbers
+	if p.scratch {
+		clobbers = []string{scratchRegister(arg[0].val)}
+	}
+	exp := &expansion{
+		macro:    call.val,
+		call:     call,
+		pseudo:   true,
+		clobbers: clobbers,
+	}
+
+	// generated tokens have no line yet, operand tokens keep theirs
+	lines := p.expand(arg)
+	for _, l := range lines {
+		for i := range l {
+			if l[i].lin == 0 {
+				l[i].file, l[i].lin, l[i].col = call.file, call.lin, call.col
+				l[i].exp = exp
+			}
+		}
+	}
+	return lines, nil
+}
+
+// isPseudo reports whether line starts with a pseudo-instruction
+func isPseudo(line []token) bool {
+	if len(line) == 0 || line[0].typ != Identifier || isLabel(line) {
+		return false
+	}
+	_, ok := pseudos[line[0].val]
+	return ok
+}
+
+// registers an instruction reads and writes, "flag" is the process flag. SP
+// and PC are left out, no pseudo-instruction clobbers them.
+func effects(node *syntaxTree) (reads []string, writes []string) {
+	op := node.Children[0].Data
+	var ra, rb string
+	if len(node.Children) > 1 {
+		ra = node.Children[1].Data
+	}
+	if len(node.Children) > 2 {
+		rb = node.Children[2].Data
+	}
+
+	switch op {
+	case "MOV":
+		return []string{rb}, []string{ra}
+	case "CMP":
+		if ra == rb {
+			return nil, []string{"flag"} // always equal, JMPA uses it to set the flag
+		}
+		return []string{ra, rb}, []string{"flag"}
+	case "SHL", "SHR", "ADD", "SUB", "AND", "ORR":
+		if op == "SUB" && ra == rb {
+			return nil, []string{ra} // CLR, the old value does not matter
+		}
+		return []string{ra, rb}, []string{ra}
+	case "NOT":
+		return []string{ra}, []string{ra}
+	case "PSH", "STA":
+		return []string{ra}, nil
+	case "POP", "LDI", "LDA":
+		return nil, []string{ra}
+	case "SYS":
+		return []string{ra}, []string{"flag"}
+	case "JMP":
+		return []string{"flag"}, nil
+	}
+	return nil, nil
+}
+
+// clobberWarnings looks through straight line code for a register that a
+// pseudo-instruction overwrote being read before anything else writes it. A
+// label ends the run since it can be reached from elsewhere.
+func clobberWarnings(instrs []*syntaxTree) []Warning {
+	var warnings []Warning
+	clobbered := map[string]*expansion{}
+
+	for _, node := range instrs {
+		switch node.Symbol.Value {
+		case "identifier", "directive":
+			clear(clobbered)
+			continue
+		case "xInstruction", "yInstruction", "zInstruction":
+		default:
+			continue
+		}
+
+		t, _ := node.first()
+		reads, writes := effects(node)
+		for _, r := range reads {
+			// an expansion reads its own scratch registers
+			if by, ok := clobbered[r]; ok && by != t.exp {
+				warnings = append(warnings, warningAt(node, RuleClobbered, fmt.Sprintf(
+					"%s is read here but was clobbered by %s at %s",
+					r,
+					by.macro,
+					by.call.pos(),
+				)))
+				delete(clobbered, r)
+			}
+		}
+		for _, w := range writes {
+			delete(clobbered, w)
+		}
+		if t.exp != nil && t.exp.pseudo {
+			for _, c := range t.exp.clobbers {
+				clobbered[c] = t.exp
+			}
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,145 @@
+package assembler
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"tcp-vm/shared/vm"
+)
+
+func Test_pseudoInstructions(t *testing.T) {
+	tests := map[string][]byte{
+		"JMPA main":  {0x10, 0xC2, 0x51},
+		"EXIT 5":     {0xD0, 0x05, 0x90, 0xD0, 0x00, 0xB0},
+		"SLEEP 3":    {0xD0, 0x03, 0x90, 0xD0, 0x01, 0xB0},
+		"INC R0":     {0xD4, 0x01, 0x41},
+		"INC SP":     {0xD0, 0x01, 0x48},
+		"DEC R1":     {0xD0, 0x01, 0x54},
+		"CLR R1":     {0x55},
+		"PUSHI 'A'":  {0xD0, 0x41, 0x90},
+		"PUSHI 1+2":  {0xD0, 0x03, 0x90},
+		"EXIT COUNT": {0xD0, 0x04, 0x90, 0xD0, 0x00, 0xB0},
+	}
+
+	for line, want := range tests {
+		src := ".equ COUNT 4\n.text\nmain:\n\t" + line + "\n"
+		prog, err := AssembleString("", src, Options{})
+		if err != nil {
+			t.Fatalf("%s: AssembleString() failed: %v", line, err)
+		}
+		if got := prog.Text[:len(want)]; !bytes.Equal(got, want) {
+			t.Fatalf("%s = % X, want % X", line, got, want)
+		}
+		if _, ok := prog.SourceMap[vm.TextStart]; !ok {
+			t.Fatalf("%s: no source map entry for the expansion", line)
+		}
+	}
+}
+
+func Test_pseudoRuns(t *testing.T) {
+	src := `
+.text
+main:
+	CLR R1
+	INC R1
+	INC R1
+	DEC R1
+	PUSHI 0x07
+	POP R0
+	ADD R0 R1
+	PSH R0
+	POP R1
+	JMPA done
+	EXIT 0xFF
+done:
+	PSH R1
+	LDI R0, 0x00
+	SYS R0
+`
+	prog, err := AssembleString("runs.asm", src, Options{})
+	if err != nil {
+		t.Fatalf("AssembleString() failed: %v", err)
+	}
+
+	machine := new(vm.VirtualMachine)
+	machine.ResetFromStateless(prog.Data, prog.Text, prog.Entry)
+	if err := machine.RunUntilStop(); err != nil {
+		t.Fatalf("RunUntilStop() failed: %v", err)
+	}
+	if machine.R0 != 0x08 {
+		t.Fatalf("expected exit code 0x08, got 0x%02X", machine.R0)
+	}
+}
+
+func Test_pseudoErrors(t *testing.T) {
+	tests := map[string]string{
+		"INC":          "INC takes a register",
+		"INC 1":        "INC takes a register",
+		"DEC PC":       "DEC cannot be used on PC",
+		"CLR R0, R1":   "CLR takes a register",
+		"EXIT":         "EXIT takes an immediate",
+		"PUSHI 1, 2":   "PUSHI takes one operand",
+		"JMPA":         "JMPA takes a label",
+		"JMPA nowhere": "undefined label or constant 'nowhere'",
+		"EXIT 300":     "line 3:2 (in pseudo-instruction EXIT, clobbers R0)",
+	}
+
+	for line, want := range tests {
+		src := ".text\nmain:\n\t" + line + "\n"
+		_, err := AssembleString("", src, Options{})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("%s: expected an error containing %q, got %v", line, want, err)
+		}
+	}
+
+	src := ".macro INC r\n\tADD r r\n.endm\n.text\nmain:\n"
+	_, err := AssembleString("", src, Options{})
+	if err == nil || !strings.Contains(err.Error(), "has the name of a pseudo-instruction") {
+		t.Fatalf("expected a macro named INC to be rejected, got %v", err)
+	}
+}
+
+func Test_clobberWarnings(t *testing.T) {
+	src := `
+.text
+main:
+	LDI R0, 0x05
+	LDI R1, 0x02
+	INC R1
+	ADD R1 R0
+	PUSHI 0x03
+	LDI R0, 0x01
+	ADD R1 R0
+	PUSHI 0x03
+loop:
+	ADD R1 R0
+	JMPA loop
+`
+	prog, err := AssembleString("clobber.asm", src, Options{})
+	if err != nil {
+		t.Fatalf("AssembleString() failed: %v", err)
+	}
+
+	// only the ADD straight after INC R1 reads a clobbered register, R0 is
+	// written again after the first PUSHI and the label ends the second run
+	if len(prog.Warnings) != 1 {
+		t.Fatalf("expected 1 warning, got %v", prog.Warnings)
+	}
+	want := "clobber.asm:7:2: R0 is read here but was clobbered by INC at clobber.asm:6:2 [clobbered]"
+	if got := prog.Warnings[0].String(); got != want {
+		t.Fatalf("warning = %q, want %q", got, want)
+	}
+}
+
+func Test_clobberWarningsJMPA(t *testing.T) {
+	// JMPA compares R0 with itself, that does not read what PUSHI left in R0
+	src := ".text\nmain:\n\tPUSHI 0x03\n\tJMPA main\n"
+	prog, err := AssembleString("jmpa.asm", src, Options{})
+	if err != nil {
+		t.Fatalf("AssembleString() failed: %v", err)
+	}
+	if len(prog.Warnings) != 0 {
+		t.Fatalf("expected no warnings, got %v", prog.Warnings)
+	}
+}
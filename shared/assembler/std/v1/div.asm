@@ -0,0 +1,39 @@
+# std/div.asm: unsigned division
+#
+# div(a, b)
+#  inputs:
+#   R0 = a, R1 = b
+#  outputs:
+#   R0 = a / b, R1 = a % b
+#  clobbers:
+#   flag
+#  notes:
+#   dividing by zero gives 0 remainder a, returns via `POP PC`
+div:
+	STA R1, div_b
+	PSH R0
+	CLR R0
+	STA R0, div_q
+	CMP R1 R0
+	POP R0
+	JMP 010, div_done
+div_loop:
+	CMP R0 R1
+	JMP 100, div_done
+	SUB R0 R1
+	PSH R0
+	LDA R0, div_q
+	LDI R1, 0x01
+	ADD R0 R1
+	STA R0, div_q
+	POP R0
+	LDA R1, div_b
+	JMPA div_loop
+div_done:
+	MOV R1 R0
+	LDA R0, div_q
+	POP PC
+div_b:
+	.byte 0
+div_q:
+	.byte 0
@@ -0,0 +1,41 @@
+# std/memcpy.asm: copy words
+#
+# memcpy(dst, src, count)
+#  inputs:
+#   R0 = dst, R1 = src
+#   stack: the return address, then count on top
+#  outputs:
+#   count words from src are copied to dst, front to back
+#  clobbers:
+#   R0, R1, flag
+#  notes:
+#   the addresses are written into the LDA and STA below, so any address
+#   works, .data included. Removes the count, returns via `POP PC`
+memcpy:
+	STA R0, memcpy_store + 1
+	STA R1, memcpy_load + 1
+	POP R1
+memcpy_loop:
+	CLR R0
+	CMP R1 R0
+	JMP 010, memcpy_done
+	LDI R0, 0x01
+	SUB R1 R0
+	STA R1, memcpy_left
+memcpy_load:
+	LDA R0, 0x00
+memcpy_store:
+	STA R0, 0x00
+	LDA R0, memcpy_load + 1
+	LDI R1, 0x01
+	ADD R0 R1
+	STA R0, memcpy_load + 1
+	LDA R0, memcpy_store + 1
+	ADD R0 R1
+	STA R0, memcpy_store + 1
+	LDA R1, memcpy_left
+	JMPA memcpy_loop
+memcpy_done:
+	POP PC
+memcpy_left:
+	.byte 0
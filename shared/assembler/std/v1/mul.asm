@@ -0,0 +1,45 @@
+# std/mul.asm: multiplication
+#
+# mul(a, b)
+#  inputs:
+#   R0 = a, R1 = b
+#  outputs:
+#   R0 = a * b, modulo 256
+#  clobbers:
+#   R1, flag
+#  notes:
+#   shift and add, at most 8 rounds, returns via `POP PC`
+mul:
+	STA R0, mul_a
+	CLR R0
+	PSH R0
+mul_loop:
+	CLR R0
+	CMP R1 R0
+	JMP 010, mul_done
+	STA R1, mul_b
+	LDI R0, 0x01
+	AND R0 R1
+	LDI R1, 0x01
+	CMP R0 R1
+	POP R0
+	JMP 100, mul_skip
+	LDA R1, mul_a
+	ADD R0 R1
+mul_skip:
+	PSH R0
+	LDA R0, mul_a
+	LDI R1, 0x01
+	SHL R0 R1
+	STA R0, mul_a
+	LDA R0, mul_b
+	SHR R0 R1
+	MOV R1 R0
+	JMPA mul_loop
+mul_done:
+	POP R0
+	POP PC
+mul_a:
+	.byte 0
+mul_b:
+	.byte 0
@@ -0,0 +1,33 @@
+# std/neg.asm: two's complement helpers
+#
+# neg(x)
+#  inputs:
+#   R0 = x
+#  outputs:
+#   R0 = -x, (~x)+1
+#  clobbers:
+#   R1
+#
+# abs(x)
+#  inputs:
+#   R0 = x, read as signed (-128 to 127)
+#  outputs:
+#   R0 = |x|, abs(-128) is -128
+#  clobbers:
+#   R1, flag
+#
+# both return via `POP PC`
+neg:
+	NOT R0
+	LDI R1, 0x01
+	ADD R0 R1
+	POP PC
+abs:
+	LDI R1, 0x80
+	CMP R0 R1
+	JMP 100, abs_done
+	NOT R0
+	LDI R1, 0x01
+	ADD R0 R1
+abs_done:
+	POP PC
@@ -0,0 +1,35 @@
+# std/sum.asm: variadic sum
+#
+# sum(count, args...)
+#  inputs:
+#   stack: the return address, then the arguments, then their count on top
+#  outputs:
+#   R0 = sum of the arguments, modulo 256
+#  clobbers:
+#   R1, flag
+#  notes:
+#   removes the arguments and the count, returns via `POP PC`
+sum:
+	POP R1
+	CLR R0
+	STA R0, sum_acc
+sum_loop:
+	CLR R0
+	CMP R1 R0
+	JMP 010, sum_done
+	LDI R0, 0x01
+	SUB R1 R0
+	STA R1, sum_left
+	POP R0
+	LDA R1, sum_acc
+	ADD R0 R1
+	STA R0, sum_acc
+	LDA R1, sum_left
+	JMPA sum_loop
+sum_done:
+	LDA R0, sum_acc
+	POP PC
+sum_acc:
+	.byte 0
+sum_left:
+	.byte 0
@@ -0,0 +1,59 @@
+# std/utoa.asm: number to decimal text
+#
+# utoa(x, buf)
+#  inputs:
+#   R0 = x, R1 = address of 3 free words
+#  outputs:
+#   the ASCII digits of x, zero padded, `7` is written as `007`
+#  clobbers:
+#   R0, R1, flag
+#  notes:
+#   there is no output system call yet, the digits are left for the caller.
+#   The address is written into the STA below, returns via `POP PC`
+utoa:
+	STA R1, utoa_store + 1
+	STA R0, utoa_value
+	LDI R0, 100
+	STA R0, utoa_div
+utoa_digit:
+	LDI R0, '0'
+	STA R0, utoa_char
+utoa_count:
+	LDA R0, utoa_value
+	LDA R1, utoa_div
+	CMP R0 R1
+	JMP 100, utoa_put
+	SUB R0 R1
+	STA R0, utoa_value
+	LDA R0, utoa_char
+	LDI R1, 0x01
+	ADD R0 R1
+	STA R0, utoa_char
+	JMPA utoa_count
+utoa_put:
+	LDA R0, utoa_char
+utoa_store:
+	STA R0, 0x00
+	LDA R0, utoa_store + 1
+	LDI R1, 0x01
+	ADD R0 R1
+	STA R0, utoa_store + 1
+	LDA R0, utoa_div
+	CMP R0 R1
+	JMP 010, utoa_done
+	LDI R1, 10
+	CMP R0 R1
+	LDI R0, 10
+	JMP 001, utoa_next
+	LDI R0, 0x01
+utoa_next:
+	STA R0, utoa_div
+	JMPA utoa_digit
+utoa_done:
+	POP PC
+utoa_value:
+	.byte 0
+utoa_div:
+	.byte 0
+utoa_char:
+	.byte 0
@@ -0,0 +1,76 @@
+package assembler
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// the standard library is shipped inside the assembler, one directory per
+// major version
+//
+//go:embed std
+var stdlibFS embed.FS
+
+// StdlibVersion is the version of the standard library `std/` includes. A
+// new major version may change how routines are called, `std@1/` keeps a
+// program on the routines it was written against.
+const StdlibVersion = "1.0.0"
+
+const stdlibMajor = 1
+
+// stdlibName turns `std/mul.asm` or `std@1/mul.asm` into the name the module
+// is read under, `std@1/mul.asm`. ok is false for any other include.
+func stdlibName(name string) (string, bool, error) {
+	lib, module, found := strings.Cut(name, "/")
+	if !found || (lib != "std" && !strings.HasPrefix(lib, "std@")) {
+		return "", false, nil
+	}
+
+	major := stdlibMajor
+	if v, pinned := strings.CutPrefix(lib, "std@"); pinned {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return "", true, fmt.Errorf("invalid standard library version '%s', expected std@%d", v, stdlibMajor)
+		}
+		major = n
+	}
+	if _, err := fs.Stat(stdlibFS, fmt.Sprintf("std/v%d", major)); err != nil {
+		return "", true, fmt.Errorf("standard library version %d is not available, this assembler ships %s", major, StdlibVersion)
+	}
+
+	canonical := fmt.Sprintf("std@%d/%s", major, module)
+	if _, err := fs.Stat(stdlibFS, stdlibPath(canonical)); err != nil {
+		return "", true, fmt.Errorf("no module '%s' in the standard library %d, there is %s",
+			module, major, strings.Join(StdlibModules(major), ", "))
+	}
+	return canonical, true, nil
+}
+
+// stdlibPath is where a module read as `std@1/mul.asm` is embedded
+func stdlibPath(canonical string) string {
+	lib, module, _ := strings.Cut(canonical, "/")
+	return path.Join("std", "v"+strings.TrimPrefix(lib, "std@"), module)
+}
+
+func isStdlib(name string) bool {
+	return strings.HasPrefix(name, "std@")
+}
+
+// StdlibModules lists the modules of a major version of the standard library
+func StdlibModules(major int) []string {
+	entries, err := fs.ReadDir(stdlibFS, fmt.Sprintf("std/v%d", major))
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names
+}
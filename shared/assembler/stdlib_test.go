@@ -0,0 +1,131 @@
+package assembler
+
+import (
+	"fmt"
+	"strings"
+	"tcp-vm/shared/vm"
+	"testing"
+)
+
+// runStd calls routine from module with setup run after the return address is
+// pushed, the program exits with what the routine left in R0
+func runStd(t *testing.T, module string, setup string, routine string) *vm.VirtualMachine {
+	t.Helper()
+	src := fmt.Sprintf(`.data
+	buf:
+	.zero 3
+	src:
+	.string "abc"
+.text
+main:
+	LDI R0, back
+	PSH R0
+%s
+	JMPA %s
+back:
+	PSH R0
+	LDI R0, 0x00
+	SYS R0
+.include "std/%s"
+`, setup, routine, module)
+
+	prog, err := AssembleString("harness.asm", src, Options{})
+	if err != nil {
+		t.Fatalf("%s: %s", module, Explain(err))
+	}
+	if len(prog.Warnings) != 0 {
+		t.Fatalf("%s: unexpected warnings %v", module, prog.Warnings)
+	}
+
+	machine := new(vm.VirtualMachine)
+	machine.ResetFromStateless(prog.Data, prog.Text, prog.Entry)
+	if err := machine.RunUntilStop(); err != nil {
+		t.Fatalf("%s: RunUntilStop() failed: %v", module, err)
+	}
+	if machine.SP != vm.StackStart {
+		t.Fatalf("%s: stack holds %d words after returning", module, machine.SP-vm.StackStart)
+	}
+	return machine
+}
+
+func regs(a, b int) string {
+	return fmt.Sprintf("\tLDI R0, %d\n\tLDI R1, %d", a, b)
+}
+
+func Test_stdlibArithmetic(t *testing.T) {
+	tests := []struct {
+		module, routine, setup string
+		want                   vm.Register
+	}{
+		{"mul.asm", "mul", regs(6, 7), 42},
+		{"mul.asm", "mul", regs(0, 9), 0},
+		{"mul.asm", "mul", regs(13, 1), 13},
+		{"mul.asm", "mul", regs(16, 17), 16}, // 272 wraps
+		{"div.asm", "div", regs(100, 7), 14},
+		{"div.asm", "div", regs(5, 9), 0},
+		{"div.asm", "div", regs(255, 1), 255},
+		{"div.asm", "div", regs(9, 0), 0},
+		{"neg.asm", "neg", regs(5, 0), 251},
+		{"neg.asm", "neg", regs(0, 0), 0},
+		{"neg.asm", "abs", regs(-5, 0), 5},
+		{"neg.asm", "abs", regs(5, 0), 5},
+		{"sum.asm", "sum", "\tLDI R0, 1\n\tPSH R0\n\tLDI R0, 2\n\tPSH R0\n\tLDI R0, 3\n\tPSH R0\n\tPSH R0", 6},
+		{"sum.asm", "sum", "\tCLR R0\n\tPSH R0", 0},
+	}
+	for _, tt := range tests {
+		machine := runStd(t, tt.module, tt.setup, tt.routine)
+		if machine.R0 != tt.want {
+			t.Fatalf("%s with\n%s\ngave %d, want %d", tt.routine, tt.setup, machine.R0, tt.want)
+		}
+	}
+}
+
+func Test_stdlibRemainder(t *testing.T) {
+	// exiting does not touch R1, it still holds the remainder
+	for _, tt := range []struct{ a, b, want int }{{100, 7, 2}, {9, 0, 9}, {6, 3, 0}} {
+		machine := runStd(t, "div.asm", regs(tt.a, tt.b), "div")
+		if int(machine.R1) != tt.want {
+			t.Fatalf("%d %% %d = %d, want %d", tt.a, tt.b, machine.R1, tt.want)
+		}
+	}
+}
+
+func Test_stdlibMemory(t *testing.T) {
+	// memcpy(buf, src, 3)
+	machine := runStd(t, "memcpy.asm", "\tLDI R0, 3\n\tPSH R0\n\tLDI R0, buf\n\tLDI R1, src", "memcpy")
+	if got := string(machine.Memory[0:3]); got != "abc" {
+		t.Fatalf("memcpy copied %q, want %q", got, "abc")
+	}
+
+	for x, want := range map[int]string{7: "007", 42: "042", 255: "255", 100: "100", 0: "000"} {
+		machine := runStd(t, "utoa.asm", fmt.Sprintf("\tLDI R0, %d\n\tLDI R1, buf", x), "utoa")
+		if got := string(machine.Memory[0:3]); got != want {
+			t.Fatalf("utoa(%d) wrote %q, want %q", x, got, want)
+		}
+	}
+}
+
+func Test_stdlibNames(t *testing.T) {
+	src := ".text\nmain:\n\tEXIT 0x00\n.include \"std@1/neg.asm\"\n"
+	prog, err := AssembleString("pinned.asm", src, Options{})
+	if err != nil {
+		t.Fatalf("pinned include failed: %s", Explain(err))
+	}
+	if _, ok := prog.Symbols["abs"]; !ok {
+		t.Fatalf("std@1/neg.asm did not define abs")
+	}
+	if pos := prog.SourceMap[prog.Symbols["neg"]]; pos.File != "std@1/neg.asm" {
+		t.Fatalf("neg maps to %v, want std@1/neg.asm", pos)
+	}
+
+	for include, want := range map[string]string{
+		"std@9/neg.asm": "standard library version 9 is not available",
+		"std/nope.asm":  "no module 'nope.asm' in the standard library 1, there is div.asm, memcpy.asm",
+		"std@x/neg.asm": "invalid standard library version 'x'",
+	} {
+		_, err := AssembleString("bad.asm", ".text\nmain:\n.include \""+include+"\"\n", Options{})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("%s: expected an error containing %q, got %v", include, want, err)
+		}
+	}
+}
@@ -59,29 +59,56 @@ func (llpt *llParseTable) prettyPrint() {
 	}
 }
 
-func tokenMatches(tok token, gi grammarItem) bool {
-	if gi.Value == tok.val {
-		return true
+// terminals named after a ttype (identifier, register, ...) only match by
+// type, so a label called `register` is still an identifier
+var typeTerminals = func() util.Set[string] {
+	names := util.NewSet[string]()
+	for tt := Section; tt < Unknown; tt++ {
+		names.Add(strings.ToLower(strings.TrimPrefix(tt.String(), "ttype.")))
 	}
+	return names
+}()
 
+func tokenMatches(tok token, gi grammarItem) bool {
 	typeName := strings.TrimPrefix(tok.typ.String(), "ttype.")
-	if strings.EqualFold(typeName, gi.Value) {
-		return true
+	if typeTerminals.Contains(strings.ToLower(gi.Value)) {
+		return strings.EqualFold(typeName, gi.Value)
 	}
 
+	return gi.Value == tok.val
+}
+
+// list symbols the parser can pick up again from after a syntax error
+func isSyncSymbol(sym string) bool {
+	switch sym {
+	case "defs", "dataList", "textList":
+		return true
+	}
 	return false
 }
 
+// sameLine reports whether two tokens were lexed from the same line, tokens
+// from one macro or pseudo-instruction expansion count as their own line
+func sameLine(a token, b token) bool {
+	return a.file == b.file && a.lin == b.lin && a.exp == b.exp
+}
+
+// llTabularParse parses tokens, on a syntax error it records a diagnostic and
+// recovers in panic mode: the rest of the line is skipped and the stack is
+// unwound to a list that can carry on from the next line. Every error found
+// is returned together as Diagnostics.
 func (table *llParseTable) llTabularParse(
 	tokens []token,
 	start grammarItem,
 ) (*syntaxTree, error) {
-	var ts util.Queue[token]
-	for _, t := range tokens {
-		ts.Push(t)
-	}
+	// the end of input sits just past the last token
 	eof := token{val: "$", typ: Unknown, lin: 0}
-	ts.Push(eof)
+	if n := len(tokens); n > 0 {
+		last := tokens[n-1]
+		eof.file, eof.lin, eof.col = last.file, last.lin, last.col+len(last.val)
+	}
+	toks := append(append([]token(nil), tokens...), eof)
+	next := 0
 
 	root := newSyntaxTree(start, start.Value)
 	current := root
@@ -89,12 +116,96 @@ func (table *llParseTable) llTabularParse(
 	var stack util.Stack[grammarItem]
 	stack.Push(start)
 
+	var diags Diagnostics
+	lastError := -1
+
+	// resync skips input and unwinds the stack until they agree again, it
+	// returns false once there is nothing left to parse
+	resync := func() bool {
+		// a token starting a line may begin something valid, anything else
+		// takes the rest of its line with it. An error at the same token
+		// twice always skips so recovery makes progress.
+		startsLine := next == 0 || !sameLine(toks[next-1], toks[next])
+		skipLine := func() {
+			at := toks[next]
+			for next < len(toks)-1 && sameLine(toks[next], at) {
+				next++
+			}
+		}
+		if !startsLine || lastError == next {
+			skipLine()
+		}
+		lastError = next
+
+		for {
+			tok := toks[next]
+			for i := len(stack) - 1; i >= 0; i-- {
+				sym := stack[i]
+				resume := false
+				switch {
+				case sym.Type == NonTerminal && isSyncSymbol(sym.Value):
+					for term := range table.Data[sym] {
+						if tokenMatches(tok, term) {
+							resume = true
+							break
+						}
+					}
+				case sym.Type == Terminal && sym.Value == "$":
+					resume = next == len(toks)-1
+				}
+				if !resume {
+					continue
+				}
+				// everything above sym is abandoned, climb out of the
+				// nodes it belonged to
+				for _, dropped := range stack[i+1:] {
+					if dropped == marker && current.Parent != nil {
+						current = current.Parent
+					}
+				}
+				stack = stack[:i+1]
+				return true
+			}
+			if next == len(toks)-1 {
+				return false
+			}
+			skipLine()
+		}
+	}
+
+	// an error part way through a line that only shows up on the next one is
+	// reported just after the last token of the unfinished line
+	unfinished := func(x grammarItem) (token, bool) {
+		if next == 0 || isSyncSymbol(x.Value) || sameLine(toks[next-1], toks[next]) {
+			return token{}, false
+		}
+		end := toks[next-1]
+		end.col += len(end.val)
+		end.val = " "
+		return end, true
+	}
+
+	report := func(d Diagnostic) bool {
+		diags = append(diags, d)
+		if len(diags) == maxDiagnostics {
+			diags = append(diags, diagnostic(toks[next], "too many errors, stopping"))
+			return false
+		}
+		return resync()
+	}
+
+parse:
 	for len(stack) > 0 {
 		x, _ := stack.Pop()
+		peekTok := toks[next]
 
 		if x.Type == Terminal && x.Value == "$" {
-			if _, err := ts.Pop(); err != nil {
-				return nil, fmt.Errorf("unexpected end of input")
+			if next != len(toks)-1 {
+				d := diagnostic(peekTok, "unexpected %s after the end of the program", describeToken(peekTok))
+				if !report(d) {
+					break parse
+				}
+				continue
 			}
 			continue
 		}
@@ -109,11 +220,6 @@ func (table *llParseTable) llTabularParse(
 
 		switch x.Type {
 		case NonTerminal:
-			peekTok, err := ts.Peek()
-			if err != nil {
-				return nil, fmt.Errorf("unexpected end of input")
-			}
-
 			col, exists := table.Data[x]
 			if !exists {
 				return nil, fmt.Errorf(
@@ -132,11 +238,20 @@ func (table *llParseTable) llTabularParse(
 				}
 			}
 			if !matched {
-				return nil, fmt.Errorf(
-					"no rule for %s when next token is %v",
-					x.Value,
-					peekTok,
-				)
+				d := diagnostic(peekTok, "unexpected %s", describeToken(peekTok))
+				if end, ok := unfinished(x); ok {
+					d = diagnostic(end, "unexpected end of line")
+				}
+				d.Expected = table.expectedFrom(x, stack)
+				// a list that was already popped is where parsing picks up
+				// again, resync only looks at the stack
+				if isSyncSymbol(x.Value) {
+					stack.Push(x)
+				}
+				if !report(d) {
+					break parse
+				}
+				continue
 			}
 			rule := col[sel]
 
@@ -149,36 +264,30 @@ func (table *llParseTable) llTabularParse(
 			current = node
 
 		case Terminal:
-			if x.Type == Terminal {
-				peekTok, err := ts.Peek()
-				if err != nil {
-					return nil, fmt.Errorf(
-						"unexpected end of input",
-					)
+			if !tokenMatches(peekTok, x) {
+				want := describeTerminal(x)
+				d := diagnostic(peekTok, "expected %s but got %s", want, describeToken(peekTok))
+				if end, ok := unfinished(x); ok {
+					d = diagnostic(end, "expected %s at the end of the line", want)
 				}
-
-				if !tokenMatches(peekTok, x) {
-					return nil, fmt.Errorf(
-						"expected '%s' but got '%s'",
-						x.Value,
-						peekTok.val,
-					)
+				d.Expected = []string{want}
+				if !report(d) {
+					break parse
 				}
-
-				tok, _ := ts.Pop()
-				current.addChild(x, tok.val)
-			} else {
-				// lambda (empty string)
-				current.addChild(x, "")
+				continue
 			}
 
+			leaf := current.addChild(x, peekTok.val)
+			leaf.Token = peekTok
+			next++
+
 		default:
-			// marker: ascend a node
-			if x == marker && current.Parent != nil {
-				current = current.Parent
-			}
+			// lambda (empty string)
 		}
 	}
 
+	if len(diags) > 0 {
+		return nil, diags
+	}
 	return root, nil
 }
@@ -18,7 +18,7 @@ func Test_tableCreation(t *testing.T) {
 }
 
 func Test_llTabularParse(t *testing.T) {
-	tokens, err := lex("./add_data.asm")
+	tokens, err := lex("./add_data.asm", Options{})
 	if err != nil {
 		t.Fatalf("lex() failed: %v", err)
 	}
@@ -47,16 +47,16 @@ func Test_llTabularParse(t *testing.T) {
 
 	if simp := st.applySDT(); simp != nil {
 		simp.prettyPrint()
-		data, text, err := simp.compile()
+		prog, err := simp.compile()
 		if err != nil {
 			t.Fatalf("compile() filed: %v", err)
 		}
 		t.Logf("data section:\n")
-		for _, b := range data {
+		for _, b := range prog.Data {
 			t.Logf("%+v", b)
 		}
 		t.Logf("text section:\n")
-		for _, b := range text {
+		for _, b := range prog.Text {
 			t.Logf("%+v", b)
 		}
 	}
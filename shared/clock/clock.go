@@ -0,0 +1,47 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for sleeping programs, shared by the vm, server
+// and router so tests can control when jobs wake up
+type Clock interface {
+	Now() time.Time
+}
+
+// Real reads the wall clock
+type Real struct{}
+
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+// Simulated only moves when told to
+type Simulated struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func NewSimulated(start time.Time) *Simulated {
+	return &Simulated{now: start}
+}
+
+func (s *Simulated) Now() time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.now
+}
+
+func (s *Simulated) Advance(d time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.now = s.now.Add(d)
+}
+
+func (s *Simulated) Set(t time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.now = t
+}
@@ -0,0 +1,158 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	g "tcp-vm/shared/globals"
+	"tcp-vm/shared/vm"
+)
+
+// core file layout (big endian):
+//
+//	4 Bytes   # magic "TVMC"
+//	1 Byte    # version
+//	4 Bytes   # R0, R1, SP, PC
+//	1 Byte    # selected bank
+//	256 Bytes # memory
+//	128 Bytes # extended memory pages
+//	1 Byte    # address of the faulting instruction
+//	4 Bytes   # step count
+//	32 Bytes  # sha256 of the program (.data + .text as loaded)
+//	2 Bytes   # length of the fault reason
+//	N Bytes   # fault reason
+const (
+	Magic   = "TVMC"
+	Version = 1
+)
+
+const (
+	memorySize = vm.MemoryEnd - vm.MemoryStart + 1
+	banksSize  = g.BankCount * g.BankLength
+	headerSize = len(Magic) + 1 + 4 + 1 + memorySize + banksSize + 1 + 4 + sha256.Size + 2
+)
+
+type Core struct {
+	R0, R1, SP, PC byte
+	Bank           byte
+	Memory         vm.Memory
+	Banks          [banksSize]byte
+	FaultPC        byte
+	Reason         string
+	Steps          uint32
+	ProgramHash    [sha256.Size]byte
+}
+
+// ProgramHash identifies a program by its stateless image, used to check a
+// core against the source it is inspected with
+func ProgramHash(data []byte, text []byte) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write(data)
+	h.Write(text)
+	var out [sha256.Size]byte
+	copy(out[:], h.Sum(nil))
+	return out
+}
+
+// New snapshots machine after RunUntilStop returned fault
+func New(machine *vm.VirtualMachine, fault error, hash [sha256.Size]byte) *Core {
+	c := &Core{
+		R0:          byte(machine.R0),
+		R1:          byte(machine.R1),
+		SP:          byte(machine.SP),
+		PC:          byte(machine.PC),
+		Bank:        machine.Bank,
+		Memory:      machine.Memory,
+		FaultPC:     byte(machine.PC),
+		Reason:      fault.Error(),
+		Steps:       uint32(machine.Steps),
+		ProgramHash: hash,
+	}
+	copy(c.Banks[:], machine.BankBytes())
+
+	var f *vm.Fault
+	if errors.As(fault, &f) {
+		c.FaultPC = byte(f.PC)
+	}
+
+	return c
+}
+
+func (c *Core) Marshal() ([]byte, error) {
+	if len(c.Reason) > 0xFFFF {
+		return nil, fmt.Errorf("core: fault reason too long: %d", len(c.Reason))
+	}
+
+	buf := make([]byte, 0, headerSize+len(c.Reason))
+	buf = append(buf, Magic...)
+	buf = append(buf, Version)
+	buf = append(buf, c.R0, c.R1, c.SP, c.PC)
+	buf = append(buf, c.Bank)
+	buf = append(buf, c.Memory[:]...)
+	buf = append(buf, c.Banks[:]...)
+	buf = append(buf, c.FaultPC)
+	buf = binary.BigEndian.AppendUint32(buf, c.Steps)
+	buf = append(buf, c.ProgramHash[:]...)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(c.Reason)))
+	buf = append(buf, c.Reason...)
+	return buf, nil
+}
+
+func Parse(raw []byte) (*Core, error) {
+	if len(raw) < headerSize {
+		return nil, fmt.Errorf("core: too short: %d < %d", len(raw), headerSize)
+	}
+	if string(raw[:len(Magic)]) != Magic {
+		return nil, errors.New("core: bad magic, not a core file")
+	}
+	i := len(Magic)
+	if raw[i] != Version {
+		return nil, fmt.Errorf("core: unsupported version %d", raw[i])
+	}
+	i++
+
+	var c Core
+	c.R0, c.R1, c.SP, c.PC = raw[i], raw[i+1], raw[i+2], raw[i+3]
+	i += 4
+	c.Bank = raw[i]
+	i++
+	copy(c.Memory[:], raw[i:i+memorySize])
+	i += memorySize
+	copy(c.Banks[:], raw[i:i+banksSize])
+	i += banksSize
+	c.FaultPC = raw[i]
+	i++
+	c.Steps = binary.BigEndian.Uint32(raw[i : i+4])
+	i += 4
+	copy(c.ProgramHash[:], raw[i:i+sha256.Size])
+	i += sha256.Size
+	n := int(binary.BigEndian.Uint16(raw[i : i+2]))
+	i += 2
+	if len(raw) != i+n {
+		return nil, fmt.Errorf("core: fault reason length %d does not match %d remaining bytes", n, len(raw)-i)
+	}
+	c.Reason = string(raw[i:])
+
+	return &c, nil
+}
+
+func (c *Core) Data() []byte {
+	return c.Memory[vm.DataStart : vm.DataStart+g.DataSectionLength]
+}
+
+func (c *Core) Text() []byte {
+	return c.Memory[vm.TextStart : vm.TextStart+g.TextSectionLength]
+}
+
+// Stack returns the pushed words, bottom first
+func (c *Core) Stack() []byte {
+	sp := int(c.SP)
+	if sp < vm.StackStart {
+		return nil
+	}
+	if sp > vm.StackStart+g.StackLength {
+		sp = vm.StackStart + g.StackLength
+	}
+	return c.Memory[vm.StackStart:sp]
+}
@@ -0,0 +1,55 @@
+package core
+
+import (
+	g "tcp-vm/shared/globals"
+	"tcp-vm/shared/vm"
+	"testing"
+)
+
+func Test_roundTrip(t *testing.T) {
+	var data [g.DataSectionLength]byte
+	text := [g.TextSectionLength]byte{
+		0xD0, 0x07, // LDI R0, 0x07
+		0x90, // PSH R0
+		0xA0, // POP R0
+		0xA4, // POP R1 (underflow)
+	}
+	data[0] = 0x2A
+
+	machine := new(vm.VirtualMachine)
+	machine.ResetFromStateless(data, text, vm.TextStart)
+	fault := machine.RunUntilStop()
+	if fault == nil {
+		t.Fatalf("expected the program to fault")
+	}
+
+	hash := ProgramHash(data[:], text[:])
+	raw, err := New(machine, fault, hash).Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	c, err := Parse(raw)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	if c.FaultPC != vm.TextStart+4 {
+		t.Fatalf("expected fault at 0x%02X, got 0x%02X", vm.TextStart+4, c.FaultPC)
+	}
+	if c.Reason != fault.Error() {
+		t.Fatalf("expected reason %q, got %q", fault.Error(), c.Reason)
+	}
+	if c.Steps != 4 {
+		t.Fatalf("expected 4 steps, got %d", c.Steps)
+	}
+	if c.R0 != 0x07 || c.ProgramHash != hash || c.Data()[0] != 0x2A {
+		t.Fatalf("core does not match the machine: %+v", c)
+	}
+	if len(c.Stack()) != 0 {
+		t.Fatalf("expected an empty stack, got % X", c.Stack())
+	}
+
+	if _, err := Parse(raw[:len(raw)-1]); err == nil {
+		t.Fatalf("expected truncated core to fail parsing")
+	}
+}
@@ -0,0 +1,256 @@
+package debuginfo
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// debug info layout (big endian):
+//
+//	4 Bytes # magic "TVMD"
+//	1 Byte  # version
+//	1 Byte  # string count, then each string as 1 Byte length + bytes
+//	1 Byte  # line count, then per line:
+//	          1 Byte addr, 1 Byte file string, 2 Bytes line, 1 Byte column
+//	1 Byte  # text label count, then per label: 1 Byte addr, 1 Byte name string
+//	1 Byte  # data name count, then per name: 1 Byte addr, 1 Byte name string
+//
+// File names and label names share the string table so each is only sent
+// once. The enclosing label of an address is worked out from the text labels
+// rather than stored with every line.
+const (
+	Magic   = "TVMD"
+	Version = 1
+)
+
+// counts and string table indexes are single bytes
+const maxEntries = 255
+
+// Line is where the instruction or text data at Addr was written
+type Line struct {
+	Addr uint8
+	File string // empty for unnamed input
+	Line int
+	Col  int
+}
+
+// Symbol names an address
+type Symbol struct {
+	Addr uint8
+	Name string
+}
+
+// Info maps the addresses of an assembled program back to its source
+type Info struct {
+	Lines  []Line   // sorted by address
+	Labels []Symbol // text labels, sorted by address
+	Data   []Symbol // data labels, sorted by address
+}
+
+// Sort puts every table in address order, Lookup relies on it
+func (d *Info) Sort() {
+	sort.SliceStable(d.Lines, func(i, j int) bool { return d.Lines[i].Addr < d.Lines[j].Addr })
+	for _, syms := range [][]Symbol{d.Labels, d.Data} {
+		sort.SliceStable(syms, func(i, j int) bool {
+			if syms[i].Addr != syms[j].Addr {
+				return syms[i].Addr < syms[j].Addr
+			}
+			return syms[i].Name < syms[j].Name
+		})
+	}
+}
+
+// Lookup returns the line holding pc and the closest text label at or before
+// it, pc may point into the middle of an instruction
+func (d *Info) Lookup(pc uint8) (Line, string, bool) {
+	i := sort.Search(len(d.Lines), func(i int) bool { return d.Lines[i].Addr > pc })
+	if i == 0 {
+		return Line{}, "", false
+	}
+	line := d.Lines[i-1]
+
+	label := ""
+	for _, l := range d.Labels {
+		if l.Addr > pc {
+			break
+		}
+		label = l.Name
+	}
+	return line, label, true
+}
+
+// Describe names pc as `file:line in label`, or just the address when it is
+// not covered
+func (d *Info) Describe(pc uint8) string {
+	line, label, ok := d.Lookup(pc)
+	if !ok {
+		return fmt.Sprintf("0x%02X", pc)
+	}
+
+	at := fmt.Sprintf("%s:%d", line.File, line.Line)
+	if line.File == "" {
+		at = fmt.Sprintf("line %d", line.Line)
+	}
+	if label != "" {
+		at += " in " + label
+	}
+	return at
+}
+
+// DataName returns the data label at addr
+func (d *Info) DataName(addr uint8) (string, bool) {
+	for _, s := range d.Data {
+		if s.Addr == addr {
+			return s.Name, true
+		}
+	}
+	return "", false
+}
+
+func (d *Info) Marshal() ([]byte, error) {
+	var strs []string
+	index := map[string]int{}
+	intern := func(s string) (byte, error) {
+		if len(s) > 255 {
+			return 0, fmt.Errorf("name '%s...' is longer than 255 bytes", s[:16])
+		}
+		if i, ok := index[s]; ok {
+			return byte(i), nil
+		}
+		if len(strs) == maxEntries {
+			return 0, fmt.Errorf("more than %d file and label names", maxEntries)
+		}
+		index[s] = len(strs)
+		strs = append(strs, s)
+		return byte(len(strs) - 1), nil
+	}
+
+	var body []byte
+	if len(d.Lines) > maxEntries {
+		return nil, fmt.Errorf("%d lines, at most %d fit", len(d.Lines), maxEntries)
+	}
+	body = append(body, byte(len(d.Lines)))
+	for _, l := range d.Lines {
+		file, err := intern(l.File)
+		if err != nil {
+			return nil, err
+		}
+		if l.Line < 0 || l.Line > 0xFFFF || l.Col < 0 || l.Col > 0xFF {
+			return nil, fmt.Errorf("position %d:%d at 0x%02X does not fit", l.Line, l.Col, l.Addr)
+		}
+		body = append(body, l.Addr, file)
+		body = binary.BigEndian.AppendUint16(body, uint16(l.Line))
+		body = append(body, byte(l.Col))
+	}
+
+	for _, syms := range [][]Symbol{d.Labels, d.Data} {
+		if len(syms) > maxEntries {
+			return nil, fmt.Errorf("%d symbols, at most %d fit", len(syms), maxEntries)
+		}
+		body = append(body, byte(len(syms)))
+		for _, s := range syms {
+			name, err := intern(s.Name)
+			if err != nil {
+				return nil, err
+			}
+			body = append(body, s.Addr, name)
+		}
+	}
+
+	buf := append([]byte(Magic), Version, byte(len(strs)))
+	for _, s := range strs {
+		buf = append(buf, byte(len(s)))
+		buf = append(buf, s...)
+	}
+	return append(buf, body...), nil
+}
+
+var errShort = errors.New("debug info: truncated")
+
+func Parse(raw []byte) (*Info, error) {
+	if len(raw) < len(Magic)+2 || string(raw[:len(Magic)]) != Magic {
+		return nil, fmt.Errorf("debug info: bad magic")
+	}
+	if raw[len(Magic)] != Version {
+		return nil, fmt.Errorf("debug info: unsupported version %d", raw[len(Magic)])
+	}
+
+	r := raw[len(Magic)+1:]
+	take := func(n int) ([]byte, error) {
+		if len(r) < n {
+			return nil, errShort
+		}
+		out := r[:n]
+		r = r[n:]
+		return out, nil
+	}
+
+	n, err := take(1)
+	if err != nil {
+		return nil, err
+	}
+	strs := make([]string, n[0])
+	for i := range strs {
+		size, err := take(1)
+		if err != nil {
+			return nil, err
+		}
+		s, err := take(int(size[0]))
+		if err != nil {
+			return nil, err
+		}
+		strs[i] = string(s)
+	}
+	str := func(i byte) (string, error) {
+		if int(i) >= len(strs) {
+			return "", fmt.Errorf("debug info: string %d out of range", i)
+		}
+		return strs[i], nil
+	}
+
+	d := &Info{}
+	if n, err = take(1); err != nil {
+		return nil, err
+	}
+	for range n[0] {
+		e, err := take(5)
+		if err != nil {
+			return nil, err
+		}
+		file, err := str(e[1])
+		if err != nil {
+			return nil, err
+		}
+		d.Lines = append(d.Lines, Line{
+			Addr: e[0],
+			File: file,
+			Line: int(binary.BigEndian.Uint16(e[2:4])),
+			Col:  int(e[4]),
+		})
+	}
+
+	for _, syms := range []*[]Symbol{&d.Labels, &d.Data} {
+		if n, err = take(1); err != nil {
+			return nil, err
+		}
+		for range n[0] {
+			e, err := take(2)
+			if err != nil {
+				return nil, err
+			}
+			name, err := str(e[1])
+			if err != nil {
+				return nil, err
+			}
+			*syms = append(*syms, Symbol{Addr: e[0], Name: name})
+		}
+	}
+
+	if len(r) != 0 {
+		return nil, fmt.Errorf("debug info: %d trailing bytes", len(r))
+	}
+	d.Sort()
+	return d, nil
+}
@@ -0,0 +1,88 @@
+package debuginfo
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func sample() *Info {
+	return &Info{
+		Lines: []Line{
+			{Addr: 0x51, File: "adder.asm", Line: 8, Col: 2},
+			{Addr: 0x53, File: "adder.asm", Line: 10, Col: 2},
+			{Addr: 0x55, File: "lib/print.asm", Line: 3, Col: 2},
+		},
+		Labels: []Symbol{
+			{Addr: 0x51, Name: "main"},
+			{Addr: 0x53, Name: "adderloopstart"},
+		},
+		Data: []Symbol{
+			{Addr: 0x00, Name: "count"},
+		},
+	}
+}
+
+func Test_roundTrip(t *testing.T) {
+	d := sample()
+	raw, err := d.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	got, err := Parse(raw)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, d) {
+		t.Fatalf("round trip changed the info:\n got %+v\nwant %+v", got, d)
+	}
+
+	// the file name is only stored once
+	if n := bytes.Count(raw, []byte("adder.asm")); n != 1 {
+		t.Fatalf("expected adder.asm once in the encoding, found %d", n)
+	}
+}
+
+func Test_describe(t *testing.T) {
+	d := sample()
+	tests := map[uint8]string{
+		0x51: "adder.asm:8 in main",
+		0x52: "adder.asm:8 in main", // the operand of the instruction at 0x51
+		0x54: "adder.asm:10 in adderloopstart",
+		0x55: "lib/print.asm:3 in adderloopstart",
+		0x50: "0x50",
+	}
+	for pc, want := range tests {
+		if got := d.Describe(pc); got != want {
+			t.Fatalf("Describe(0x%02X) = %q, want %q", pc, got, want)
+		}
+	}
+
+	unnamed := &Info{Lines: []Line{{Addr: 0x51, Line: 4, Col: 1}}}
+	if got := unnamed.Describe(0x51); got != "line 4" {
+		t.Fatalf("Describe() without a file or label = %q, want %q", got, "line 4")
+	}
+
+	if name, ok := d.DataName(0x00); !ok || name != "count" {
+		t.Fatalf("DataName(0x00) = %q, %v", name, ok)
+	}
+}
+
+func Test_parseErrors(t *testing.T) {
+	raw, err := sample().Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+
+	tests := map[string][]byte{
+		"bad magic": append([]byte("XXXX"), raw[4:]...),
+		"version":   append(append([]byte(Magic), 9), raw[5:]...),
+		"truncated": raw[:len(raw)-1],
+		"trailing":  append(append([]byte(nil), raw...), 0x00),
+	}
+	for name, bad := range tests {
+		if _, err := Parse(bad); err == nil {
+			t.Fatalf("%s: expected Parse() to fail", name)
+		}
+	}
+}
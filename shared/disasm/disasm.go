@@ -0,0 +1,257 @@
+package disasm
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"tcp-vm/shared/vm"
+)
+
+// opcodes, the top nibble of every instruction
+const (
+	OpMOV byte = iota
+	OpCMP
+	OpSHL
+	OpSHR
+	OpADD
+	OpSUB
+	OpAND
+	OpORR
+	OpNOT
+	OpPSH
+	OpPOP
+	OpSYS
+	OpJMP
+	OpLDI
+	OpLDA
+	OpSTA
+)
+
+// register numbers, see parseRegister in the assembler
+const (
+	RegR0 byte = iota
+	RegR1
+	RegSP
+	RegPC
+)
+
+var mnemonics = [...]string{
+	"MOV", "CMP", "SHL", "SHR", "ADD", "SUB", "AND", "ORR",
+	"NOT", "PSH", "POP", "SYS", "JMP", "LDI", "LDA", "STA",
+}
+
+var registers = [...]string{"R0", "R1", "SP", "PC"}
+
+type Form int
+
+const (
+	FormX Form = iota
+	FormY
+	FormZ
+	FormZJ
+)
+
+func (f Form) String() string {
+	switch f {
+	case FormX:
+		return "X"
+	case FormY:
+		return "Y"
+	case FormZ:
+		return "Z"
+	case FormZJ:
+		return "ZJ"
+	}
+	return "impossible"
+}
+
+type Instruction struct {
+	Addr   uint8
+	Raw    []byte
+	Form   Form
+	Opcode byte
+	Ra     byte
+	Rb     byte
+	Mask   byte
+	Imm    byte
+}
+
+func (in Instruction) Size() int {
+	return len(in.Raw)
+}
+
+func (in Instruction) Mnemonic() string {
+	return mnemonics[in.Opcode]
+}
+
+func RegisterName(r byte) string {
+	return registers[r&0x03]
+}
+
+// Decode the instruction starting at text[off], base is the address of
+// text[0] in vm memory
+func Decode(text []byte, off int, base uint8) (Instruction, error) {
+	if off < 0 || off >= len(text) {
+		return Instruction{}, fmt.Errorf("offset %d outside of text", off)
+	}
+
+	b := text[off]
+	in := Instruction{
+		Addr:   base + uint8(off),
+		Opcode: b >> 4,
+	}
+
+	switch {
+	case in.Opcode < OpNOT:
+		// o4 o3 o2 o1 ra1 ra0 rb1 rb0
+		in.Form = FormX
+		in.Ra = (b >> 2) & 0x03
+		in.Rb = b & 0x03
+	case in.Opcode < OpJMP:
+		// o4 o3 o2 o1 0 0 ra1 ra0
+		in.Form = FormY
+		in.Ra = b & 0x03
+	case in.Opcode == OpJMP:
+		// o4 o3 o2 o1 0 m2 m1 m0, immediate
+		in.Form = FormZJ
+		in.Mask = b & 0x07
+	default:
+		// o4 o3 o2 o1 ra1 ra0 0 0, immediate
+		in.Form = FormZ
+		in.Ra = (b >> 2) & 0x03
+	}
+
+	size := 1
+	if in.Form == FormZ || in.Form == FormZJ {
+		size = 2
+		if off+1 >= len(text) {
+			return Instruction{}, fmt.Errorf(
+				"%s at 0x%02X is missing its immediate",
+				in.Mnemonic(),
+				in.Addr,
+			)
+		}
+		in.Imm = text[off+1]
+	}
+	in.Raw = text[off : off+size]
+
+	return in, nil
+}
+
+// Symbols maps an address back to a label name
+type Symbols map[uint8]string
+
+// SymbolsFromLabels inverts an assembler label table, when several labels
+// share an address the first one alphabetically wins
+func SymbolsFromLabels(labels map[string]uint8) Symbols {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	syms := Symbols{}
+	for _, name := range names {
+		if _, taken := syms[labels[name]]; !taken {
+			syms[labels[name]] = name
+		}
+	}
+	return syms
+}
+
+// Format renders the instruction the way the assembler accepts it. Immediates
+// that point at a label are printed as that label, LDI only uses text labels
+// since small constants would otherwise collide with .data addresses.
+func (in Instruction) Format(syms Symbols) string {
+	op := in.Mnemonic()
+	switch in.Form {
+	case FormX:
+		return fmt.Sprintf("%s %s %s", op, RegisterName(in.Ra), RegisterName(in.Rb))
+	case FormY:
+		return fmt.Sprintf("%s %s", op, RegisterName(in.Ra))
+	case FormZJ:
+		return fmt.Sprintf("%s %03b, %s", op, in.Mask, in.operand(syms))
+	default:
+		return fmt.Sprintf("%s %s, %s", op, RegisterName(in.Ra), in.operand(syms))
+	}
+}
+
+func (in Instruction) String() string {
+	return in.Format(nil)
+}
+
+func (in Instruction) operand(syms Symbols) string {
+	if name, ok := syms[in.Imm]; ok {
+		if in.Opcode != OpLDI || in.Imm >= vm.TextStart {
+			return name
+		}
+	}
+	return fmt.Sprintf("0x%02X", in.Imm)
+}
+
+// Disassemble decodes text from start to end, base is the address of text[0]
+func Disassemble(text []byte, base uint8) ([]Instruction, error) {
+	var out []Instruction
+	for off := 0; off < len(text); {
+		in, err := Decode(text, off, base)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, in)
+		off += in.Size()
+	}
+	return out, nil
+}
+
+// TrimPadding drops the trailing zero words (MOV R0 R0) that fill the unused
+// part of a text section
+func TrimPadding(text []byte) []byte {
+	end := len(text)
+	for end > 0 && text[end-1] == 0 {
+		end--
+	}
+	return text[:end]
+}
+
+// Write prints a listing of text, one instruction per line with its address
+// and encoding, labels from syms are printed above the address they mark
+func Write(w io.Writer, text []byte, base uint8, syms Symbols) error {
+	instrs, err := Disassemble(text, base)
+	for _, in := range instrs {
+		if name, ok := syms[in.Addr]; ok {
+			fmt.Fprintf(w, "%s:\n", name)
+		}
+
+		var enc []string
+		for _, b := range in.Raw {
+			enc = append(enc, fmt.Sprintf("%02X", b))
+		}
+		fmt.Fprintf(
+			w,
+			"\t0x%02X:  %-6s  %s\n",
+			in.Addr,
+			strings.Join(enc, " "),
+			in.Format(syms),
+		)
+	}
+	return err
+}
+
+// Enclosing finds the closest text label at or before addr, used to describe
+// an address as label+offset
+func (syms Symbols) Enclosing(addr uint8) (string, uint8, bool) {
+	best := -1
+	for a := range syms {
+		if a < vm.TextStart || a > addr {
+			continue
+		}
+		if int(a) > best {
+			best = int(a)
+		}
+	}
+	if best == -1 {
+		return "", 0, false
+	}
+	return syms[uint8(best)], addr - uint8(best), true
+}
@@ -0,0 +1,81 @@
+package disasm
+
+import (
+	"strings"
+	"tcp-vm/shared/assembler"
+	"tcp-vm/shared/vm"
+	"testing"
+)
+
+func Test_Decode(t *testing.T) {
+	tests := []struct {
+		raw  []byte
+		want string
+	}{
+		{[]byte{0x01}, "MOV R0 R1"},
+		{[]byte{0x1E}, "CMP PC SP"},
+		{[]byte{0x75}, "ORR R1 R1"},
+		{[]byte{0x83}, "NOT PC"},
+		{[]byte{0x91}, "PSH R1"},
+		{[]byte{0xA3}, "POP PC"},
+		{[]byte{0xB0}, "SYS R0"},
+		{[]byte{0xC2, 0x51}, "JMP 010, 0x51"},
+		{[]byte{0xD4, 0xC8}, "LDI R1, 0xC8"},
+		{[]byte{0xE0, 0x00}, "LDA R0, 0x00"},
+		{[]byte{0xFC, 0xFF}, "STA PC, 0xFF"},
+	}
+
+	for _, tc := range tests {
+		in, err := Decode(tc.raw, 0, vm.TextStart)
+		if err != nil {
+			t.Fatalf("Decode(% X) failed: %v", tc.raw, err)
+		}
+		if got := in.String(); got != tc.want {
+			t.Fatalf("Decode(% X) = %q, want %q", tc.raw, got, tc.want)
+		}
+		if in.Size() != len(tc.raw) {
+			t.Fatalf("Decode(% X) size %d, want %d", tc.raw, in.Size(), len(tc.raw))
+		}
+	}
+
+	if _, err := Decode([]byte{0xD0}, 0, vm.TextStart); err == nil {
+		t.Fatalf("expected error for a truncated Z instruction")
+	}
+}
+
+func Test_roundTrip(t *testing.T) {
+	file := "../assembler/add_data.asm"
+	_, text, err := assembler.Assemble(file)
+	if err != nil {
+		t.Fatalf("Assemble(%s) failed: %v", file, err)
+	}
+	labels, err := assembler.Symbols(file)
+	if err != nil {
+		t.Fatalf("Symbols(%s) failed: %v", file, err)
+	}
+
+	var out strings.Builder
+	err = Write(&out, TrimPadding(text[:]), vm.TextStart, SymbolsFromLabels(labels))
+	if err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	t.Logf("listing:\n%s", out.String())
+
+	want := []string{
+		"main:",
+		"LDA R0, x",
+		"LDA R1, y",
+		"ADD R1 R0",
+		"PSH R1",
+		"LDI R0, 0x00",
+		"SYS R0",
+		"JMP 111, main",
+		"STA PC, 0xFF",
+	}
+	listing := out.String()
+	for _, line := range want {
+		if !strings.Contains(listing, line) {
+			t.Fatalf("listing is missing %q", line)
+		}
+	}
+}
@@ -8,6 +8,12 @@ const (
 	TextSectionLength = 175
 )
 
+const (
+	// extended memory pages selected with sys_bank, bank 0 is .data itself
+	BankCount  = 8
+	BankLength = DataSectionLength
+)
+
 const (
 	// Byte masks to check against Mem(79) / Process Flag
 	HaltFlag  = 0x80 // 0b 1000 0000
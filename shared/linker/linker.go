@@ -0,0 +1,204 @@
+package linker
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"tcp-vm/shared/assembler"
+	g "tcp-vm/shared/globals"
+)
+
+// placed is an object together with where its sections ended up
+type placed struct {
+	obj  *assembler.Object
+	data uint8 // address of the first data word
+	text uint8 // address of the first text word
+}
+
+// base is the address a section of the object starts at
+func (p placed) base(s assembler.SectionID) uint8 {
+	if s == assembler.TextSection {
+		return p.text
+	}
+	return p.data
+}
+
+func (p placed) addr(sym assembler.ObjectSymbol) uint8 {
+	return p.base(sym.Section) + sym.Offset
+}
+
+// Link puts objs together in order, the data and text of the first object come
+// first. Every import is resolved against the globals of the other objects and
+// every relocation is patched. Exactly one object has to have an entry point.
+func Link(objs ...*assembler.Object) (*assembler.Program, error) {
+	if len(objs) == 0 {
+		return nil, fmt.Errorf("nothing to link")
+	}
+
+	layout, err := lay(objs)
+	if err != nil {
+		return nil, err
+	}
+
+	// globals by name
+	globals := map[string]placed{}
+	for _, p := range layout {
+		for _, sym := range p.obj.Symbols {
+			if !sym.Global {
+				continue
+			}
+			if prev, dup := globals[sym.Name]; dup {
+				return nil, fmt.Errorf("'%s' is exported by both %s and %s", sym.Name, prev.obj.Name, p.obj.Name)
+			}
+			globals[sym.Name] = p
+		}
+	}
+
+	prog := &assembler.Program{
+		Symbols:   map[string]uint8{},
+		SourceMap: map[uint8]assembler.Position{},
+	}
+
+	var errs []error
+	for _, p := range layout {
+		copy(prog.Data[p.data-assembler.DataSection.Start():], p.obj.Data)
+		copy(prog.Text[p.text-assembler.TextSection.Start():], p.obj.Text)
+
+		for _, imp := range p.obj.Imports {
+			if _, ok := globals[imp]; !ok {
+				errs = append(errs, fmt.Errorf("%s: undefined symbol '%s', no object exports it", p.obj.Name, imp))
+			}
+		}
+
+		for _, r := range p.obj.Relocations {
+			var target uint8
+			switch r.Symbol {
+			case ".data":
+				target = p.data
+			case ".text":
+				target = p.text
+			default:
+				owner, ok := globals[r.Symbol]
+				if !ok {
+					// reported with the imports above
+					continue
+				}
+				sym, _ := owner.obj.Symbol(r.Symbol)
+				target = owner.addr(sym)
+			}
+
+			v := int(target) + r.Addend
+			if v < -128 || v > 255 {
+				errs = append(errs, fmt.Errorf("%s: value %d of '%s' at %s+%d does not fit in 8 bits (-128 to 255)",
+					p.obj.Name, v, r.Symbol, r.Section, r.Offset))
+				continue
+			}
+			at := p.base(r.Section) + r.Offset
+			if r.Section == assembler.TextSection {
+				prog.Text[at-assembler.TextSection.Start()] = uint8(v)
+			} else {
+				prog.Data[at-assembler.DataSection.Start()] = uint8(v)
+			}
+		}
+
+		for _, l := range p.obj.Lines {
+			prog.SourceMap[p.base(l.Section)+l.Offset] = l.Pos
+		}
+		prog.Warnings = append(prog.Warnings, p.obj.Warnings...)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	// globals keep their names, a local is only named when nothing else has
+	// taken the name
+	for name, p := range globals {
+		sym, _ := p.obj.Symbol(name)
+		prog.Symbols[name] = p.addr(sym)
+	}
+	for _, p := range layout {
+		for _, sym := range p.obj.Symbols {
+			if _, taken := prog.Symbols[sym.Name]; !taken {
+				prog.Symbols[sym.Name] = p.addr(sym)
+			}
+		}
+	}
+
+	entry, err := entryPoint(layout)
+	if err != nil {
+		return nil, err
+	}
+	prog.Entry = entry
+	return prog, nil
+}
+
+// lay places the sections of objs one after the other
+func lay(objs []*assembler.Object) ([]placed, error) {
+	var layout []placed
+	data := int(assembler.DataSection.Start())
+	text := int(assembler.TextSection.Start())
+	var dataSizes, textSizes []string
+	for _, obj := range objs {
+		layout = append(layout, placed{obj: obj, data: uint8(data), text: uint8(text)})
+		data += len(obj.Data)
+		text += len(obj.Text)
+		dataSizes = append(dataSizes, fmt.Sprintf("%s %d", obj.Name, len(obj.Data)))
+		textSizes = append(textSizes, fmt.Sprintf("%s %d", obj.Name, len(obj.Text)))
+	}
+
+	if used := data - int(assembler.DataSection.Start()); used > g.DataSectionLength {
+		return nil, fmt.Errorf("data section overflow: %d of %d words (%s)",
+			used, g.DataSectionLength, strings.Join(dataSizes, ", "))
+	}
+	if used := text - int(assembler.TextSection.Start()); used > g.TextSectionLength {
+		return nil, fmt.Errorf("text section overflow: %d of %d words (%s)",
+			used, g.TextSectionLength, strings.Join(textSizes, ", "))
+	}
+	return layout, nil
+}
+
+// entryPoint is the address of the one entry label among the objects
+func entryPoint(layout []placed) (uint8, error) {
+	var with []string
+	var entry uint8
+	for _, p := range layout {
+		if p.obj.Entry == "" {
+			continue
+		}
+		sym, ok := p.obj.Symbol(p.obj.Entry)
+		if !ok || sym.Section != assembler.TextSection {
+			return 0, fmt.Errorf("%s: entry '%s' is not a text label", p.obj.Name, p.obj.Entry)
+		}
+		entry = p.addr(sym)
+		with = append(with, fmt.Sprintf("%s (%s)", p.obj.Name, p.obj.Entry))
+	}
+	switch len(with) {
+	case 0:
+		return 0, fmt.Errorf("no entry point, no object has a main label or .entry")
+	case 1:
+		return entry, nil
+	}
+	sort.Strings(with)
+	return 0, fmt.Errorf("more than one entry point: %s", strings.Join(with, ", "))
+}
+
+// LinkFiles links the objects at paths, a `.o` file is read as an object and
+// anything else is assembled first
+func LinkFiles(paths []string, opts assembler.Options) (*assembler.Program, error) {
+	var objs []*assembler.Object
+	for _, path := range paths {
+		var obj *assembler.Object
+		var err error
+		if strings.HasSuffix(path, ".o") {
+			obj, err = assembler.ReadObjectFile(path)
+		} else {
+			obj, err = assembler.AssembleObjectFile(path, opts)
+		}
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, obj)
+	}
+	return Link(objs...)
+}
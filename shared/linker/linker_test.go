@@ -0,0 +1,129 @@
+package linker
+
+import (
+	"strings"
+	"tcp-vm/shared/assembler"
+	"tcp-vm/shared/vm"
+	"testing"
+)
+
+const mainSrc = `
+.extern double
+.global done
+.data
+	x = 0x05
+.text
+main:
+	LDA R1, x
+	JMPA double
+done:
+	PSH R1
+	LDI R0, 0x00
+	SYS R0
+`
+
+const libSrc = `
+.global double
+.global scale
+.extern done
+.data
+	scale = 0x03
+.text
+double:
+	LDA R0, scale
+	ADD R1 R0
+	JMPA done
+`
+
+func object(t *testing.T, name string, src string) *assembler.Object {
+	t.Helper()
+	obj, err := assembler.AssembleObjectString(name, src, assembler.Options{})
+	if err != nil {
+		t.Fatalf("AssembleObjectString(%s) failed: %v", name, err)
+	}
+	return obj
+}
+
+func Test_link(t *testing.T) {
+	prog, err := Link(object(t, "main.asm", mainSrc), object(t, "lib.asm", libSrc))
+	if err != nil {
+		t.Fatalf("Link() failed: %v", err)
+	}
+
+	// lib comes after main in both sections
+	if prog.Symbols["scale"] != 0x01 || prog.Symbols["double"] != vm.TextStart+9 {
+		t.Fatalf("scale at 0x%02X and double at 0x%02X, want 0x01 and 0x%02X",
+			prog.Symbols["scale"], prog.Symbols["double"], vm.TextStart+9)
+	}
+	if prog.Entry != vm.TextStart {
+		t.Fatalf("entry = 0x%02X, want 0x%02X", prog.Entry, vm.TextStart)
+	}
+	if pos := prog.SourceMap[prog.Symbols["double"]]; pos.File != "lib.asm" || pos.Line != 9 {
+		t.Fatalf("double maps to %v, want lib.asm:9", pos)
+	}
+
+	machine := new(vm.VirtualMachine)
+	machine.ResetFromStateless(prog.Data, prog.Text, prog.Entry)
+	if err := machine.RunUntilStop(); err != nil {
+		t.Fatalf("RunUntilStop() failed: %v", err)
+	}
+	if machine.R0 != 0x08 {
+		t.Fatalf("exit code = %d, want 8", machine.R0)
+	}
+}
+
+func Test_linkErrors(t *testing.T) {
+	exit := ".text\nmain:\n\tEXIT 0x00\n"
+	tests := []struct {
+		name string
+		objs []*assembler.Object
+		want string
+	}{
+		{
+			name: "undefined",
+			objs: []*assembler.Object{object(t, "main.asm", mainSrc)},
+			want: "main.asm: undefined symbol 'double'",
+		},
+		{
+			name: "exported twice",
+			objs: []*assembler.Object{
+				object(t, "main.asm", mainSrc),
+				object(t, "lib.asm", libSrc),
+				object(t, "copy.asm", ".global double\n.text\ndouble:\n\tEXIT 0x00\n"),
+			},
+			want: "'double' is exported by both lib.asm and copy.asm",
+		},
+		{
+			name: "two entries",
+			objs: []*assembler.Object{object(t, "a.asm", exit), object(t, "b.asm", exit)},
+			want: "more than one entry point: a.asm (main), b.asm (main)",
+		},
+		{
+			name: "no entry",
+			objs: []*assembler.Object{object(t, "lib.asm", ".text\nhelper:\n\tEXIT 0x00\n")},
+			want: "no entry point",
+		},
+		{
+			name: "data overflow",
+			objs: []*assembler.Object{
+				object(t, "a.asm", ".data\nbuf:\n\t.zero 10\n"+exit),
+				object(t, "b.asm", ".data\nmore:\n\t.zero 7\n"),
+			},
+			want: "data section overflow: 17 of 16 words (a.asm 10, b.asm 7)",
+		},
+		{
+			name: "text overflow",
+			objs: []*assembler.Object{
+				object(t, "a.asm", exit+"\t.zero 100\n"),
+				object(t, "b.asm", ".text\npad:\n\t.zero 70\n"),
+			},
+			want: "text section overflow: 176 of 175 words (a.asm 106, b.asm 70)",
+		},
+	}
+	for _, tt := range tests {
+		_, err := Link(tt.objs...)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Fatalf("%s: expected an error containing %q, got %v", tt.name, tt.want, err)
+		}
+	}
+}
@@ -55,9 +55,9 @@ func (c *Client) Do(packet Packet) (Packet, error) {
 	var restLength int
 	switch pt {
 	case Stateless:
-		restLength = 16 + 175
+		restLength = statelessLength - 1
 	case Stateful:
-		restLength = (1 * 4) + 16 + 64 + 1 + 175
+		restLength = statefulLength - 1
 	case Return:
 		// read the exit code
 		exit := make([]byte, 1)
@@ -82,5 +82,14 @@ func (c *Client) Do(packet Packet) (Packet, error) {
 	}
 	raw = append(header, rest...)
 
+	// stateless packets end with debug info of the length just read
+	if pt == Stateless {
+		debug := make([]byte, StatelessDebugLength(raw[len(raw)-2:]))
+		if _, err := io.ReadFull(c.conn, debug); err != nil {
+			return nil, err
+		}
+		raw = append(raw, debug...)
+	}
+
 	return ParsePacket(raw)
 }
@@ -1,6 +1,7 @@
 package ofstp
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 )
@@ -9,10 +10,18 @@ const (
 	dataSize      = 16
 	stackSize     = 64
 	flagSize      = 1
-	textSize      = 1
+	textSize      = 175
+	bankCount     = 8
+	bankSize      = bankCount * 16 // bank count * bank length
 	maxRetPayload = 1498
 )
 
+const (
+	textStart       = dataSize + stackSize + flagSize
+	statelessLength = 1 + dataSize + textSize + 1 + 2 // without debug info
+	statefulLength  = 1 + 4 + dataSize + stackSize + flagSize + textSize + 1 + bankSize
+)
+
 type PacketType byte
 
 const (
@@ -48,14 +57,19 @@ func MustMarshal(p Packet) []byte {
 	return buf
 }
 
-// stateless packet (1 + 16 + 175)
+// the most debug info a stateless packet can carry and still be read in one go
+const MaxDebugLength = maxPacketSize - statelessLength
+
+// stateless packet (1 + 16 + 175 + 1 + 2 + up to 1305)
 
 type StatelessPacket struct {
-	Data [dataSize]byte
-	Text [textSize]byte
+	Data  [dataSize]byte
+	Text  [textSize]byte
+	Entry byte   // address PC starts at
+	Debug []byte // encoded shared/debuginfo, may be empty
 }
 
-func NewStatelessPacket(data, text []byte) (*StatelessPacket, error) {
+func NewStatelessPacket(data, text []byte, entry byte) (*StatelessPacket, error) {
 	if len(data) != dataSize || len(text) != textSize {
 		return nil, fmt.Errorf(
 			"StatelessPacket got: len(data): %d, len(text): %d",
@@ -63,10 +77,14 @@ func NewStatelessPacket(data, text []byte) (*StatelessPacket, error) {
 			len(text),
 		)
 	}
+	if entry < textStart {
+		return nil, fmt.Errorf("StatelessPacket entry 0x%02X is not in .text", entry)
+	}
 
 	var p StatelessPacket
 	copy(p.Data[:], data)
 	copy(p.Text[:], text)
+	p.Entry = entry
 	return &p, nil
 }
 
@@ -75,14 +93,28 @@ func (p *StatelessPacket) Type() PacketType {
 }
 
 func (p *StatelessPacket) Marshal() ([]byte, error) {
-	buf := make([]byte, 1+16+175)
+	if len(p.Debug) > MaxDebugLength {
+		return nil, fmt.Errorf(
+			"StatelessPacket: debug info too large: len(debug): %d",
+			len(p.Debug),
+		)
+	}
+	buf := make([]byte, statelessLength, statelessLength+len(p.Debug))
 	buf[0] = byte(Stateless)
 	copy(buf[1:17], p.Data[:])
-	copy(buf[17:], p.Text[:])
-	return buf, nil
+	copy(buf[17:192], p.Text[:])
+	buf[192] = p.Entry
+	binary.BigEndian.PutUint16(buf[193:195], uint16(len(p.Debug)))
+	return append(buf, p.Debug...), nil
+}
+
+// StatelessDebugLength reads the length of the debug info from the two bytes
+// after the entry point, for readers that take the fixed part first
+func StatelessDebugLength(size []byte) int {
+	return int(binary.BigEndian.Uint16(size))
 }
 
-// stateful packet (1 + 1*4 + 16 + 64 + 1 + 175)
+// stateful packet (1 + 1*4 + 16 + 64 + 1 + 175 + 1 + 128)
 
 type StatefulPacket struct {
 	R0, R1, SP, PC byte
@@ -90,26 +122,37 @@ type StatefulPacket struct {
 	Stack          [stackSize]byte
 	Flag           byte
 	Text           [textSize]byte
+	Bank           byte
+	Banks          [bankSize]byte
 }
 
 func NewStatefulPacket(
 	r0, r1, sp, pc byte,
 	data []byte, stack []byte,
 	flag byte, text []byte,
+	bank byte, banks []byte,
 ) (*StatefulPacket, error) {
-	if len(data) != 16 || len(stack) != 64 || len(text) != 175 {
+	if len(data) != 16 || len(stack) != 64 || len(text) != 175 ||
+		len(banks) != bankSize {
 		return nil, fmt.Errorf(
-			"StatefulPacket got: len(data): %d, len(stack): %d, len(text): %d",
-			len(data), len(stack), len(text),
+			"StatefulPacket got: len(data): %d, len(stack): %d, len(text): %d, len(banks): %d",
+			len(data), len(stack), len(text), len(banks),
 		)
 	}
 
+	// bank 0 is the regular .data section, the rest index the banks
+	if bank > bankCount {
+		return nil, fmt.Errorf("StatefulPacket bank %d is not 0 to %d", bank, bankCount)
+	}
+
 	var p StatefulPacket
 	p.R0, p.R1, p.SP, p.PC = r0, r1, sp, pc
 	copy(p.Data[:], data)
 	copy(p.Stack[:], stack)
 	p.Flag = flag
 	copy(p.Text[:], text)
+	p.Bank = bank
+	copy(p.Banks[:], banks)
 	return &p, nil
 }
 
@@ -118,7 +161,7 @@ func (p *StatefulPacket) Type() PacketType {
 }
 
 func (p *StatefulPacket) Marshal() ([]byte, error) {
-	buf := make([]byte, 1+4+16+64+1+175)
+	buf := make([]byte, statefulLength)
 	i := 0
 	buf[i] = byte(Stateful)
 	i++
@@ -130,7 +173,11 @@ func (p *StatefulPacket) Marshal() ([]byte, error) {
 	i += 64
 	buf[i] = p.Flag
 	i++
-	copy(buf[i:], p.Text[:])
+	copy(buf[i:i+175], p.Text[:])
+	i += 175
+	buf[i] = p.Bank
+	i++
+	copy(buf[i:], p.Banks[:])
 	return buf, nil
 }
 
@@ -176,17 +223,25 @@ func ParsePacket(raw []byte) (Packet, error) {
 
 	switch PacketType(raw[0]) {
 	case Stateless:
-		if len(raw) != 192 {
+		if len(raw) < statelessLength ||
+			len(raw) != statelessLength+StatelessDebugLength(raw[193:195]) {
 			return nil, fmt.Errorf(
 				"invalid Stateless length: %d",
 				len(raw),
 			)
 		}
 		data := raw[1:17]
-		text := raw[17:]
-		return NewStatelessPacket(data, text)
+		text := raw[17:192]
+		p, err := NewStatelessPacket(data, text, raw[192])
+		if err != nil {
+			return nil, err
+		}
+		if len(raw) > statelessLength {
+			p.Debug = append([]byte(nil), raw[statelessLength:]...)
+		}
+		return p, nil
 	case Stateful:
-		expect := 1 + 4 + 16 + 64 + 1 + 175
+		expect := statefulLength
 		if len(raw) != expect {
 			return nil, fmt.Errorf(
 				"invalid Stateful length: %d != %d",
@@ -198,9 +253,11 @@ func ParsePacket(raw []byte) (Packet, error) {
 		data := raw[5:21]
 		stack := raw[21:85]
 		flag := raw[85]
-		text := raw[86:]
+		text := raw[86:261]
+		bank := raw[261]
+		banks := raw[262:]
 		return NewStatefulPacket(
-			r0, r1, sp, pc, data, stack, flag, text,
+			r0, r1, sp, pc, data, stack, flag, text, bank, banks,
 		)
 	case Return:
 		if len(raw) < 2 {
@@ -224,4 +281,5 @@ const (
 	NotBusyCode        = 0x21
 	AskStatelessCode   = 0x22
 	AskOutputCode      = 0x23
+	CoreDumpCode       = 0x30 // output holds a core file, see shared/core
 )
@@ -0,0 +1,22 @@
+package ofstp
+
+import "testing"
+
+func Test_parseStatefulBank(t *testing.T) {
+	p, err := NewStatefulPacket(0, 0, 0, 0,
+		make([]byte, dataSize), make([]byte, stackSize), 0, make([]byte, textSize),
+		bankCount, make([]byte, bankSize))
+	if err != nil {
+		t.Fatalf("NewStatefulPacket() failed: %v", err)
+	}
+	raw := MustMarshal(p)
+	if _, err := ParsePacket(raw); err != nil {
+		t.Fatalf("ParsePacket() failed for bank %d: %v", bankCount, err)
+	}
+
+	// a bank past the last one would index outside vm.Banks
+	raw[statefulLength-bankSize-1] = bankCount + 1
+	if _, err := ParsePacket(raw); err == nil {
+		t.Fatalf("expected bank %d to be rejected", bankCount+1)
+	}
+}
@@ -0,0 +1,412 @@
+package verify
+
+import (
+	"fmt"
+	"sort"
+	"tcp-vm/shared/disasm"
+	g "tcp-vm/shared/globals"
+	"tcp-vm/shared/util"
+	"tcp-vm/shared/vm"
+)
+
+const FILE_LOG_TAG = "tcp-vm/shared/verify - verify.go"
+
+// stack depth cannot be followed once SP is written directly
+const unknownDepth = -1
+
+type Severity int
+
+const (
+	Warning Severity = iota
+	Error
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Warning:
+		return "warning"
+	case Error:
+		return "error"
+	}
+	return "impossible"
+}
+
+type Finding struct {
+	Addr     uint8
+	Severity Severity
+	Message  string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s at 0x%02X: %s", f.Severity, f.Addr, f.Message)
+}
+
+type Report []Finding
+
+func (r Report) HasErrors() bool {
+	for _, f := range r {
+		if f.Severity == Error {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Report) Errors() Report {
+	var out Report
+	for _, f := range r {
+		if f.Severity == Error {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func (r Report) Warnings() Report {
+	var out Report
+	for _, f := range r {
+		if f.Severity == Warning {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// flag values are tracked as a bitmap over the 8 possible values of the low
+// three flag bits (the only bits JMP masks can test)
+type flagSet uint8
+
+const (
+	flagLT = 0b100
+	flagEQ = 0b010
+	flagGT = 0b001
+)
+
+const allFlags flagSet = 0xFF
+
+func flagOf(v byte) flagSet {
+	return 1 << (v & 0x07)
+}
+
+// can a jump with mask be taken / fall through under any flag value in fs
+func (fs flagSet) jumps(mask byte) (taken bool, fallthru bool) {
+	for v := byte(0); v < 8; v++ {
+		if fs&flagOf(v) == 0 {
+			continue
+		}
+		if v&mask != 0 {
+			taken = true
+		} else {
+			fallthru = true
+		}
+	}
+	return taken, fallthru
+}
+
+type constReg struct {
+	known bool
+	val   byte
+}
+
+type state struct {
+	flags flagSet
+	regs  [2]constReg
+	// the path went through a branch whose outcome depends on data
+	maybe bool
+}
+
+func (s state) merge(o state) (state, bool) {
+	out := s
+	out.flags |= o.flags
+	out.maybe = out.maybe || o.maybe
+	for i := range out.regs {
+		if out.regs[i] != o.regs[i] {
+			out.regs[i] = constReg{}
+		}
+	}
+	return out, out != s
+}
+
+type key struct {
+	pc    int
+	depth int
+}
+
+type verifier struct {
+	mem      vm.Memory
+	states   map[key]state
+	depths   map[int]map[int]bool
+	work     []key
+	findings map[Finding]bool
+}
+
+func (v *verifier) report(addr int, sev Severity, format string, args ...any) {
+	v.findings[Finding{
+		Addr:     uint8(addr),
+		Severity: sev,
+		Message:  fmt.Sprintf(format, args...),
+	}] = true
+}
+
+// stack faults on a data dependent path might never happen (a loop counter
+// the verifier cannot see), so they are only warnings there
+func (v *verifier) reportPath(addr int, st state, format string, args ...any) {
+	if st.maybe {
+		v.report(addr, Warning, "may "+format, args...)
+		return
+	}
+	v.report(addr, Error, format, args...)
+}
+
+func (v *verifier) visit(pc int, depth int, st state) {
+	if pc > vm.MemoryEnd {
+		return
+	}
+	if depth > g.StackLength {
+		depth = g.StackLength
+	}
+	k := key{pc: pc, depth: depth}
+	if prev, seen := v.states[k]; seen {
+		merged, changed := prev.merge(st)
+		if !changed {
+			return
+		}
+		st = merged
+	}
+	v.states[k] = st
+	if v.depths[pc] == nil {
+		v.depths[pc] = map[int]bool{}
+	}
+	v.depths[pc][depth] = true
+	v.work = append(v.work, k)
+}
+
+// Verify analyses an assembled .text section without running it. Execution is
+// followed from entry along every path the flag and constant registers allow.
+func Verify(text [g.TextSectionLength]byte, entry uint8) Report {
+	logTag := fmt.Sprintf("%s - Verify()", FILE_LOG_TAG)
+	util.LogStart(logTag)
+	defer util.LogEnd(logTag)
+
+	v := &verifier{
+		states:   map[key]state{},
+		depths:   map[int]map[int]bool{},
+		findings: map[Finding]bool{},
+	}
+	copy(v.mem[vm.TextStart:], text[:])
+
+	if entry < vm.TextStart {
+		v.report(int(entry), Error, "entry point is in %s, not .text", region(entry))
+	} else {
+		v.visit(int(entry), 0, state{flags: flagOf(0)})
+	}
+	for len(v.work) > 0 {
+		k := v.work[len(v.work)-1]
+		v.work = v.work[:len(v.work)-1]
+		v.step(k.pc, k.depth, v.states[k])
+	}
+
+	for pc, depths := range v.depths {
+		if len(depths) < 2 || depths[unknownDepth] {
+			continue
+		}
+		var seen []int
+		for d := range depths {
+			seen = append(seen, d)
+		}
+		sort.Ints(seen)
+		v.report(pc, Warning, "stack depth differs between paths: %v", seen)
+	}
+
+	var out Report
+	for f := range v.findings {
+		out = append(out, f)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Addr != out[j].Addr {
+			return out[i].Addr < out[j].Addr
+		}
+		return out[i].Message < out[j].Message
+	})
+	return out
+}
+
+func (v *verifier) step(pc int, depth int, st state) {
+	in, err := disasm.Decode(v.mem[:], pc, 0)
+	if err != nil {
+		v.report(pc, Error, "operand runs off the end of .text")
+		return
+	}
+	next := pc + in.Size()
+
+	// any write to R0/R1 we cannot follow makes it unknown
+	clobber := func(r byte) {
+		if r == disasm.RegR0 || r == disasm.RegR1 {
+			st.regs[r] = constReg{}
+		}
+	}
+
+	switch in.Opcode {
+	case disasm.OpMOV, disasm.OpSHL, disasm.OpSHR, disasm.OpADD, disasm.OpSUB, disasm.OpAND, disasm.OpORR:
+		if in.Ra == disasm.RegPC {
+			v.report(pc, Warning, "%s writes to PC, control flow cannot be followed", in.Mnemonic())
+			return
+		}
+		if in.Ra == disasm.RegSP {
+			depth = unknownDepth
+		}
+		if in.Opcode == disasm.OpMOV && in.Ra <= disasm.RegR1 && in.Rb <= disasm.RegR1 {
+			st.regs[in.Ra] = st.regs[in.Rb]
+		} else {
+			clobber(in.Ra)
+		}
+
+	case disasm.OpCMP:
+		switch {
+		case in.Ra == in.Rb:
+			st.flags = flagOf(flagEQ)
+		case in.Ra <= disasm.RegR1 && in.Rb <= disasm.RegR1 &&
+			st.regs[in.Ra].known && st.regs[in.Rb].known:
+			a, b := st.regs[in.Ra].val, st.regs[in.Rb].val
+			switch {
+			case a < b:
+				st.flags = flagOf(flagLT)
+			case a == b:
+				st.flags = flagOf(flagEQ)
+			default:
+				st.flags = flagOf(flagGT)
+			}
+		default:
+			st.flags = flagOf(flagLT) | flagOf(flagEQ) | flagOf(flagGT)
+		}
+
+	case disasm.OpNOT:
+		if in.Ra == disasm.RegPC {
+			v.report(pc, Warning, "NOT writes to PC, control flow cannot be followed")
+			return
+		}
+		if in.Ra == disasm.RegSP {
+			depth = unknownDepth
+		}
+		clobber(in.Ra)
+
+	case disasm.OpPSH:
+		if depth != unknownDepth {
+			if depth >= g.StackLength {
+				v.reportPath(pc, st, "PSH overflows the stack")
+				return
+			}
+			depth++
+		}
+
+	case disasm.OpPOP:
+		if depth != unknownDepth {
+			if depth == 0 {
+				v.reportPath(pc, st, "POP %s with an empty stack", disasm.RegisterName(in.Ra))
+				return
+			}
+			depth--
+		}
+		if in.Ra == disasm.RegPC {
+			// return through a pushed address, the caller is followed
+			// separately
+			return
+		}
+		if in.Ra == disasm.RegSP {
+			depth = unknownDepth
+		}
+		clobber(in.Ra)
+
+	case disasm.OpSYS:
+		if depth != unknownDepth {
+			if depth == 0 {
+				v.reportPath(pc, st, "SYS with no argument on the stack")
+				return
+			}
+			depth--
+		}
+		if in.Ra > disasm.RegR1 || !st.regs[in.Ra].known {
+			// could be any syscall, assume it returns
+			st.flags = allFlags
+			st.regs[disasm.RegR0] = constReg{}
+			break
+		}
+		num := st.regs[in.Ra].val
+		switch num {
+		case vm.SysExit:
+			return
+		case vm.SysSleep:
+			st.flags = flagOf(g.SleepFlag)
+			st.regs[disasm.RegR0] = constReg{}
+		case vm.SysBank:
+		default:
+			v.report(pc, Warning, "unknown syscall %d loaded into %s", num, disasm.RegisterName(in.Ra))
+			return
+		}
+
+	case disasm.OpJMP:
+		if in.Imm < vm.TextStart {
+			v.report(pc, Error, "JMP to 0x%02X lands in %s", in.Imm, region(in.Imm))
+			return
+		}
+		taken, fallthru := st.flags.jumps(in.Mask)
+		if taken && fallthru {
+			st.maybe = true
+		}
+		if taken {
+			v.visit(int(in.Imm), depth, st)
+		}
+		if !fallthru {
+			return
+		}
+
+	case disasm.OpLDI:
+		if in.Ra == disasm.RegPC {
+			v.report(pc, Warning, "LDI writes to PC, use JMP instead")
+			if in.Imm < vm.TextStart {
+				v.report(pc, Error, "LDI PC to 0x%02X lands in %s", in.Imm, region(in.Imm))
+				return
+			}
+			v.visit(int(in.Imm), depth, st)
+			return
+		}
+		if in.Ra == disasm.RegSP {
+			depth = unknownDepth
+		} else {
+			st.regs[in.Ra] = constReg{known: true, val: in.Imm}
+		}
+
+	case disasm.OpLDA:
+		if in.Ra == disasm.RegPC {
+			v.report(pc, Warning, "LDA writes to PC, control flow cannot be followed")
+			return
+		}
+		if in.Ra == disasm.RegSP {
+			depth = unknownDepth
+		}
+		clobber(in.Ra)
+
+	case disasm.OpSTA:
+		if int(in.Imm) == vm.FlagStart {
+			st.flags = allFlags
+		}
+	}
+
+	if next > vm.MemoryEnd {
+		v.report(pc, Error, "execution runs off the end of .text")
+		return
+	}
+	v.visit(next, depth, st)
+}
+
+func region(addr byte) string {
+	switch {
+	case addr < vm.StackStart:
+		return ".data"
+	case addr < vm.FlagStart:
+		return "the stack"
+	case addr < vm.TextStart:
+		return "the process flag"
+	}
+	return ".text"
+}
@@ -0,0 +1,120 @@
+package verify
+
+import (
+	"strings"
+	"tcp-vm/shared/assembler"
+	g "tcp-vm/shared/globals"
+	"tcp-vm/shared/vm"
+	"testing"
+)
+
+func Test_verifyExamples(t *testing.T) {
+	files := []string{
+		"../assembler/add_data.asm",
+		"../assembler/add_text.asm",
+		"../../CompilersFinal/adder.asm",
+		"../../CompilersFinal/negative.asm",
+	}
+
+	for _, file := range files {
+		prog, err := assembler.AssembleFile(file, assembler.Options{})
+		if err != nil {
+			t.Fatalf("AssembleFile(%s) failed: %v", file, err)
+		}
+		report := Verify(prog.Text, prog.Entry)
+		for _, f := range report {
+			t.Logf("%s: %v", file, f)
+		}
+		if report.HasErrors() {
+			t.Fatalf("%s: unexpected errors: %v", file, report.Errors())
+		}
+	}
+}
+
+func Test_verifyFindings(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  []byte
+		entry uint8
+		want  string
+	}{
+		{
+			name: "jump into data",
+			text: []byte{0x10, 0xC2, 0x03}, // CMP R0 R0, JMP 010, 0x03
+			want: "lands in .data",
+		},
+		{
+			name: "run off the end",
+			text: []byte{0x41}, // ADD R0 R1, then zero padding
+			want: "runs off the end",
+		},
+		{
+			name: "write to PC",
+			text: []byte{0x0C}, // MOV PC R0
+			want: "writes to PC",
+		},
+		{
+			name: "pop empty stack",
+			text: []byte{0xA0}, // POP R0
+			want: "empty stack",
+		},
+		{
+			name: "unknown syscall",
+			text: []byte{0x90, 0xD0, 0x09, 0xB0}, // PSH R0, LDI R0, 0x09, SYS R0
+			want: "unknown syscall 9",
+		},
+		{
+			name: "stack imbalance",
+			text: []byte{
+				0xC4, 0x54, // JMP 100, 0x54 (flag is 0, never taken)
+				0x11,       //       CMP R0 R1 (unknown outcome)
+				0xC2, 0x58, // JMP 010, 0x58
+				0x90,       //       PSH R0
+				0x90,       //       0x57: PSH R0
+				0xD0, 0x00, // 0x58: LDI R0, 0x00
+				0xB0, //       SYS R0
+			},
+			want: "stack depth differs",
+		},
+		{
+			name:  "entry outside of text",
+			text:  []byte{0xD0, 0x00, 0x90, 0xB0},
+			entry: 0x10,
+			want:  "entry point is in the stack",
+		},
+		{
+			name: "helper above the entry point",
+			text: []byte{
+				0xA0,       // 0x51: POP R0, only reached through the entry
+				0xD0, 0x00, // 0x52: LDI R0, 0x00
+				0x90, // 0x54: PSH R0
+				0xB0, // 0x55: SYS R0
+			},
+			entry: 0x52,
+		},
+	}
+
+	for _, tc := range tests {
+		var text [g.TextSectionLength]byte
+		copy(text[:], tc.text)
+		if tc.entry == 0 {
+			tc.entry = vm.TextStart
+		}
+		report := Verify(text, tc.entry)
+		if tc.want == "" {
+			if len(report) != 0 {
+				t.Fatalf("%s: expected no findings, got %v", tc.name, report)
+			}
+			continue
+		}
+		found := false
+		for _, f := range report {
+			if strings.Contains(f.Message, tc.want) {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("%s: expected finding containing %q, got %v", tc.name, tc.want, report)
+		}
+	}
+}
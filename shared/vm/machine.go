@@ -2,8 +2,10 @@ package vm
 
 import (
 	"fmt"
+	"tcp-vm/shared/clock"
 	g "tcp-vm/shared/globals"
 	"tcp-vm/shared/util"
+	"time"
 )
 
 const FILE_LOG_TAG = "tcp-vm/shared/vm/vm.go"
@@ -42,6 +44,15 @@ const (
 	vmTextEnd   = vmTextStart + vmTextCount - 1
 )
 
+// extended memory: LDA/STA inside the window are routed to the selected bank
+
+const (
+	vmBankCount   = g.BankCount
+	vmBankSize    = g.BankLength
+	vmWindowStart = vmDataStart
+	vmWindowEnd   = vmWindowStart + vmBankSize - 1
+)
+
 const (
 	MemoryStart = vmDataStart
 	MemoryEnd   = vmTextEnd
@@ -54,12 +65,34 @@ const (
 	TextStart  = vmTextStart
 )
 
+const (
+	WindowStart = vmWindowStart
+	WindowEnd   = vmWindowEnd
+)
+
+// syscalls, the number is held in the register passed to SYS
+
+const (
+	SysExit  = 0
+	SysSleep = 1
+	SysBank  = 2
+)
+
+var Syscalls = map[byte]string{
+	SysExit:  "sys_exit",
+	SysSleep: "sys_sleep",
+	SysBank:  "sys_bank",
+}
+
 // hardware
 
 type Register byte
 
 type Memory [vmMemSizeWords]byte
 
+// bank 1 lives at Banks[0], bank 0 is the regular .data section
+type Banks [vmBankCount][vmBankSize]byte
+
 // virtual machine
 
 type VirtualMachine struct {
@@ -68,17 +101,48 @@ type VirtualMachine struct {
 	SP     Register
 	PC     Register
 	Memory Memory
+	Bank   byte
+	Banks  Banks
 	Output string
+	Steps  int // instructions executed since the last reset
+	// when a sleeping program should be resumed, set by sys_sleep
+	WakeAt time.Time
+	// time source for sys_sleep, the wall clock when nil
+	Clock clock.Clock
+}
+
+func (vm *VirtualMachine) now() time.Time {
+	if vm.Clock == nil {
+		return time.Now()
+	}
+	return vm.Clock.Now()
+}
+
+// returned by RunUntilStop when the program does something the machine cannot
+// continue from
+type Fault struct {
+	PC     Register // address of the faulting instruction
+	Reason string
 }
 
+func (f *Fault) Error() string {
+	return f.Reason
+}
+
+func (vm *VirtualMachine) fault(pc Register, format string, args ...any) *Fault {
+	return &Fault{PC: pc, Reason: fmt.Sprintf(format, args...)}
+}
+
+// ResetFromStateless loads a fresh program, execution starts at entry
 func (vm *VirtualMachine) ResetFromStateless(
 	data [vmDataCount]byte,
 	text [vmTextCount]byte,
+	entry byte,
 ) {
 	vm.R0 = Register(0)
 	vm.R1 = Register(0)
 	vm.SP = Register(vmStackStart)
-	vm.PC = Register(vmTextStart)
+	vm.PC = Register(entry)
 
 	copy(vm.Memory[vmDataStart:vmDataEnd+1], data[:]) // end is exclusive
 
@@ -90,7 +154,12 @@ func (vm *VirtualMachine) ResetFromStateless(
 
 	copy(vm.Memory[vmTextStart:vmTextEnd+1], text[:]) // end is exclusive
 
+	vm.Bank = 0
+	vm.Banks = Banks{}
+
 	vm.Output = ""
+	vm.Steps = 0
+	vm.WakeAt = time.Time{}
 }
 
 func (vm *VirtualMachine) ResetFromStateful(
@@ -102,6 +171,8 @@ func (vm *VirtualMachine) ResetFromStateful(
 	stack [vmStackCount]byte,
 	flag [vmFlagCount]byte,
 	text [vmTextCount]byte,
+	bank byte,
+	banks [vmBankCount * vmBankSize]byte,
 ) {
 	vm.R0 = Register(r0)
 	vm.R1 = Register(r1)
@@ -113,13 +184,45 @@ func (vm *VirtualMachine) ResetFromStateful(
 	copy(vm.Memory[vmFlagStart:vmFlagEnd+1], flag[:])    // end is exclusive
 	copy(vm.Memory[vmTextStart:vmTextEnd+1], text[:])    // end is exclusive
 
+	vm.Bank = bank
+	for i := range vm.Banks {
+		copy(vm.Banks[i][:], banks[i*vmBankSize:(i+1)*vmBankSize])
+	}
+
 	vm.Output = ""
+	vm.Steps = 0
+	vm.WakeAt = time.Time{}
+}
+
+// flatten the extended memory pages, used when building stateful packets
+func (vm *VirtualMachine) BankBytes() []byte {
+	out := make([]byte, 0, vmBankCount*vmBankSize)
+	for _, page := range vm.Banks {
+		out = append(out, page[:]...)
+	}
+	return out
+}
+
+// memory access for LDA/STA, routes the window to the selected bank
+func (vm *VirtualMachine) load(addr byte) byte {
+	if vm.Bank != 0 && addr >= vmWindowStart && addr <= vmWindowEnd {
+		return vm.Banks[vm.Bank-1][addr-vmWindowStart]
+	}
+	return vm.Memory[addr]
+}
+
+func (vm *VirtualMachine) store(addr byte, val byte) {
+	if vm.Bank != 0 && addr >= vmWindowStart && addr <= vmWindowEnd {
+		vm.Banks[vm.Bank-1][addr-vmWindowStart] = val
+		return
+	}
+	vm.Memory[addr] = val
 }
 
 func (vm *VirtualMachine) String() string {
 	out := fmt.Sprintf(
-		"R0: %d, R1: %d, SP: %d, PC: %d\n",
-		vm.R0, vm.R1, vm.SP, vm.PC,
+		"R0: %d, R1: %d, SP: %d, PC: %d, Bank: %d\n",
+		vm.R0, vm.R1, vm.SP, vm.PC, vm.Bank,
 	)
 
 	for idx, byt := range vm.Memory {
@@ -194,8 +297,10 @@ func (vm *VirtualMachine) RunUntilStop() error {
 	}
 
 	for stepCount := 0; stepCount <= MaxStepsPerRun; stepCount++ {
+		instAddr := vm.PC
 		current := vm.Memory[vm.PC]
 		vm.PC++
+		vm.Steps++
 
 		top2 := (current >> 6) & BottomTwoMask
 		middleTop2 := (current >> 4) & BottomTwoMask
@@ -260,14 +365,14 @@ func (vm *VirtualMachine) RunUntilStop() error {
 				*ra = ^(*ra)
 			case PSH:
 				if vm.SP < vmStackStart || vm.SP > vmStackEnd {
-					return fmt.Errorf("segfault on PSH: stack out of bounds")
+					return vm.fault(instAddr, "segfault on PSH: stack out of bounds")
 				}
 
 				vm.Memory[vm.SP] = byte(*ra)
 				vm.SP++ // grow stack down
 			case POP:
 				if vm.SP <= vmStackStart {
-					return fmt.Errorf("segfault on POP: underflow")
+					return vm.fault(instAddr, "segfault on POP: underflow")
 				}
 
 				vm.SP-- // shrink stack up
@@ -281,20 +386,29 @@ func (vm *VirtualMachine) RunUntilStop() error {
 				callNum := byte(*ra)
 				// argument on top of stack
 				if vm.SP <= vmStackStart {
-					return fmt.Errorf("segfault on SYS arg pop")
+					return vm.fault(instAddr, "segfault on SYS arg pop")
 				}
 				vm.SP--
 				arg := vm.Memory[vm.SP]
 
 				// TODO: note somewhere in docs that syscall will erase the process flag
 				switch callNum {
-				case 0: // sys_exit
+				case SysExit:
 					vm.R0 = Register(arg)
 					vm.Memory[vmFlagStart] = g.HaltFlag
 
-				case 1: // sys_sleep
+				case SysSleep:
 					vm.R0 = Register(arg)
 					vm.Memory[vmFlagStart] = g.SleepFlag
+					vm.WakeAt = vm.now().Add(time.Duration(arg) * time.Second)
+
+				case SysBank:
+					if arg > vmBankCount {
+						return vm.fault(instAddr, "segfault on SYS bank select: no bank %d", arg)
+					}
+					vm.Bank = arg
+					// selecting a bank does not stop the program
+					continue
 
 				default:
 					// unknown syscall exit 255 + message
@@ -303,7 +417,7 @@ func (vm *VirtualMachine) RunUntilStop() error {
 					vm.Memory[vmFlagStart] = g.HaltFlag
 					vm.Output = fmt.Sprintf("unknown system call (%d)", callNum)
 				}
-				// in all cases, we stop execution here
+				// every other syscall stops execution here
 				return nil
 			}
 
@@ -321,12 +435,12 @@ func (vm *VirtualMachine) RunUntilStop() error {
 			case LDI:
 				*ra = Register(imm)
 			case LDA:
-				*ra = Register(vm.Memory[imm])
+				*ra = Register(vm.load(imm))
 			case STA:
-				vm.Memory[imm] = byte(*ra)
+				vm.store(imm, byte(*ra))
 			}
 		}
 	}
 
-	return fmt.Errorf("program exceeded max number of steps: %d", MaxStepsPerRun)
+	return vm.fault(vm.PC, "program exceeded max number of steps: %d", MaxStepsPerRun)
 }
@@ -1,7 +1,10 @@
 package vm
 
 import (
+	"tcp-vm/shared/clock"
+	g "tcp-vm/shared/globals"
 	"testing"
+	"time"
 )
 
 // TODO: write more complete memory partition test that checks each const
@@ -14,3 +17,83 @@ func Test_mamoryPartitioningValidation(t *testing.T) {
 		)
 	}
 }
+
+func Test_bankSwitching(t *testing.T) {
+	text := [vmTextCount]byte{
+		0xD0, 0x01, // LDI R0, 0x01
+		0x90,       // PSH R0
+		0xD0, 0x02, // LDI R0, 0x02 (sys_bank)
+		0xB0,       // SYS R0
+		0xD0, 0x2A, // LDI R0, 0x2A
+		0xF0, 0x03, // STA R0, 0x03
+		0xE4, 0x03, // LDA R1, 0x03
+		0x94,       // PSH R1
+		0xD0, 0x00, // LDI R0, 0x00 (sys_exit)
+		0xB0, // SYS R0
+	}
+
+	machine := new(VirtualMachine)
+	machine.ResetFromStateless([vmDataCount]byte{}, text, vmTextStart)
+	if err := machine.RunUntilStop(); err != nil {
+		t.Fatalf("RunUntilStop() failed: %v", err)
+	}
+
+	if machine.Bank != 1 {
+		t.Fatalf("expected bank 1 to be selected, got %d", machine.Bank)
+	}
+	if machine.Banks[0][3] != 0x2A {
+		t.Fatalf("expected bank 1 offset 3 to be 0x2A, got 0x%02X", machine.Banks[0][3])
+	}
+	if machine.Memory[vmDataStart+3] != 0 {
+		t.Fatalf("STA leaked into .data while bank 1 was selected")
+	}
+	if machine.R0 != 0x2A {
+		t.Fatalf("expected exit code 0x2A, got 0x%02X", machine.R0)
+	}
+}
+
+func Test_sleepUsesClock(t *testing.T) {
+	text := [vmTextCount]byte{
+		0xD0, 0x1E, // LDI R0, 0x1E (30 seconds)
+		0x90,       // PSH R0
+		0xD0, 0x01, // LDI R0, 0x01 (sys_sleep)
+		0xB0, // SYS R0
+	}
+
+	start := time.Unix(5000, 0)
+	machine := new(VirtualMachine)
+	machine.ResetFromStateless([vmDataCount]byte{}, text, vmTextStart)
+	machine.Clock = clock.NewSimulated(start)
+	if err := machine.RunUntilStop(); err != nil {
+		t.Fatalf("RunUntilStop() failed: %v", err)
+	}
+
+	if machine.Memory[vmFlagStart]&g.SleepFlag == 0 {
+		t.Fatalf("expected the sleep flag to be set")
+	}
+	if want := start.Add(30 * time.Second); !machine.WakeAt.Equal(want) {
+		t.Fatalf("expected wake at %v, got %v", want, machine.WakeAt)
+	}
+}
+
+func Test_entryPoint(t *testing.T) {
+	text := [vmTextCount]byte{
+		0xA0,       // helper: POP R0 (faults if run first)
+		0xD0, 0x05, // main: LDI R0, 0x05
+		0x90,       // PSH R0
+		0xD0, 0x00, // LDI R0, 0x00 (sys_exit)
+		0xB0, // SYS R0
+	}
+
+	machine := new(VirtualMachine)
+	machine.ResetFromStateless([vmDataCount]byte{}, text, vmTextStart+1)
+	if machine.PC != vmTextStart+1 {
+		t.Fatalf("expected PC to start at 0x%02X, got 0x%02X", vmTextStart+1, machine.PC)
+	}
+	if err := machine.RunUntilStop(); err != nil {
+		t.Fatalf("RunUntilStop() failed: %v", err)
+	}
+	if machine.R0 != 0x05 {
+		t.Fatalf("expected exit code 0x05, got 0x%02X", machine.R0)
+	}
+}